@@ -0,0 +1,162 @@
+package spiffesdk
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultAttestationTokenPath is where the projected service account token
+	// is mounted when AttestationConfig.TokenPath is empty
+	defaultAttestationTokenPath = "/var/run/secrets/tokens/spiffe-token"
+
+	// defaultAttestationAudience is the audience the token is requested for
+	// when AttestationConfig.Audience is empty
+	defaultAttestationAudience = "spiffe-headless-api"
+
+	// AttestationTypeK8sPSAT is the evidence type of projected service
+	// account tokens
+	AttestationTypeK8sPSAT = "k8s_psat"
+
+	// AttestationHeader carries the evidence, as base64url-encoded JSON
+	// AttestationEvidence, on headless API calls other than registration
+	AttestationHeader = "X-SPIFFE-Attestation"
+)
+
+// AttestationConfig makes registration carry evidence the headless API can
+// verify, instead of selectors the workload merely asserts about itself. The
+// evidence is a projected service account token, which the API checks with
+// a TokenReview, optionally with the pod UID and node name from the downward
+// API.
+type AttestationConfig struct {
+	// TokenPath is the projected service account token (default
+	// /var/run/secrets/tokens/spiffe-token). It is read on every
+	// registration, as the kubelet rotates it.
+	TokenPath string `json:"token_path"`
+
+	// Audience is the audience of the projected token (default
+	// spiffe-headless-api) and must match the volume's audience
+	Audience string `json:"audience"`
+
+	// PodUID (metadata.uid) and NodeName (spec.nodeName) from the downward API
+	PodUID   string `json:"pod_uid"`
+	NodeName string `json:"node_name"`
+
+	// DeriveSelectors asks the headless API to derive the selectors from the
+	// evidence. The SDK then sends no selectors of its own, so Namespace,
+	// ServiceAccount and PodLabels aren't needed.
+	DeriveSelectors bool `json:"derive_selectors"`
+}
+
+// AttestationEvidence is sent as "attestation" in registration requests and
+// in AttestationHeader on every headless API request
+type AttestationEvidence struct {
+	Type     string `json:"type"`
+	Token    string `json:"token"`
+	Audience string `json:"audience"`
+	PodUID   string `json:"pod_uid,omitempty"`
+	NodeName string `json:"node_name,omitempty"`
+}
+
+// evidence reads the projected token. It is checked for the audience and
+// expiry so misconfigured volumes fail with a clear error; its signature is
+// left to the headless API.
+func (a *AttestationConfig) evidence() (*AttestationEvidence, error) {
+	path := a.TokenPath
+	if path == "" {
+		path = defaultAttestationTokenPath
+	}
+	audience := a.Audience
+	if audience == "" {
+		audience = defaultAttestationAudience
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account token: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if err := checkServiceAccountToken(token, audience, time.Now()); err != nil {
+		return nil, fmt.Errorf("service account token %s: %w", path, err)
+	}
+
+	return &AttestationEvidence{
+		Type:     AttestationTypeK8sPSAT,
+		Token:    token,
+		Audience: audience,
+		PodUID:   a.PodUID,
+		NodeName: a.NodeName,
+	}, nil
+}
+
+// attest adds fresh evidence to a request to the headless API. Every call is
+// attested, so the server can tie all of them to the pod. The token is re-read
+// each time, as the kubelet rotates it.
+func (api *HeadlessAPI) attest(req *http.Request) error {
+	if api.Attestation == nil {
+		return nil
+	}
+	evidence, err := api.Attestation.evidence()
+	if err != nil {
+		return fmt.Errorf("attestation evidence unavailable: %w", err)
+	}
+	data, err := json.Marshal(evidence)
+	if err != nil {
+		return fmt.Errorf("failed to marshal attestation evidence: %w", err)
+	}
+	req.Header.Set(AttestationHeader, base64.RawURLEncoding.EncodeToString(data))
+	return nil
+}
+
+// checkServiceAccountToken checks the unverified claims of a projected token
+func checkServiceAccountToken(token, audience string, now time.Time) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("invalid JWT payload: %w", err)
+	}
+
+	var claims struct {
+		Audience json.RawMessage `json:"aud"`
+		Expiry   int64           `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return fmt.Errorf("invalid JWT claims: %w", err)
+	}
+
+	// "aud" is either a string or a list of strings
+	var audiences []string
+	if err := json.Unmarshal(claims.Audience, &audiences); err != nil {
+		var single string
+		if err := json.Unmarshal(claims.Audience, &single); err != nil {
+			return errors.New("token has no audience")
+		}
+		audiences = []string{single}
+	}
+	if !containsString(audiences, audience) {
+		return fmt.Errorf("audience is %v, not %q", audiences, audience)
+	}
+
+	if claims.Expiry != 0 && !now.Before(time.Unix(claims.Expiry, 0)) {
+		return fmt.Errorf("token expired at %v", time.Unix(claims.Expiry, 0))
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
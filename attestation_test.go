@@ -0,0 +1,178 @@
+package spiffesdk_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	spiffesdk "github.com/authsec-ai/spiffe-sdk"
+	"github.com/authsec-ai/spiffe-sdk/spiffetest"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+)
+
+var paymentsPod = spiffetest.Pod{Namespace: "payments", ServiceAccount: "payments", Name: "payments-0", UID: "3f0c"}
+
+func TestAttestationEvidenceOnEveryCall(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	headless := spiffetest.NewHeadlessServer(t, ca)
+	headless.RequireAttestation = true
+
+	attestation := &spiffesdk.AttestationConfig{
+		TokenPath: headless.ServiceAccountTokenFile(t, paymentsPod, spiffetest.DefaultAttestationAudience),
+		PodUID:    paymentsPod.UID,
+	}
+	config := &spiffesdk.Config{
+		SPIFFEID:       paymentID,
+		ServiceType:    "application",
+		Namespace:      "payments",
+		ServiceAccount: "payments",
+		HeadlessAPIURL: headless.URL,
+		Attestation:    attestation,
+	}
+	sdk, err := spiffesdk.FetchIdentity(context.Background(), config)
+	if err != nil {
+		t.Fatalf("FetchIdentity() error = %v", err)
+	}
+	t.Cleanup(func() { _ = sdk.Close(context.Background()) })
+
+	api := &spiffesdk.HeadlessAPI{BaseURL: headless.URL, HTTPClient: http.DefaultClient, Attestation: attestation}
+	if _, err := api.GetOrRefreshSVID(paymentID); err != nil {
+		t.Errorf("GetOrRefreshSVID() error = %v", err)
+	}
+	if _, err := api.IssueJWTSVID(context.Background(), paymentID, []string{"orders"}); err != nil {
+		t.Errorf("IssueJWTSVID() error = %v", err)
+	}
+	workloads, err := api.ListWorkloads(paymentID)
+	if err != nil || len(workloads) != 1 {
+		t.Fatalf("ListWorkloads() = %v, %v", workloads, err)
+	}
+	if _, err := api.GetWorkload(workloads[0].ID); err != nil {
+		t.Errorf("GetWorkload() error = %v", err)
+	}
+	td := spiffeid.RequireTrustDomainFromString("authsec.dev")
+	if _, err := api.FetchX509Bundle(td); err != nil {
+		t.Errorf("FetchX509Bundle() error = %v", err)
+	}
+	if _, err := api.FetchJWTBundle(td); err != nil {
+		t.Errorf("FetchJWTBundle() error = %v", err)
+	}
+	if _, err := api.FetchDenyList(); err != nil {
+		t.Errorf("FetchDenyList() error = %v", err)
+	}
+	cert, _ := marshalSVID(t, ca.CreateX509SVID(clientID, 0))
+	if _, err := api.VerifyCertificate(map[string]string{"certificate": cert}); err != nil {
+		t.Errorf("VerifyCertificate() error = %v", err)
+	}
+	if err := api.DeleteWorkload(workloads[0].ID); err != nil {
+		t.Errorf("DeleteWorkload() error = %v", err)
+	}
+
+	// Every request, registration included, carries the header
+	seen := map[spiffetest.Endpoint]bool{}
+	for _, request := range headless.Requests() {
+		seen[request.Endpoint] = true
+		if request.Header.Get(spiffesdk.AttestationHeader) == "" {
+			t.Errorf("%s %s sent no attestation evidence", request.Method, request.Path)
+		}
+	}
+	for _, endpoint := range []spiffetest.Endpoint{
+		spiffetest.EndpointRegister,
+		spiffetest.EndpointListWorkloads,
+		spiffetest.EndpointGetWorkload,
+		spiffetest.EndpointDeleteWorkload,
+		spiffetest.EndpointIssueSVID,
+		spiffetest.EndpointIssueJWTSVID,
+		spiffetest.EndpointJWTBundle,
+		spiffetest.EndpointX509Bundle,
+		spiffetest.EndpointDenyList,
+		spiffetest.EndpointVerify,
+	} {
+		if !seen[endpoint] {
+			t.Errorf("no %s request was made", endpoint)
+		}
+	}
+}
+
+func TestAttestationRequiredForIssuance(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	headless := spiffetest.NewHeadlessServer(t, ca)
+	headless.RequireAttestation = true
+	workloadID := headless.Register(paymentID, "k8s:ns:payments", "k8s:sa:payments")
+
+	otherPod := spiffetest.Pod{Namespace: "orders", ServiceAccount: "orders"}
+	forged := spiffetest.NewHeadlessServer(t, ca)
+
+	tests := []struct {
+		name        string
+		attestation *spiffesdk.AttestationConfig
+		wantList    bool // Listing and bundle fetches only need valid evidence
+	}{
+		{"no evidence", nil, false},
+		{"token from another issuer", &spiffesdk.AttestationConfig{
+			TokenPath: forged.ServiceAccountTokenFile(t, paymentsPod, spiffetest.DefaultAttestationAudience),
+		}, false},
+		{"another service account", &spiffesdk.AttestationConfig{
+			TokenPath: headless.ServiceAccountTokenFile(t, otherPod, spiffetest.DefaultAttestationAudience),
+		}, true},
+		{"pod UID does not match the token", &spiffesdk.AttestationConfig{
+			TokenPath: headless.ServiceAccountTokenFile(t, paymentsPod, spiffetest.DefaultAttestationAudience),
+			PodUID:    "9d2e",
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &spiffesdk.HeadlessAPI{BaseURL: headless.URL, HTTPClient: http.DefaultClient, Attestation: tt.attestation}
+
+			td := spiffeid.RequireTrustDomainFromString("authsec.dev")
+			unbound := map[string]func() error{
+				"ListWorkloads": func() error {
+					_, err := api.ListWorkloads(paymentID)
+					return err
+				},
+				"FetchX509Bundle": func() error {
+					_, err := api.FetchX509Bundle(td)
+					return err
+				},
+				"FetchJWTBundle": func() error {
+					_, err := api.FetchJWTBundle(td)
+					return err
+				},
+				"FetchDenyList": func() error {
+					_, err := api.FetchDenyList()
+					return err
+				},
+			}
+			for name, call := range unbound {
+				if err := call(); (err == nil) != tt.wantList {
+					t.Errorf("%s() error = %v, want success %v", name, err, tt.wantList)
+				}
+			}
+			calls := map[string]func() error{
+				"GetWorkload": func() error {
+					_, err := api.GetWorkload(workloadID)
+					return err
+				},
+				"IssueSVID": func() error {
+					_, err := api.IssueSVID(workloadID)
+					return err
+				},
+				"IssueJWTSVID": func() error {
+					_, err := api.IssueJWTSVID(context.Background(), paymentID, []string{"orders"})
+					return err
+				},
+				"DeleteWorkload": func() error {
+					return api.DeleteWorkload(workloadID)
+				},
+			}
+			for name, call := range calls {
+				err := call()
+				if err == nil {
+					t.Errorf("%s() succeeded", name)
+				} else if !strings.Contains(err.Error(), "403") && !strings.Contains(err.Error(), "attestation") {
+					t.Errorf("%s() error = %v, want an attestation failure", name, err)
+				}
+			}
+		})
+	}
+}
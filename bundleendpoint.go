@@ -0,0 +1,200 @@
+package spiffesdk
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/spiffebundle"
+	"github.com/spiffe/go-spiffe/v2/federation"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
+)
+
+// defaultBundleRefreshHint is the spiffe_refresh_hint advertised to partners
+const defaultBundleRefreshHint = 5 * time.Minute
+
+// BundleEndpointOption configures the bundle endpoint handler and server
+type BundleEndpointOption func(*bundleEndpointOptions)
+
+type bundleEndpointOptions struct {
+	refreshHint     time.Duration
+	certFile        string
+	keyFile         string
+	shutdownTimeout time.Duration
+}
+
+// WithBundleRefreshHint sets the spiffe_refresh_hint served with the bundle
+func WithBundleRefreshHint(refreshHint time.Duration) BundleEndpointOption {
+	return func(o *bundleEndpointOptions) {
+		o.refreshHint = refreshHint
+	}
+}
+
+// WithWebPKICertificate serves the endpoint with the https_web profile using a
+// Web PKI certificate and key (e.g. issued by cert-manager). The files are
+// re-read when they change. Without it the https_spiffe profile is used and
+// the server presents the SDK's SVID.
+func WithWebPKICertificate(certFile, keyFile string) BundleEndpointOption {
+	return func(o *bundleEndpointOptions) {
+		o.certFile = certFile
+		o.keyFile = keyFile
+	}
+}
+
+// BundleEndpointHandler returns an http.Handler that serves the local trust
+// domain's bundle in SPIFFE bundle (JWKS) format, for partners that federate
+// with us. The bundle carries X.509 authorities and, when available, JWT
+// authorities, plus spiffe_refresh_hint and the trust bundle's sequence number
+// (see GetBundleStatus) as spiffe_sequence, which changes whenever an authority
+// is added or removed.
+//
+// The handler does not terminate TLS; mount it behind a server that implements
+// one of the bundle endpoint profiles, or use NewBundleEndpointServer.
+func (s *SpiffeSDK) BundleEndpointHandler(opts ...BundleEndpointOption) (http.Handler, error) {
+	options := s.bundleEndpointOptions(opts)
+
+	td, err := spiffeid.TrustDomainFromString(s.config.TrustDomain)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trust domain: %w", err)
+	}
+
+	source := &bundleEndpointSource{sdk: s, trustDomain: td, refreshHint: options.refreshHint}
+	return federation.NewHandler(td, source)
+}
+
+// NewBundleEndpointServer creates a server for BundleEndpointHandler. By
+// default it implements the https_spiffe profile, presenting the SDK's current
+// SVID without requiring client certificates; WithWebPKICertificate selects the
+// https_web profile. Like other SDK servers it is shut down when the SDK is
+// closed.
+func (s *SpiffeSDK) NewBundleEndpointServer(addr string, opts ...BundleEndpointOption) (*Server, error) {
+	options := s.bundleEndpointOptions(opts)
+
+	handler, err := s.BundleEndpointHandler(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	var tlsConfig *tls.Config
+	if options.certFile != "" {
+		cert := &webPKICertificate{certFile: options.certFile, keyFile: options.keyFile}
+		if _, err := cert.GetCertificate(nil); err != nil {
+			return nil, err
+		}
+		tlsConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: cert.GetCertificate,
+		}
+	} else {
+		if _, err := s.GetX509SVID(); err != nil {
+			return nil, fmt.Errorf("server identity unavailable: %w", err)
+		}
+		tlsConfig = tlsconfig.TLSServerConfig(s)
+	}
+
+	return &Server{
+		sdk: s,
+		httpServer: &http.Server{
+			Addr:      addr,
+			Handler:   handler,
+			TLSConfig: tlsConfig,
+		},
+		shutdownTimeout: options.shutdownTimeout,
+	}, nil
+}
+
+// ServeBundleEndpoint runs a bundle endpoint server until ctx is cancelled or
+// the SDK is closed
+func (s *SpiffeSDK) ServeBundleEndpoint(ctx context.Context, addr string, opts ...BundleEndpointOption) error {
+	srv, err := s.NewBundleEndpointServer(addr, opts...)
+	if err != nil {
+		return err
+	}
+	return s.runServer(ctx, srv)
+}
+
+func (s *SpiffeSDK) bundleEndpointOptions(opts []BundleEndpointOption) bundleEndpointOptions {
+	options := bundleEndpointOptions{
+		refreshHint:     defaultBundleRefreshHint,
+		shutdownTimeout: defaultShutdownTimeout,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+// bundleEndpointSource builds the served bundle from the SDK's current trust
+// material
+type bundleEndpointSource struct {
+	sdk         *SpiffeSDK
+	trustDomain spiffeid.TrustDomain
+	refreshHint time.Duration
+}
+
+func (b *bundleEndpointSource) GetBundleForTrustDomain(trustDomain spiffeid.TrustDomain) (*spiffebundle.Bundle, error) {
+	if trustDomain != b.trustDomain {
+		return nil, fmt.Errorf("no bundle for trust domain %q", trustDomain)
+	}
+
+	x509Bundle, sequence := b.sdk.trustBundle.snapshot(trustDomain)
+	if x509Bundle == nil {
+		// SDKs with an identity source don't track the bundle's changes
+		var err error
+		if x509Bundle, err = b.sdk.GetX509BundleForTrustDomain(trustDomain); err != nil {
+			return nil, err
+		}
+		sequence = newestNotBefore(x509Bundle.X509Authorities())
+	}
+	bundle := spiffebundle.FromX509Bundle(x509Bundle)
+
+	// JWT authorities are optional: not every deployment issues JWT-SVIDs
+	if jwtBundle, err := b.sdk.GetJWTBundleForTrustDomain(trustDomain); err == nil {
+		bundle.SetJWTAuthorities(jwtBundle.JWTAuthorities())
+	}
+
+	bundle.SetSequenceNumber(sequence)
+	bundle.SetRefreshHint(b.refreshHint)
+	return bundle, nil
+}
+
+// webPKICertificate serves a certificate from files, reloading it when the
+// files change so rotated certificates are picked up without a restart
+type webPKICertificate struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func (c *webPKICertificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info, err := os.Stat(c.certFile)
+	if err == nil && c.cert != nil && info.ModTime().Equal(c.modTime) {
+		return c.cert, nil
+	}
+
+	var cert tls.Certificate
+	if err == nil {
+		cert, err = tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	}
+	if err != nil {
+		if c.cert != nil {
+			// Files may be mid-update; keep serving the previous certificate
+			return c.cert, nil
+		}
+		return nil, fmt.Errorf("failed to load bundle endpoint certificate: %w", err)
+	}
+	c.cert = &cert
+	c.modTime = info.ModTime()
+	return c.cert, nil
+}
@@ -0,0 +1,206 @@
+package spiffesdk_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	spiffesdk "github.com/authsec-ai/spiffe-sdk"
+	"github.com/authsec-ai/spiffe-sdk/spiffetest"
+)
+
+// servedBundle is the JWKS document of a bundle endpoint
+type servedBundle struct {
+	Keys []struct {
+		Use string   `json:"use"`
+		Kty string   `json:"kty"`
+		Kid string   `json:"kid"`
+		X5c []string `json:"x5c"`
+	} `json:"keys"`
+	Sequence    uint64 `json:"spiffe_sequence"`
+	RefreshHint int64  `json:"spiffe_refresh_hint"`
+}
+
+// x509Authorities returns the DER of the served X.509 authorities
+func (b servedBundle) x509Authorities(t *testing.T) [][]byte {
+	t.Helper()
+	var authorities [][]byte
+	for _, key := range b.Keys {
+		if key.Use != "x509-svid" {
+			continue
+		}
+		if len(key.X5c) != 1 {
+			t.Fatalf("x509-svid key has %d certificates, want 1", len(key.X5c))
+		}
+		der, err := base64.StdEncoding.DecodeString(key.X5c[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		authorities = append(authorities, der)
+	}
+	return authorities
+}
+
+func TestBundleEndpointServesTrustBundle(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	newCA := spiffetest.NewCA(t, "authsec.dev")
+	headless := spiffetest.NewHeadlessServer(t, ca)
+	sdk := newHeadlessSDK(t, headless, func(config *spiffesdk.Config) {
+		config.BundleRefreshInterval = 10 * time.Millisecond
+		config.BundleOverlap = 200 * time.Millisecond
+	})
+	if err := sdk.Start(); err != nil {
+		t.Fatal(err)
+	}
+	handler, err := sdk.BundleEndpointHandler(spiffesdk.WithBundleRefreshHint(90 * time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetch := func() servedBundle {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != 200 {
+			t.Fatalf("bundle endpoint returned %d", w.Code)
+		}
+		var bundle servedBundle
+		if err := json.Unmarshal(w.Body.Bytes(), &bundle); err != nil {
+			t.Fatalf("bundle is not JSON: %v", err)
+		}
+		return bundle
+	}
+	waitFor := func(what string, authorities int) servedBundle {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if bundle := fetch(); len(bundle.x509Authorities(t)) == authorities {
+				return bundle
+			}
+		}
+		t.Fatalf("bundle endpoint never served %d authorities after %s", authorities, what)
+		return servedBundle{}
+	}
+
+	initial := fetch()
+	authorities := initial.x509Authorities(t)
+	if len(authorities) != 1 || string(authorities[0]) != string(ca.Certificate().Raw) {
+		t.Fatalf("served %d authorities, want the CA's certificate", len(authorities))
+	}
+	for _, key := range initial.Keys {
+		if key.Kty != "EC" {
+			t.Errorf("%s key has kty %q, want EC", key.Use, key.Kty)
+		}
+		if key.Use == "jwt-svid" && key.Kid == "" {
+			t.Error("jwt-svid key has no kid")
+		}
+	}
+	if initial.RefreshHint != 90 {
+		t.Errorf("spiffe_refresh_hint = %d, want 90", initial.RefreshHint)
+	}
+	if initial.Sequence == 0 {
+		t.Error("spiffe_sequence is not set")
+	}
+
+	// Rotation adds the new CA...
+	headless.SetBundleCAs(ca, newCA)
+	rotated := waitFor("adding a CA", 2)
+	if rotated.Sequence <= initial.Sequence {
+		t.Errorf("spiffe_sequence went from %d to %d when a CA was added", initial.Sequence, rotated.Sequence)
+	}
+
+	// ...and the old one is dropped once its overlap ends
+	headless.SetBundleCAs(newCA)
+	retired := waitFor("removing a CA", 1)
+	if got := retired.x509Authorities(t)[0]; string(got) != string(newCA.Certificate().Raw) {
+		t.Error("the new CA was removed instead of the old one")
+	}
+	if retired.Sequence <= rotated.Sequence {
+		t.Errorf("spiffe_sequence went from %d to %d when a CA was removed", rotated.Sequence, retired.Sequence)
+	}
+	if status := sdk.GetBundleStatus(); status.Sequence != retired.Sequence {
+		t.Errorf("GetBundleStatus().Sequence = %d, endpoint serves %d", status.Sequence, retired.Sequence)
+	}
+}
+
+func TestBundleEndpointReloadsWebPKICertificate(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	sdk := ca.NewSDK(t, paymentID)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeWebPKICertificate(t, certFile, keyFile, "bundles-1.authsec.dev")
+
+	addr := freeAddr(t)
+	srv, err := sdk.NewBundleEndpointServer(addr, spiffesdk.WithWebPKICertificate(certFile, keyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = srv.ListenAndServe() }()
+	waitForListener(t, addr)
+
+	servedName := func() string {
+		t.Helper()
+		conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}
+	if got := servedName(); got != "bundles-1.authsec.dev" {
+		t.Errorf("served certificate for %s, want bundles-1.authsec.dev", got)
+	}
+
+	// cert-manager renews the certificate in place
+	writeWebPKICertificate(t, certFile, keyFile, "bundles-2.authsec.dev")
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(certFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got := servedName(); got != "bundles-2.authsec.dev" {
+		t.Errorf("served certificate for %s after renewal, want bundles-2.authsec.dev", got)
+	}
+}
+
+// writeWebPKICertificate writes a self-signed server certificate for name
+func writeWebPKICertificate(t *testing.T, certFile, keyFile, name string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
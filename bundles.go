@@ -0,0 +1,357 @@
+package spiffesdk
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+)
+
+const (
+	// defaultBundleRefreshInterval is how often the local trust bundle is
+	// fetched from the headless API when Config.BundleRefreshInterval is zero
+	defaultBundleRefreshInterval = 5 * time.Minute
+
+	// defaultBundleOverlap is how long an authority that left the bundle is
+	// still trusted when Config.BundleOverlap is zero. It covers the lifetime
+	// of SVIDs the outgoing CA issued before the rotation.
+	defaultBundleOverlap = time.Hour
+)
+
+// trustBundle tracks the X.509 authorities of the local trust domain. Each
+// update replaces the published authorities, but an authority that is no
+// longer published keeps being trusted for an overlap period, so peers holding
+// SVIDs from an outgoing CA are accepted until they have rotated.
+type trustBundle struct {
+	mu          sync.RWMutex
+	trustDomain spiffeid.TrustDomain
+	published   []*x509.Certificate
+	retiring    map[*x509.Certificate]time.Time // Trusted until the given time
+	bundle      *x509bundle.Bundle              // published and retiring authorities
+	sequence    uint64
+	updatedAt   time.Time
+}
+
+// trustAnchorChange is an authority that started or stopped being trusted
+type trustAnchorChange struct {
+	authority *x509.Certificate
+	added     bool
+}
+
+func newTrustBundle() *trustBundle {
+	return &trustBundle{retiring: make(map[*x509.Certificate]time.Time)}
+}
+
+// update publishes bundle's authorities. Authorities missing from it are
+// retired for overlap. It returns the authorities that started or stopped
+// being trusted.
+func (t *trustBundle) update(bundle *x509bundle.Bundle, overlap time.Duration, now time.Time) []trustAnchorChange {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var changes []trustAnchorChange
+	if t.trustDomain != bundle.TrustDomain() {
+		// A different trust domain replaces everything
+		for _, authority := range t.trustedLocked() {
+			changes = append(changes, trustAnchorChange{authority: authority})
+		}
+		t.trustDomain = bundle.TrustDomain()
+		t.published = nil
+		t.retiring = make(map[*x509.Certificate]time.Time)
+	}
+
+	published := bundle.X509Authorities()
+	for _, authority := range published {
+		if containsCertificate(t.published, authority) {
+			continue
+		}
+		if retired := findCertificate(t.retiring, authority); retired != nil {
+			// Published again before the overlap ended
+			delete(t.retiring, retired)
+			continue
+		}
+		changes = append(changes, trustAnchorChange{authority: authority, added: true})
+	}
+	for _, authority := range t.published {
+		if !containsCertificate(published, authority) {
+			t.retiring[authority] = now.Add(overlap)
+		}
+	}
+	t.published = published
+	changes = append(changes, t.pruneLocked(now)...)
+
+	t.updatedAt = now
+	t.rebuildLocked(len(changes) > 0)
+	return changes
+}
+
+// prune stops trusting retired authorities whose overlap has ended or that
+// have expired
+func (t *trustBundle) prune(now time.Time) []trustAnchorChange {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	changes := t.pruneLocked(now)
+	if len(changes) > 0 {
+		t.rebuildLocked(true)
+	}
+	return changes
+}
+
+func (t *trustBundle) pruneLocked(now time.Time) []trustAnchorChange {
+	var changes []trustAnchorChange
+	for authority, until := range t.retiring {
+		if now.Before(until) && now.Before(authority.NotAfter) {
+			continue
+		}
+		delete(t.retiring, authority)
+		changes = append(changes, trustAnchorChange{authority: authority})
+	}
+	return changes
+}
+
+// rebuildLocked rebuilds the trusted bundle, advancing the sequence number if
+// the trusted authorities changed. The sequence never drops below the newest
+// authority's NotBefore, so replicas that load the same bundle agree on it and
+// a rotation moves it past values served before a restart.
+func (t *trustBundle) rebuildLocked(changed bool) {
+	trusted := t.trustedLocked()
+	if changed || t.bundle == nil {
+		t.sequence = max(t.sequence+1, newestNotBefore(trusted))
+	}
+	t.bundle = x509bundle.FromX509Authorities(t.trustDomain, trusted)
+}
+
+// trustedLocked returns the published and retiring authorities
+func (t *trustBundle) trustedLocked() []*x509.Certificate {
+	trusted := append([]*x509.Certificate(nil), t.published...)
+	for authority := range t.retiring {
+		trusted = append(trusted, authority)
+	}
+	return trusted
+}
+
+// get returns the trusted authorities of trustDomain, or nil if none are known
+func (t *trustBundle) get(trustDomain spiffeid.TrustDomain) *x509bundle.Bundle {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.bundle == nil || t.trustDomain != trustDomain {
+		return nil
+	}
+	return t.bundle
+}
+
+// snapshot returns the trusted authorities of trustDomain with their sequence
+// number, or nil if none are known
+func (t *trustBundle) snapshot(trustDomain spiffeid.TrustDomain) (*x509bundle.Bundle, uint64) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.bundle == nil || t.trustDomain != trustDomain {
+		return nil, 0
+	}
+	return t.bundle, t.sequence
+}
+
+// newestNotBefore returns the NotBefore (Unix seconds) of the newest authority
+func newestNotBefore(authorities []*x509.Certificate) uint64 {
+	var newest int64
+	for _, authority := range authorities {
+		if notBefore := authority.NotBefore.Unix(); notBefore > newest {
+			newest = notBefore
+		}
+	}
+	return uint64(newest)
+}
+
+// BundleStatus describes the local trust bundle
+type BundleStatus struct {
+	TrustDomain string
+	Sequence    uint64 // Increases whenever an authority is added or removed
+	UpdatedAt   time.Time
+
+	// Authorities are trusted now; Retiring are those among them that are no
+	// longer published and stop being trusted at the given time
+	Authorities []*x509.Certificate
+	Retiring    map[*x509.Certificate]time.Time
+}
+
+// GetBundleStatus returns the state of the local trust bundle. Its sequence
+// number is zero until the first bundle arrives; it is the same sequence that
+// the bundle endpoint serves, the "bundle_sequence" metric reports and trust
+// anchor events carry.
+func (s *SpiffeSDK) GetBundleStatus() BundleStatus {
+	t := s.trustBundle
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	status := BundleStatus{
+		TrustDomain: t.trustDomain.String(),
+		Sequence:    t.sequence,
+		UpdatedAt:   t.updatedAt,
+		Authorities: t.trustedLocked(),
+		Retiring:    make(map[*x509.Certificate]time.Time, len(t.retiring)),
+	}
+	for authority, until := range t.retiring {
+		status.Retiring[authority] = until
+	}
+	return status
+}
+
+// updateTrustBundle applies a local trust bundle delivered by origin
+func (s *SpiffeSDK) updateTrustBundle(bundle *x509bundle.Bundle, origin string) {
+	overlap := s.config.BundleOverlap
+	if overlap <= 0 {
+		overlap = defaultBundleOverlap
+	}
+	s.applyTrustAnchorChanges(s.trustBundle.update(bundle, overlap, time.Now()), origin)
+}
+
+// syncTrustBundle applies the bundle delivered with the current SVID by origin
+func (s *SpiffeSDK) syncTrustBundle(origin string) {
+	svid, err := s.currentSVID.x509SVID()
+	if err != nil {
+		return
+	}
+	bundle, err := s.currentSVID.x509Bundle(svid.ID.TrustDomain())
+	if err != nil {
+		s.logf("Ignoring trust bundle from %s: %v", origin, err)
+		return
+	}
+	s.updateTrustBundle(bundle, origin)
+}
+
+// applyTrustAnchorChanges reports changed authorities and rewrites the
+// bundle file
+func (s *SpiffeSDK) applyTrustAnchorChanges(changes []trustAnchorChange, origin string) {
+	if len(changes) == 0 {
+		return
+	}
+
+	status := s.GetBundleStatus()
+	bundleSequence.Set(int64(status.Sequence))
+	for _, change := range changes {
+		eventType, verb := EventTrustAnchorRemoved, "removed from"
+		if change.added {
+			eventType, verb = EventTrustAnchorAdded, "added to"
+		}
+		fingerprint := sha256.Sum256(change.authority.Raw)
+		s.emit(Event{
+			Type:    eventType,
+			Message: fmt.Sprintf("authority %s %s the %s bundle", change.authority.Subject, verb, status.TrustDomain),
+			Attributes: map[string]string{
+				"trust_domain": status.TrustDomain,
+				"subject":      change.authority.Subject.String(),
+				"sha256":       hex.EncodeToString(fingerprint[:]),
+				"sequence":     strconv.FormatUint(status.Sequence, 10),
+				"origin":       origin,
+			},
+		})
+	}
+	s.writeCertificateFiles()
+}
+
+// runBundleRefresher refreshes the local trust bundle from the headless API
+// on its own schedule, independently of SVID renewal, and ends the overlap of
+// retired authorities. While the Workload API is connected the agent streams
+// bundle updates instead.
+func (s *SpiffeSDK) runBundleRefresher() {
+	interval := s.config.BundleRefreshInterval
+	if interval <= 0 {
+		interval = defaultBundleRefreshInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if s.config.HeadlessAPIURL != "" && !s.hasWorkloadAPI() {
+			if err := s.refreshTrustBundle(); err != nil {
+				s.logf("Trust bundle refresh failed, keeping the current bundle: %v", err)
+			}
+		}
+		s.applyTrustAnchorChanges(s.trustBundle.prune(time.Now()), "overlap")
+	}
+}
+
+// refreshTrustBundle fetches the local trust bundle from the headless API
+func (s *SpiffeSDK) refreshTrustBundle() error {
+	trustDomain, err := spiffeid.TrustDomainFromString(s.config.TrustDomain)
+	if err != nil {
+		return fmt.Errorf("invalid trust domain %q: %w", s.config.TrustDomain, err)
+	}
+	bundle, err := s.headlessAPI.FetchX509Bundle(trustDomain)
+	if err != nil {
+		return err
+	}
+	s.updateTrustBundle(bundle, SVIDSourceHeadlessAPI)
+	return nil
+}
+
+// FetchX509Bundle fetches the trust domain's X.509 authorities (PEM)
+func (api *HeadlessAPI) FetchX509Bundle(trustDomain spiffeid.TrustDomain) (*x509bundle.Bundle, error) {
+	req, err := http.NewRequest("GET", api.BaseURL+"/spiresvc/api/v1/bundles/x509", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if err := api.attest(req); err != nil {
+		return nil, err
+	}
+
+	resp, err := api.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read X.509 bundle: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("X.509 bundle fetch failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	bundle, err := x509bundle.Parse(trustDomain, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse X.509 bundle: %w", err)
+	}
+	if len(bundle.X509Authorities()) == 0 {
+		return nil, fmt.Errorf("X.509 bundle for %s has no authorities", trustDomain)
+	}
+
+	return bundle, nil
+}
+
+func containsCertificate(certs []*x509.Certificate, cert *x509.Certificate) bool {
+	for _, c := range certs {
+		if c.Equal(cert) {
+			return true
+		}
+	}
+	return false
+}
+
+func findCertificate(certs map[*x509.Certificate]time.Time, cert *x509.Certificate) *x509.Certificate {
+	for c := range certs {
+		if c.Equal(cert) {
+			return c
+		}
+	}
+	return nil
+}
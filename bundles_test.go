@@ -0,0 +1,193 @@
+package spiffesdk_test
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"expvar"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	spiffesdk "github.com/authsec-ai/spiffe-sdk"
+	"github.com/authsec-ai/spiffe-sdk/spiffetest"
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+func TestTrustBundleRotation(t *testing.T) {
+	const overlap = time.Hour
+	oldCA := spiffetest.NewCA(t, "authsec.dev")
+	newCA := spiffetest.NewCA(t, "authsec.dev")
+	td := oldCA.TrustDomain()
+	oldSVID := oldCA.CreateX509SVID(paymentID, 0)
+	newSVID := newCA.CreateX509SVID(paymentID, 0)
+
+	published := func(cas ...*spiffetest.CA) *x509bundle.Bundle {
+		var authorities []*x509.Certificate
+		for _, ca := range cas {
+			authorities = append(authorities, ca.Certificate())
+		}
+		return x509bundle.FromX509Authorities(td, authorities)
+	}
+
+	// Each step either publishes a bundle or, with a nil bundle, prunes at
+	// the given offset from the start
+	start := time.Now()
+	steps := []struct {
+		name        string
+		at          time.Duration
+		bundle      *x509bundle.Bundle
+		wantChanged bool
+		acceptOld   bool
+		acceptNew   bool
+	}{
+		{"old CA published", 0, published(oldCA), true, true, false},
+		{"new CA added", 10 * time.Minute, published(oldCA, newCA), true, true, true},
+		{"same bundle again", 15 * time.Minute, published(oldCA, newCA), false, true, true},
+		{"old CA withdrawn", 20 * time.Minute, published(newCA), false, true, true},
+		{"inside overlap", 20*time.Minute + overlap - time.Second, nil, false, true, true},
+		{"overlap ended", 20*time.Minute + overlap, nil, true, false, true},
+		{"after overlap", 2 * overlap, published(newCA), false, false, true},
+	}
+
+	bundle := spiffesdk.NewTrustBundle()
+	var sequence uint64
+	for _, step := range steps {
+		now := start.Add(step.at)
+		var changes int
+		if step.bundle != nil {
+			changes = bundle.Update(step.bundle, overlap, now)
+		} else {
+			changes = bundle.Prune(now)
+		}
+		if (changes > 0) != step.wantChanged {
+			t.Errorf("%s: %d trust anchor changes, want changes %v", step.name, changes, step.wantChanged)
+		}
+
+		got := bundle.Sequence()
+		switch {
+		case got < sequence:
+			t.Errorf("%s: sequence went back from %d to %d", step.name, sequence, got)
+		case step.wantChanged && got == sequence:
+			t.Errorf("%s: sequence %d not incremented", step.name, got)
+		case !step.wantChanged && got != sequence:
+			t.Errorf("%s: sequence changed from %d to %d without a trust anchor change", step.name, sequence, got)
+		}
+		sequence = got
+
+		trusted := bundle.Get(td)
+		if accepted := verifies(oldSVID, trusted); accepted != step.acceptOld {
+			t.Errorf("%s: SVID from the old CA accepted = %v, want %v", step.name, accepted, step.acceptOld)
+		}
+		if accepted := verifies(newSVID, trusted); accepted != step.acceptNew {
+			t.Errorf("%s: SVID from the new CA accepted = %v, want %v", step.name, accepted, step.acceptNew)
+		}
+	}
+}
+
+func TestTrustBundleRepublishedDuringOverlap(t *testing.T) {
+	const overlap = time.Hour
+	oldCA := spiffetest.NewCA(t, "authsec.dev")
+	newCA := spiffetest.NewCA(t, "authsec.dev")
+	td := oldCA.TrustDomain()
+	both := x509bundle.FromX509Authorities(td, []*x509.Certificate{oldCA.Certificate(), newCA.Certificate()})
+
+	bundle := spiffesdk.NewTrustBundle()
+	start := time.Now()
+	bundle.Update(both, overlap, start)
+	bundle.Update(newCA.X509Bundle(), overlap, start.Add(time.Minute))
+	sequence := bundle.Sequence()
+
+	// Publishing the retiring CA again cancels its overlap
+	if changes := bundle.Update(both, overlap, start.Add(2*time.Minute)); changes != 0 {
+		t.Errorf("republishing the retiring CA reported %d changes", changes)
+	}
+	if changes := bundle.Prune(start.Add(2 * overlap)); changes != 0 {
+		t.Errorf("published CA pruned after the overlap: %d changes", changes)
+	}
+	if !verifies(oldCA.CreateX509SVID(paymentID, 0), bundle.Get(td)) {
+		t.Error("SVID from the republished CA rejected")
+	}
+	if got := bundle.Sequence(); got != sequence {
+		t.Errorf("sequence changed from %d to %d", sequence, got)
+	}
+}
+
+func TestBundleSequenceIsShared(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	newCA := spiffetest.NewCA(t, "authsec.dev")
+	headless := spiffetest.NewHeadlessServer(t, ca)
+	headless.SetBundleCAs(ca, newCA)
+
+	removed := make(chan spiffesdk.Event, 1)
+	sdk := newHeadlessSDK(t, headless, func(config *spiffesdk.Config) {
+		config.BundleRefreshInterval = 10 * time.Millisecond
+		config.BundleOverlap = 100 * time.Millisecond
+		config.OnEvent = func(event spiffesdk.Event) {
+			if event.Type == spiffesdk.EventTrustAnchorRemoved {
+				select {
+				case removed <- event:
+				default:
+				}
+			}
+		}
+	})
+	if err := sdk.Start(); err != nil {
+		t.Fatal(err)
+	}
+	handler, err := sdk.BundleEndpointHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := func() uint64 {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		var bundle struct {
+			Sequence uint64 `json:"spiffe_sequence"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &bundle); err != nil {
+			t.Fatal(err)
+		}
+		return bundle.Sequence
+	}
+
+	before := sdk.GetBundleStatus().Sequence
+	newest := max(ca.Certificate().NotBefore.Unix(), newCA.Certificate().NotBefore.Unix())
+	if before < uint64(newest) {
+		t.Errorf("sequence %d is below the newest authority's NotBefore %d", before, newest)
+	}
+	if got := served(); got != before {
+		t.Errorf("endpoint serves sequence %d, GetBundleStatus reports %d", got, before)
+	}
+
+	// Retiring the old CA changes the one sequence everywhere
+	headless.SetBundleCAs(newCA)
+	var event spiffesdk.Event
+	select {
+	case event = <-removed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("old CA was not retired")
+	}
+	status := sdk.GetBundleStatus()
+	if status.Sequence <= before {
+		t.Errorf("sequence went from %d to %d when a CA was retired", before, status.Sequence)
+	}
+	if got := event.Attributes["sequence"]; got != strconv.FormatUint(status.Sequence, 10) {
+		t.Errorf("%s event has sequence %s, want %d", event.Type, got, status.Sequence)
+	}
+	if got := expvar.Get("spiffesdk").(*expvar.Map).Get("bundle_sequence").String(); got != strconv.FormatUint(status.Sequence, 10) {
+		t.Errorf("bundle_sequence metric = %s, want %d", got, status.Sequence)
+	}
+	if got := served(); got != status.Sequence {
+		t.Errorf("endpoint serves sequence %d, want %d", got, status.Sequence)
+	}
+}
+
+func verifies(svid *x509svid.SVID, bundle *x509bundle.Bundle) bool {
+	if bundle == nil {
+		return false
+	}
+	_, _, err := x509svid.Verify(svid.Certificates, bundle)
+	return err == nil
+}
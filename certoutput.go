@@ -0,0 +1,253 @@
+package spiffesdk
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/authsec-ai/spiffe-sdk/internal/atomicfile"
+)
+
+// certificateCommandTimeout bounds how long the post-update command may run
+const certificateCommandTimeout = 30 * time.Second
+
+// jwtFileCheckInterval is how often written JWT-SVIDs are checked for renewal
+const jwtFileCheckInterval = 30 * time.Second
+
+// certificateOutputSignals are the signals that may be sent after an update
+var certificateOutputSignals = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGTERM": syscall.SIGTERM,
+}
+
+// CertificateOutput writes the SVID and bundle to files on every rotation, for
+// processes that can't use the SDK (nginx, database clients, JVMs), in the
+// same way as spiffe-helper
+type CertificateOutput struct {
+	Dir string `json:"dir"`
+
+	// File names inside Dir (default svid.pem, svid_key.pem and bundle.pem)
+	SVIDFileName    string `json:"svid_file_name"`
+	SVIDKeyFileName string `json:"svid_key_file_name"`
+	BundleFileName  string `json:"bundle_file_name"`
+
+	// File modes for certificates and the private key (default 0644 and 0600)
+	CertFileMode os.FileMode `json:"cert_file_mode"`
+	KeyFileMode  os.FileMode `json:"key_file_mode"`
+
+	// IncludeFederatedBundles appends federated trust domain CAs to the bundle file
+	IncludeFederatedBundles bool `json:"include_federated_bundles"`
+
+	// JWTSVIDs are written next to the certificates and renewed before expiry
+	JWTSVIDs []JWTSVIDOutput `json:"jwt_svids"`
+
+	// After each update, Signal (default SIGHUP) is sent to the process whose
+	// PID is in PIDFile, and Command is run with CommandArgs
+	PIDFile     string   `json:"pid_file"`
+	Signal      string   `json:"signal"`
+	Command     string   `json:"command"`
+	CommandArgs []string `json:"command_args"`
+}
+
+// JWTSVIDOutput writes a JWT-SVID for Audience to FileName
+type JWTSVIDOutput struct {
+	Audience string `json:"audience"`
+	FileName string `json:"file_name"`
+}
+
+// certificateOutput checks the certificate output configuration and returns a
+// copy with defaults filled in, or nil if none is configured. The caller's
+// Config is left untouched.
+func (c *Config) certificateOutput() (*CertificateOutput, error) {
+	if c.CertificateOutput == nil {
+		return nil, nil
+	}
+	out := *c.CertificateOutput
+	out.JWTSVIDs = append([]JWTSVIDOutput(nil), out.JWTSVIDs...)
+	out.CommandArgs = append([]string(nil), out.CommandArgs...)
+
+	if out.Dir == "" {
+		return nil, errors.New("certificate output requires a directory")
+	}
+	if out.SVIDFileName == "" {
+		out.SVIDFileName = "svid.pem"
+	}
+	if out.SVIDKeyFileName == "" {
+		out.SVIDKeyFileName = "svid_key.pem"
+	}
+	if out.BundleFileName == "" {
+		out.BundleFileName = "bundle.pem"
+	}
+	if out.CertFileMode == 0 {
+		out.CertFileMode = 0o644
+	}
+	if out.KeyFileMode == 0 {
+		out.KeyFileMode = 0o600
+	}
+	if out.Signal == "" {
+		out.Signal = "SIGHUP"
+	}
+	if _, ok := certificateOutputSignals[out.Signal]; !ok {
+		return nil, fmt.Errorf("unsupported certificate output signal %q", out.Signal)
+	}
+
+	for _, jwtOut := range out.JWTSVIDs {
+		if jwtOut.Audience == "" || jwtOut.FileName == "" {
+			return nil, errors.New("JWT-SVID outputs require an audience and a file name")
+		}
+	}
+	return &out, nil
+}
+
+// writeCertificateFiles writes the current SVID, key and bundle to the output
+// directory and notifies the consuming process. Failures are logged.
+func (s *SpiffeSDK) writeCertificateFiles() {
+	out := s.certOutput
+	if out == nil {
+		return
+	}
+
+	s.outputMu.Lock()
+	defer s.outputMu.Unlock()
+
+	if err := s.writeX509Files(out); err != nil {
+		s.logf("Failed to write SVID files: %v", err)
+		return
+	}
+	s.notifyCertificateConsumer(out)
+}
+
+func (s *SpiffeSDK) writeX509Files(out *CertificateOutput) error {
+	svid, err := s.GetX509SVID()
+	if err != nil {
+		return err
+	}
+	certPEM, keyPEM, err := svid.Marshal()
+	if err != nil {
+		return err
+	}
+
+	bundle, err := s.GetX509BundleForTrustDomain(svid.ID.TrustDomain())
+	if err != nil {
+		return err
+	}
+	bundlePEM, err := bundle.Marshal()
+	if err != nil {
+		return err
+	}
+	if out.IncludeFederatedBundles {
+		for _, federated := range s.FederatedBundles().Bundles() {
+			data, err := federated.Marshal()
+			if err != nil {
+				return err
+			}
+			bundlePEM = append(bundlePEM, data...)
+		}
+	}
+
+	// Other containers read the files, so the directory must be traversable
+	if err := os.MkdirAll(out.Dir, 0o755); err != nil {
+		return err
+	}
+
+	// The key is written first so the certificate never refers to a missing key
+	if err := atomicfile.Write(filepath.Join(out.Dir, out.SVIDKeyFileName), keyPEM, out.KeyFileMode); err != nil {
+		return err
+	}
+	if err := atomicfile.Write(filepath.Join(out.Dir, out.SVIDFileName), certPEM, out.CertFileMode); err != nil {
+		return err
+	}
+	return atomicfile.Write(filepath.Join(out.Dir, out.BundleFileName), bundlePEM, out.CertFileMode)
+}
+
+// runJWTFileWriter keeps the configured JWT-SVID files up to date
+func (s *SpiffeSDK) runJWTFileWriter() {
+	out := s.certOutput
+	written := make(map[string]string, len(out.JWTSVIDs))
+
+	ticker := time.NewTicker(jwtFileCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		changed := false
+		for _, jwtOut := range out.JWTSVIDs {
+			// FetchJWTSVID returns the cached token until it is close to expiry
+			svid, err := s.FetchJWTSVID(s.ctx, jwtOut.Audience)
+			if err != nil {
+				s.logf("Failed to fetch JWT-SVID for %s: %v", jwtOut.Audience, err)
+				continue
+			}
+			token := svid.Marshal()
+			if written[jwtOut.FileName] == token {
+				continue
+			}
+
+			path := filepath.Join(out.Dir, jwtOut.FileName)
+			if err := atomicfile.Write(path, []byte(token), out.KeyFileMode); err != nil {
+				s.logf("Failed to write JWT-SVID file: %v", err)
+				continue
+			}
+			written[jwtOut.FileName] = token
+			changed = true
+		}
+
+		if changed {
+			s.outputMu.Lock()
+			s.notifyCertificateConsumer(out)
+			s.outputMu.Unlock()
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// notifyCertificateConsumer signals the consuming process and runs the
+// configured command after the files were updated
+func (s *SpiffeSDK) notifyCertificateConsumer(out *CertificateOutput) {
+	if out.PIDFile != "" {
+		if err := signalPIDFile(out.PIDFile, certificateOutputSignals[out.Signal]); err != nil {
+			s.logf("Failed to signal process after SVID update: %v", err)
+		}
+	}
+
+	if out.Command != "" {
+		ctx, cancel := context.WithTimeout(s.ctx, certificateCommandTimeout)
+		defer cancel()
+
+		output, err := exec.CommandContext(ctx, out.Command, out.CommandArgs...).CombinedOutput()
+		if err != nil {
+			s.logf("Command %s failed after SVID update: %v: %s", out.Command, err, bytes.TrimSpace(output))
+		}
+	}
+}
+
+func signalPIDFile(pidFile string, sig syscall.Signal) error {
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("invalid PID in %s: %w", pidFile, err)
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return process.Signal(sig)
+}
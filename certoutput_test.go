@@ -0,0 +1,112 @@
+package spiffesdk_test
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	spiffesdk "github.com/authsec-ai/spiffe-sdk"
+	"github.com/authsec-ai/spiffe-sdk/spiffetest"
+)
+
+func TestCertificateOutputFilesAndHooks(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	dir := t.TempDir()
+	pidFile := filepath.Join(dir, "consumer.pid")
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	out := &spiffesdk.CertificateOutput{
+		Dir:     filepath.Join(dir, "certs"),
+		PIDFile: pidFile,
+		// The command sees the files that were just written
+		Command:     "cp",
+		CommandArgs: []string{filepath.Join(dir, "certs", "svid.pem"), filepath.Join(dir, "hook.pem")},
+	}
+	config := &spiffesdk.Config{CertificateOutput: out}
+	newDenyListSDK(t, ca, paymentID, config)
+
+	if out.SVIDFileName != "" || out.KeyFileMode != 0 || out.Signal != "" {
+		t.Errorf("defaults were written into the caller's config: %+v", out)
+	}
+
+	for name, wantMode := range map[string]os.FileMode{"svid_key.pem": 0o600, "svid.pem": 0o644, "bundle.pem": 0o644} {
+		info, err := os.Stat(filepath.Join(out.Dir, name))
+		if err != nil {
+			t.Errorf("%s not written: %v", name, err)
+			continue
+		}
+		if mode := info.Mode().Perm(); mode != wantMode {
+			t.Errorf("%s mode = %o, want %o", name, mode, wantMode)
+		}
+	}
+	written, err := os.ReadFile(filepath.Join(out.Dir, "svid.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied, err := os.ReadFile(filepath.Join(dir, "hook.pem"))
+	if err != nil {
+		t.Fatalf("command did not run after the update: %v", err)
+	}
+	if !bytes.Equal(copied, written) {
+		t.Error("command ran before the certificate was written")
+	}
+	select {
+	case <-hangups:
+	case <-time.After(5 * time.Second):
+		t.Error("consumer process was not sent SIGHUP")
+	}
+}
+
+func TestCertificateOutputWriteOrder(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+
+	// A directory in place of one file makes its write fail, so the files
+	// written before it show the order
+	tests := []struct {
+		blocked string
+		want    []string
+	}{
+		{"svid_key.pem", nil},
+		{"svid.pem", []string{"svid_key.pem"}},
+		{"bundle.pem", []string{"svid_key.pem", "svid.pem"}},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(dir, tt.blocked, "occupied"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		newDenyListSDK(t, ca, paymentID, &spiffesdk.Config{
+			CertificateOutput: &spiffesdk.CertificateOutput{Dir: dir},
+			Logger:            log.New(io.Discard, "", 0),
+		})
+
+		var got []string
+		for _, name := range []string{"svid_key.pem", "svid.pem", "bundle.pem"} {
+			if info, err := os.Stat(filepath.Join(dir, name)); err == nil && info.Mode().IsRegular() {
+				got = append(got, name)
+			}
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("with %s blocked, wrote %v, want %v", tt.blocked, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("with %s blocked, wrote %v, want %v", tt.blocked, got, tt.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Command spiffectl inspects and manages SPIFFE identities through the
+// headless SPIRE API and the Workload API.
+//
+// Configuration comes from the same SPIFFE_* environment variables as the SDK
+// (see spiffesdk.ConfigFromEnv) and can be overridden with flags:
+//
+//	spiffectl list -o json
+//	spiffectl register -spiffe-id spiffe://authsec.dev/billing -selector k8s:ns:billing
+//	spiffectl issue -spiffe-id spiffe://authsec.dev/billing -out ./certs
+//	spiffectl inspect ./certs/svid.pem
+//	spiffectl watch -socket /run/spire/sockets/agent.sock
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	spiffesdk "github.com/authsec-ai/spiffe-sdk"
+)
+
+// command is a spiffectl subcommand
+type command struct {
+	usage string
+	run   func(config *spiffesdk.Config, args []string) error
+}
+
+var commands map[string]command
+
+// The table is filled in init because commands refer back to it for usage
+func init() {
+	commands = map[string]command{
+		"register": {"register a workload with the headless API", runRegister},
+		"list":     {"list registered workloads", runList},
+		"get":      {"show a registered workload", runGet},
+		"delete":   {"delete a workload registration", runDelete},
+		"issue":    {"issue an X.509-SVID and write it to disk", runIssue},
+		"verify":   {"verify a PEM certificate with the headless API", runVerify},
+		"inspect":  {"print the details of a PEM certificate chain", runInspect},
+		"watch":    {"watch SVID rotation from the Workload API socket", runWatch},
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := os.Args[1]
+	if name == "help" || name == "-h" || name == "--help" {
+		usage()
+		return
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "spiffectl: unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
+
+	config, err := spiffesdk.ConfigFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "spiffectl: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := cmd.run(config, os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "spiffectl %s: %v\n", name, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "Usage: spiffectl <command> [flags] [args]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun 'spiffectl <command> -h' for the flags of a command.")
+}
+
+// options are the flags shared by every command
+type options struct {
+	output string
+}
+
+// newFlagSet returns a flag set for a command with the shared flags, which
+// default to the values from the environment
+func newFlagSet(name, args string, config *spiffesdk.Config) (*flag.FlagSet, *options) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: spiffectl %s [flags] %s\n\n%s.\n\nFlags:\n", name, args, commands[name].usage)
+		fs.PrintDefaults()
+	}
+
+	opts := &options{}
+	fs.StringVar(&config.HeadlessAPIURL, "api-url", config.HeadlessAPIURL, "headless API URL (SPIFFE_HEADLESS_API_URL)")
+	fs.StringVar(&config.SocketPath, "socket", config.SocketPath, "Workload API socket path (SPIFFE_SOCKET_PATH)")
+	fs.StringVar(&config.TrustDomain, "trust-domain", config.TrustDomain, "trust domain (SPIFFE_TRUST_DOMAIN)")
+	fs.StringVar(&opts.output, "o", "table", "output format: table or json")
+	return fs, opts
+}
+
+// parse parses the command line and checks the number of positional arguments
+func parse(fs *flag.FlagSet, opts *options, args []string, nargs int) error {
+	_ = fs.Parse(args)
+	if opts.output != "table" && opts.output != "json" {
+		return fmt.Errorf("unknown output format %q", opts.output)
+	}
+	if fs.NArg() != nargs {
+		fs.Usage()
+		os.Exit(2)
+	}
+	return nil
+}
+
+func headlessAPI(config *spiffesdk.Config) (*spiffesdk.HeadlessAPI, error) {
+	if config.HeadlessAPIURL == "" {
+		return nil, fmt.Errorf("no headless API URL: set SPIFFE_HEADLESS_API_URL or -api-url")
+	}
+	return &spiffesdk.HeadlessAPI{
+		BaseURL:     config.HeadlessAPIURL,
+		HTTPClient:  &http.Client{Timeout: 10 * time.Second},
+		Attestation: config.Attestation,
+	}, nil
+}
+
+// print writes v as indented JSON or renders it with table
+func (o *options) print(v interface{}, table func(w *tabwriter.Writer)) error {
+	if o.output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	table(w)
+	return w.Flush()
+}
+
+// stringList is a repeatable string flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
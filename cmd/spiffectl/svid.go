@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	spiffesdk "github.com/authsec-ai/spiffe-sdk"
+	"github.com/authsec-ai/spiffe-sdk/internal/atomicfile"
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+)
+
+// svidDetails describes an X.509-SVID and its certificate chain
+type svidDetails struct {
+	SPIFFEID string               `json:"spiffe_id"`
+	Chain    []certificateDetails `json:"chain"`
+}
+
+type certificateDetails struct {
+	SPIFFEID  string    `json:"spiffe_id,omitempty"`
+	Serial    string    `json:"serial"`
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	ExpiresIn string    `json:"expires_in"`
+	IsCA      bool      `json:"is_ca"`
+}
+
+func runIssue(config *spiffesdk.Config, args []string) error {
+	fs, opts := newFlagSet("issue", "", config)
+	fs.StringVar(&config.SPIFFEID, "spiffe-id", config.SPIFFEID, "SPIFFE ID of a registered workload (SPIFFE_ID)")
+	out := fs.String("out", ".", "directory to write svid.pem, svid_key.pem and bundle.pem to")
+	if err := parse(fs, opts, args, 0); err != nil {
+		return err
+	}
+
+	if config.SPIFFEID == "" {
+		return errors.New("no SPIFFE ID: set SPIFFE_ID or -spiffe-id")
+	}
+	api, err := headlessAPI(config)
+	if err != nil {
+		return err
+	}
+	svid, err := api.GetOrRefreshSVID(config.SPIFFEID)
+	if err != nil {
+		return err
+	}
+
+	// Check the SVID like the SDK does before it replaces its own
+	parsed, err := x509svid.Parse([]byte(svid.X509SVID), []byte(svid.PrivateKey))
+	if err != nil {
+		return fmt.Errorf("headless API issued an invalid SVID: %w", err)
+	}
+	bundle, err := x509bundle.Parse(parsed.ID.TrustDomain(), []byte(svid.Bundle))
+	if err != nil {
+		return fmt.Errorf("headless API returned an invalid bundle: %w", err)
+	}
+	if err := spiffesdk.VerifySVID(parsed, bundle, config.SPIFFEID); err != nil {
+		return fmt.Errorf("headless API issued an invalid SVID: %w", err)
+	}
+
+	if err := os.MkdirAll(*out, 0o755); err != nil {
+		return err
+	}
+	// Each file is replaced atomically, key first, so a reader never sees a
+	// truncated file or a certificate without its key (as CertificateOutput)
+	files := []struct {
+		name string
+		data string
+		perm os.FileMode
+	}{
+		{"svid_key.pem", svid.PrivateKey, 0o600},
+		{"svid.pem", svid.X509SVID, 0o644},
+		{"bundle.pem", svid.Bundle, 0o644},
+	}
+	for _, file := range files {
+		if err := atomicfile.Write(filepath.Join(*out, file.name), []byte(file.data), file.perm); err != nil {
+			return err
+		}
+	}
+
+	details := describeChain(parsed.Certificates)
+	return opts.print(details, func(w *tabwriter.Writer) {
+		fmt.Fprintf(w, "Wrote SVID for %s to %s\n\n", svid.SPIFFEID, *out)
+		printChain(w, details)
+	})
+}
+
+func runVerify(config *spiffesdk.Config, args []string) error {
+	fs, opts := newFlagSet("verify", "<cert.pem>", config)
+	if err := parse(fs, opts, args, 1); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	api, err := headlessAPI(config)
+	if err != nil {
+		return err
+	}
+	result, err := api.VerifyCertificate(map[string]string{"certificate": string(data)})
+	if err != nil {
+		return err
+	}
+
+	if err := opts.print(result, func(w *tabwriter.Writer) {
+		fmt.Fprintf(w, "Valid:\t%t\n", result.Valid)
+		fmt.Fprintf(w, "SPIFFE ID:\t%s\n", result.SPIFFEID)
+		fmt.Fprintf(w, "Subject:\t%s\n", result.Subject)
+		fmt.Fprintf(w, "Issuer:\t%s\n", result.Issuer)
+		fmt.Fprintf(w, "Not before:\t%s\n", result.NotBefore)
+		fmt.Fprintf(w, "Not after:\t%s\n", result.NotAfter)
+	}); err != nil {
+		return err
+	}
+	if !result.Valid {
+		os.Exit(1)
+	}
+	return nil
+}
+
+func runInspect(config *spiffesdk.Config, args []string) error {
+	fs, opts := newFlagSet("inspect", "<cert.pem>", config)
+	if err := parse(fs, opts, args, 1); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	certs, err := parseCertificates(data)
+	if err != nil {
+		return err
+	}
+
+	details := describeChain(certs)
+	return opts.print(details, func(w *tabwriter.Writer) {
+		printChain(w, details)
+	})
+}
+
+func runWatch(config *spiffesdk.Config, args []string) error {
+	fs, opts := newFlagSet("watch", "", config)
+	if err := parse(fs, opts, args, 0); err != nil {
+		return err
+	}
+	if config.SocketPath == "" {
+		return errors.New("no Workload API socket: set SPIFFE_SOCKET_PATH or -socket")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	watcher := &rotationWatcher{opts: opts}
+	err := workloadapi.WatchX509Context(ctx, watcher, workloadapi.WithAddr("unix://"+config.SocketPath))
+	if err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
+
+// rotationWatcher prints every X.509 context received from the Workload API
+type rotationWatcher struct {
+	opts *options
+}
+
+func (r *rotationWatcher) OnX509ContextUpdate(x509Context *workloadapi.X509Context) {
+	for _, svid := range x509Context.SVIDs {
+		details := describeChain(svid.Certificates)
+		_ = r.opts.print(details, func(w *tabwriter.Writer) {
+			fmt.Fprintf(w, "%s: received SVID\n", time.Now().Format(time.RFC3339))
+			printChain(w, details)
+			fmt.Fprintln(w)
+		})
+	}
+}
+
+func (r *rotationWatcher) OnX509ContextWatchError(err error) {
+	fmt.Fprintf(os.Stderr, "%s: watch error: %v\n", time.Now().Format(time.RFC3339), err)
+}
+
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("no PEM certificates found")
+	}
+	return certs, nil
+}
+
+func describeChain(certs []*x509.Certificate) *svidDetails {
+	details := &svidDetails{}
+	for _, cert := range certs {
+		cd := certificateDetails{
+			Serial:    fmt.Sprintf("%x", cert.SerialNumber),
+			Subject:   cert.Subject.String(),
+			Issuer:    cert.Issuer.String(),
+			NotBefore: cert.NotBefore,
+			NotAfter:  cert.NotAfter,
+			ExpiresIn: time.Until(cert.NotAfter).Round(time.Second).String(),
+			IsCA:      cert.IsCA,
+		}
+		if id, err := x509svid.IDFromCert(cert); err == nil {
+			cd.SPIFFEID = id.String()
+		}
+		details.Chain = append(details.Chain, cd)
+	}
+	details.SPIFFEID = details.Chain[0].SPIFFEID
+	return details
+}
+
+func printChain(w *tabwriter.Writer, details *svidDetails) {
+	fmt.Fprintf(w, "SPIFFE ID:\t%s\n", details.SPIFFEID)
+	fmt.Fprintln(w, "\nCHAIN\tSERIAL\tSUBJECT\tISSUER\tNOT BEFORE\tNOT AFTER\tEXPIRES IN")
+	for i, cert := range details.Chain {
+		subject := cert.Subject
+		if cert.SPIFFEID != "" {
+			subject = cert.SPIFFEID
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", i, cert.Serial, subject, cert.Issuer,
+			cert.NotBefore.Format(time.RFC3339), cert.NotAfter.Format(time.RFC3339), cert.ExpiresIn)
+	}
+}
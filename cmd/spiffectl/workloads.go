@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	spiffesdk "github.com/authsec-ai/spiffe-sdk"
+)
+
+func runRegister(config *spiffesdk.Config, args []string) error {
+	fs, opts := newFlagSet("register", "", config)
+	fs.StringVar(&config.SPIFFEID, "spiffe-id", config.SPIFFEID, "SPIFFE ID to register (SPIFFE_ID)")
+	fs.StringVar(&config.ServiceType, "type", config.ServiceType, `workload type: "application" or "system" (SPIFFE_SERVICE_TYPE)`)
+	var selectors stringList
+	fs.Var(&selectors, "selector", "selector such as k8s:ns:billing, repeatable (default: from SPIFFE_NAMESPACE, SPIFFE_SERVICE_ACCOUNT and SPIFFE_POD_LABELS)")
+	if err := parse(fs, opts, args, 0); err != nil {
+		return err
+	}
+
+	if config.SPIFFEID == "" {
+		return fmt.Errorf("no SPIFFE ID: set SPIFFE_ID or -spiffe-id")
+	}
+	if config.ServiceType == "" {
+		config.ServiceType = "application"
+	}
+	deriveSelectors := config.Attestation != nil && config.Attestation.DeriveSelectors
+	if len(selectors) == 0 && !deriveSelectors && (config.Namespace == "" || config.ServiceAccount == "") {
+		return fmt.Errorf("no selectors: pass -selector or set SPIFFE_NAMESPACE and SPIFFE_SERVICE_ACCOUNT")
+	}
+
+	api, err := headlessAPI(config)
+	if err != nil {
+		return err
+	}
+	// Register like the SDK does, with the same attestation evidence, unless
+	// the selectors are given explicitly
+	payload, err := config.RegistrationPayload()
+	if err != nil {
+		return err
+	}
+	if len(selectors) > 0 {
+		payload["selectors"] = []string(selectors)
+		delete(payload, "derive_selectors")
+	} else if !deriveSelectors {
+		selectors = config.Selectors()
+	}
+	if err := api.RegisterAndIssueSVID(payload); err != nil {
+		return err
+	}
+
+	workload := spiffesdk.Workload{SPIFFEID: config.SPIFFEID, Type: config.ServiceType, Selectors: selectors}
+	return opts.print(workload, func(w *tabwriter.Writer) {
+		if len(workload.Selectors) == 0 {
+			fmt.Fprintf(w, "Registered %s (%s) with selectors derived from attestation\n", workload.SPIFFEID, workload.Type)
+			return
+		}
+		fmt.Fprintf(w, "Registered %s (%s) with selectors %s\n", workload.SPIFFEID, workload.Type, strings.Join(workload.Selectors, ", "))
+	})
+}
+
+func runList(config *spiffesdk.Config, args []string) error {
+	fs, opts := newFlagSet("list", "", config)
+	spiffeID := fs.String("spiffe-id", "", "only list workloads with this SPIFFE ID")
+	if err := parse(fs, opts, args, 0); err != nil {
+		return err
+	}
+
+	api, err := headlessAPI(config)
+	if err != nil {
+		return err
+	}
+	workloads, err := api.ListWorkloads(*spiffeID)
+	if err != nil {
+		return err
+	}
+
+	return opts.print(workloads, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "ID\tSPIFFE ID\tTYPE\tSELECTORS")
+		for _, workload := range workloads {
+			printWorkloadRow(w, &workload)
+		}
+	})
+}
+
+func runGet(config *spiffesdk.Config, args []string) error {
+	fs, opts := newFlagSet("get", "<workload-id>", config)
+	if err := parse(fs, opts, args, 1); err != nil {
+		return err
+	}
+
+	api, err := headlessAPI(config)
+	if err != nil {
+		return err
+	}
+	workload, err := api.GetWorkload(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	return opts.print(workload, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "ID\tSPIFFE ID\tTYPE\tSELECTORS")
+		printWorkloadRow(w, workload)
+	})
+}
+
+func runDelete(config *spiffesdk.Config, args []string) error {
+	fs, opts := newFlagSet("delete", "<workload-id>", config)
+	if err := parse(fs, opts, args, 1); err != nil {
+		return err
+	}
+
+	api, err := headlessAPI(config)
+	if err != nil {
+		return err
+	}
+	if err := api.DeleteWorkload(fs.Arg(0)); err != nil {
+		return err
+	}
+
+	result := map[string]string{"deleted": fs.Arg(0)}
+	return opts.print(result, func(w *tabwriter.Writer) {
+		fmt.Fprintf(w, "Deleted workload %s\n", fs.Arg(0))
+	})
+}
+
+func printWorkloadRow(w *tabwriter.Writer, workload *spiffesdk.Workload) {
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", workload.ID, workload.SPIFFEID, workload.Type, strings.Join(workload.Selectors, ","))
+}
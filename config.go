@@ -0,0 +1,120 @@
+package spiffesdk
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConfigFromEnv builds a Config from the SPIFFE_* environment variables used
+// in config/service-template.yaml. Unset variables leave fields empty, except
+// the renewal settings, which default to 5m and 1m.
+//
+//	SPIFFE_SERVICE_NAME, SPIFFE_ID, SPIFFE_SERVICE_TYPE
+//	SPIFFE_NAMESPACE, SPIFFE_SERVICE_ACCOUNT, SPIFFE_POD_LABELS (app=web,tier=api)
+//	SPIFFE_HEADLESS_API_URL, SPIFFE_SOCKET_PATH, SPIFFE_TRUST_DOMAIN
+//	SPIFFE_RENEWAL_THRESHOLD, SPIFFE_CHECK_INTERVAL
+//	SPIFFE_BUNDLE_REFRESH_INTERVAL, SPIFFE_BUNDLE_OVERLAP
+//	SPIFFE_DENY_LIST_PATH, SPIFFE_DENY_LIST_FROM_HEADLESS_API (true/false),
+//	SPIFFE_DENY_LIST_REFRESH_INTERVAL
+//	SPIFFE_ATTESTATION_TOKEN_PATH, SPIFFE_ATTESTATION_AUDIENCE,
+//	SPIFFE_DERIVE_SELECTORS (true/false), SPIFFE_POD_UID, SPIFFE_NODE_NAME
+//
+// Attestation is enabled when any of SPIFFE_ATTESTATION_TOKEN_PATH,
+// SPIFFE_ATTESTATION_AUDIENCE or SPIFFE_DERIVE_SELECTORS is set.
+func ConfigFromEnv() (*Config, error) {
+	config := &Config{
+		ServiceName:      os.Getenv("SPIFFE_SERVICE_NAME"),
+		SPIFFEID:         os.Getenv("SPIFFE_ID"),
+		ServiceType:      os.Getenv("SPIFFE_SERVICE_TYPE"),
+		Namespace:        os.Getenv("SPIFFE_NAMESPACE"),
+		ServiceAccount:   os.Getenv("SPIFFE_SERVICE_ACCOUNT"),
+		HeadlessAPIURL:   os.Getenv("SPIFFE_HEADLESS_API_URL"),
+		SocketPath:       os.Getenv("SPIFFE_SOCKET_PATH"),
+		TrustDomain:      os.Getenv("SPIFFE_TRUST_DOMAIN"),
+		DenyListPath:     os.Getenv("SPIFFE_DENY_LIST_PATH"),
+		RenewalThreshold: 5 * time.Minute,
+		CheckInterval:    1 * time.Minute,
+	}
+
+	if labels := os.Getenv("SPIFFE_POD_LABELS"); labels != "" {
+		config.PodLabels = make(map[string]string)
+		for _, label := range strings.Split(labels, ",") {
+			key, value, ok := strings.Cut(strings.TrimSpace(label), "=")
+			if !ok || key == "" {
+				return nil, fmt.Errorf("invalid SPIFFE_POD_LABELS entry %q: expected key=value", label)
+			}
+			config.PodLabels[key] = value
+		}
+	}
+
+	if value := os.Getenv("SPIFFE_DENY_LIST_FROM_HEADLESS_API"); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SPIFFE_DENY_LIST_FROM_HEADLESS_API: %w", err)
+		}
+		config.DenyListFromHeadlessAPI = enabled
+	}
+
+	attestation := &AttestationConfig{
+		TokenPath: os.Getenv("SPIFFE_ATTESTATION_TOKEN_PATH"),
+		Audience:  os.Getenv("SPIFFE_ATTESTATION_AUDIENCE"),
+		PodUID:    os.Getenv("SPIFFE_POD_UID"),
+		NodeName:  os.Getenv("SPIFFE_NODE_NAME"),
+	}
+	deriveSelectors := os.Getenv("SPIFFE_DERIVE_SELECTORS")
+	if deriveSelectors != "" {
+		derive, err := strconv.ParseBool(deriveSelectors)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SPIFFE_DERIVE_SELECTORS: %w", err)
+		}
+		attestation.DeriveSelectors = derive
+	}
+	if attestation.TokenPath != "" || attestation.Audience != "" || deriveSelectors != "" {
+		config.Attestation = attestation
+	}
+
+	for name, field := range map[string]*time.Duration{
+		"SPIFFE_RENEWAL_THRESHOLD": &config.RenewalThreshold,
+		"SPIFFE_CHECK_INTERVAL":    &config.CheckInterval,
+
+		"SPIFFE_BUNDLE_REFRESH_INTERVAL": &config.BundleRefreshInterval,
+		"SPIFFE_BUNDLE_OVERLAP":          &config.BundleOverlap,
+
+		"SPIFFE_DENY_LIST_REFRESH_INTERVAL": &config.DenyListRefreshInterval,
+	} {
+		if value := os.Getenv(name); value != "" {
+			duration, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*field = duration
+		}
+	}
+
+	return config, nil
+}
+
+// Selectors returns the workload selectors registered for this service: its
+// namespace, service account and pod labels
+func (c *Config) Selectors() []string {
+	selectors := []string{
+		fmt.Sprintf("k8s:ns:%s", c.Namespace),
+		fmt.Sprintf("k8s:sa:%s", c.ServiceAccount),
+	}
+
+	// Add pod labels as selectors, in a stable order
+	keys := make([]string, 0, len(c.PodLabels))
+	for key := range c.PodLabels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		selectors = append(selectors, fmt.Sprintf("k8s:pod-label:%s:%s", key, c.PodLabels[key]))
+	}
+
+	return selectors
+}
@@ -0,0 +1,538 @@
+package spiffesdk
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/spiffegrpc/grpccredentials"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultDenyListRefreshInterval is how often the deny-list is reloaded when
+// Config.DenyListRefreshInterval is zero
+const defaultDenyListRefreshInterval = time.Minute
+
+// DenyList lists peers that are refused even though their SVIDs chain to a
+// trusted bundle, e.g. compromised workloads whose SVIDs haven't expired yet.
+// Serials and SPKI hashes are matched against every certificate of the peer's
+// chain, so a compromised intermediate CA can be cut off as well.
+type DenyList struct {
+	SPIFFEIDs []string `json:"spiffe_ids" yaml:"spiffe_ids"`
+
+	// Serials are hex certificate serial numbers; colons are ignored
+	Serials []string `json:"serials" yaml:"serials"`
+
+	// SPKIHashes are hex SHA-256 hashes of certificates' SubjectPublicKeyInfo
+	SPKIHashes []string `json:"spki_sha256" yaml:"spki_sha256"`
+}
+
+// LoadDenyList parses a deny-list from YAML or JSON
+func LoadDenyList(data []byte) (*DenyList, error) {
+	var list DenyList
+	if err := yaml.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse deny-list: %w", err)
+	}
+	if _, err := list.compile(); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// deniedPeers is a compiled DenyList
+type deniedPeers struct {
+	ids     map[string]bool
+	serials map[string]bool
+	spki    map[string]bool
+}
+
+func (d *DenyList) compile() (*deniedPeers, error) {
+	peers := &deniedPeers{
+		ids:     make(map[string]bool),
+		serials: make(map[string]bool),
+		spki:    make(map[string]bool),
+	}
+	for _, id := range d.SPIFFEIDs {
+		parsed, err := spiffeid.FromString(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid denied SPIFFE ID %q: %w", id, err)
+		}
+		peers.ids[parsed.String()] = true
+	}
+	for _, serial := range d.Serials {
+		n, ok := new(big.Int).SetString(strings.ReplaceAll(serial, ":", ""), 16)
+		if !ok {
+			return nil, fmt.Errorf("invalid denied serial %q: expected hex", serial)
+		}
+		peers.serials[n.Text(16)] = true
+	}
+	for _, hash := range d.SPKIHashes {
+		raw, err := hex.DecodeString(strings.ReplaceAll(hash, ":", ""))
+		if err != nil || len(raw) != sha256.Size {
+			return nil, fmt.Errorf("invalid denied SPKI hash %q: expected hex SHA-256", hash)
+		}
+		peers.spki[hex.EncodeToString(raw)] = true
+	}
+	return peers, nil
+}
+
+// merge returns the union of both lists
+func (p *deniedPeers) merge(other *deniedPeers) *deniedPeers {
+	merged := &deniedPeers{
+		ids:     make(map[string]bool),
+		serials: make(map[string]bool),
+		spki:    make(map[string]bool),
+	}
+	for _, src := range []*deniedPeers{p, other} {
+		if src == nil {
+			continue
+		}
+		for k := range src.ids {
+			merged.ids[k] = true
+		}
+		for k := range src.serials {
+			merged.serials[k] = true
+		}
+		for k := range src.spki {
+			merged.spki[k] = true
+		}
+	}
+	return merged
+}
+
+// check returns an error if the chain (leaf first) is denied
+func (p *deniedPeers) check(certs []*x509.Certificate) error {
+	if p == nil || len(certs) == 0 {
+		return nil
+	}
+	if id, err := x509svid.IDFromCert(certs[0]); err == nil && p.ids[id.String()] {
+		return fmt.Errorf("%s is on the deny-list", id)
+	}
+	for _, cert := range certs {
+		if p.serials[cert.SerialNumber.Text(16)] {
+			return fmt.Errorf("certificate serial %s is on the deny-list", cert.SerialNumber.Text(16))
+		}
+		hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		if p.spki[hex.EncodeToString(hash[:])] {
+			return fmt.Errorf("public key of %s is on the deny-list", cert.Subject)
+		}
+	}
+	return nil
+}
+
+// size returns the number of entries
+func (p *deniedPeers) size() int {
+	if p == nil {
+		return 0
+	}
+	return len(p.ids) + len(p.serials) + len(p.spki)
+}
+
+// fingerprint identifies the entries, independent of order
+func (p *deniedPeers) fingerprint() string {
+	if p == nil {
+		return ""
+	}
+	var entries []string
+	for k := range p.ids {
+		entries = append(entries, "id:"+k)
+	}
+	for k := range p.serials {
+		entries = append(entries, "serial:"+k)
+	}
+	for k := range p.spki {
+		entries = append(entries, "spki:"+k)
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, "\n")
+}
+
+// denyListState holds the last good deny-list of each source and the open
+// mTLS connections of the SDK's servers, clients and gRPC credentials, each
+// with a function returning its peer's certificates (nil until the handshake
+// completes)
+type denyListState struct {
+	mu       sync.Mutex
+	file     *deniedPeers
+	headless *deniedPeers
+	conns    map[net.Conn]func() []*x509.Certificate
+}
+
+// ErrDenied is wrapped by errors for peers on the deny-list
+var ErrDenied = errors.New("peer is denied")
+
+// CheckDenyList returns an error wrapping ErrDenied if the certificate chain
+// (leaf first) is on the deny-list
+func (s *SpiffeSDK) CheckDenyList(certs []*x509.Certificate) error {
+	if err := s.deniedPeers.Load().check(certs); err != nil {
+		return fmt.Errorf("%w: %v", ErrDenied, err)
+	}
+	return nil
+}
+
+// DenyListAuthorizer wraps authorizer (AuthorizeAny if nil) so that peers on
+// the deny-list fail the TLS handshake. Every TLS config, HTTP client, server
+// and gRPC credential created by the SDK already uses it.
+func (s *SpiffeSDK) DenyListAuthorizer(authorizer tlsconfig.Authorizer) tlsconfig.Authorizer {
+	if authorizer == nil {
+		authorizer = tlsconfig.AuthorizeAny()
+	}
+	return func(id spiffeid.ID, verifiedChains [][]*x509.Certificate) error {
+		for _, chain := range verifiedChains {
+			if err := s.CheckDenyList(chain); err != nil {
+				metrics.Add("deny_list_rejections_total", 1)
+				return err
+			}
+		}
+		return authorizer(id, verifiedChains)
+	}
+}
+
+// denyPeerRequest rejects a request over a connection from a denied peer with
+// a 403 and reports whether it did. Connections are checked at the handshake,
+// but the peer may have been denied since.
+func (s *SpiffeSDK) denyPeerRequest(w http.ResponseWriter, r *http.Request) bool {
+	if r.TLS == nil {
+		return false
+	}
+	if err := s.CheckDenyList(r.TLS.PeerCertificates); err != nil {
+		metrics.Add("deny_list_rejections_total", 1)
+		http.Error(w, "Client certificate is denied", http.StatusForbidden)
+		return true
+	}
+	return false
+}
+
+// checkDeniedID returns an error wrapping ErrDenied if id is on the deny-list,
+// e.g. for callers authenticated with a JWT-SVID
+func (s *SpiffeSDK) checkDeniedID(id spiffeid.ID) error {
+	if peers := s.deniedPeers.Load(); peers != nil && peers.ids[id.String()] {
+		metrics.Add("deny_list_rejections_total", 1)
+		return fmt.Errorf("%w: %s is on the deny-list", ErrDenied, id)
+	}
+	return nil
+}
+
+// checkGRPCPeer rejects calls over connections from denied peers. The
+// credentials from GRPCServerCredentials only expose the peer's SPIFFE ID;
+// connections from peers denied by serial or key are closed instead.
+func (s *SpiffeSDK) checkGRPCPeer(ctx context.Context) error {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	var err error
+	if tlsInfo, ok := peerTLSInfo(p); ok {
+		err = s.CheckDenyList(tlsInfo.State.PeerCertificates)
+		if err != nil {
+			metrics.Add("deny_list_rejections_total", 1)
+		}
+	} else if id, ok := grpccredentials.PeerIDFromPeer(p); ok {
+		err = s.checkDeniedID(id)
+	}
+	if err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return nil
+}
+
+// hasDenyList reports whether a deny-list source is configured
+func (c *Config) hasDenyList() bool {
+	return c.DenyListPath != "" || c.DenyListFromHeadlessAPI
+}
+
+// loadDenyList loads the configured deny-lists. A source that fails keeps its
+// last good list; the initial load of the file must succeed.
+func (s *SpiffeSDK) loadDenyList(initial bool) error {
+	s.denyList.mu.Lock()
+	file, headless := s.denyList.file, s.denyList.headless
+	s.denyList.mu.Unlock()
+
+	if s.config.DenyListPath != "" {
+		peers, err := loadDenyListFile(s.config.DenyListPath)
+		if err != nil {
+			if initial {
+				return err
+			}
+			s.emit(Event{
+				Type:       EventDenyListFetchFailed,
+				Message:    "keeping the last good deny-list from " + s.config.DenyListPath,
+				Err:        err,
+				Attributes: map[string]string{"source": s.config.DenyListPath},
+			})
+		} else {
+			file = peers
+		}
+	}
+	if s.config.DenyListFromHeadlessAPI {
+		list, err := s.headlessAPI.FetchDenyList()
+		if err == nil {
+			headless, err = list.compile()
+		}
+		if err != nil {
+			s.emit(Event{
+				Type:       EventDenyListFetchFailed,
+				Message:    "keeping the last good deny-list from the headless API",
+				Err:        err,
+				Attributes: map[string]string{"source": SVIDSourceHeadlessAPI},
+			})
+		}
+	}
+
+	s.denyList.mu.Lock()
+	s.denyList.file, s.denyList.headless = file, headless
+	s.denyList.mu.Unlock()
+
+	merged := file.merge(headless)
+	previous := s.deniedPeers.Swap(merged)
+	if previous.fingerprint() == merged.fingerprint() {
+		return nil
+	}
+
+	denyListEntries.Set(int64(merged.size()))
+	s.emit(Event{
+		Type:       EventDenyListUpdated,
+		Message:    fmt.Sprintf("deny-list has %d entries", merged.size()),
+		Attributes: map[string]string{"entries": fmt.Sprint(merged.size())},
+	})
+	s.closeDeniedConns()
+	return nil
+}
+
+func loadDenyListFile(path string) (*deniedPeers, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read deny-list: %w", err)
+	}
+	list, err := LoadDenyList(data)
+	if err != nil {
+		return nil, err
+	}
+	return list.compile()
+}
+
+// runDenyListRefresher reloads the deny-list every DenyListRefreshInterval
+func (s *SpiffeSDK) runDenyListRefresher() {
+	interval := s.config.DenyListRefreshInterval
+	if interval <= 0 {
+		interval = defaultDenyListRefreshInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			_ = s.loadDenyList(false)
+		}
+	}
+}
+
+// trackConn records connections of the SDK's HTTP servers so they can be
+// closed when their peer is denied. It is used as http.Server.ConnState.
+func (s *SpiffeSDK) trackConn(conn net.Conn, state http.ConnState) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return
+	}
+
+	switch state {
+	case http.StateNew:
+		s.trackPeer(tlsConn, func() []*x509.Certificate {
+			// ConnectionState waits for a handshake in progress
+			state := tlsConn.ConnectionState()
+			if !state.HandshakeComplete {
+				return nil
+			}
+			return state.PeerCertificates
+		})
+	case http.StateClosed, http.StateHijacked:
+		s.untrackConn(tlsConn)
+	}
+}
+
+func (s *SpiffeSDK) trackPeer(conn net.Conn, peerCertificates func() []*x509.Certificate) {
+	s.denyList.mu.Lock()
+	defer s.denyList.mu.Unlock()
+	s.denyList.conns[conn] = peerCertificates
+}
+
+func (s *SpiffeSDK) untrackConn(conn net.Conn) {
+	s.denyList.mu.Lock()
+	defer s.denyList.mu.Unlock()
+	delete(s.denyList.conns, conn)
+}
+
+// closeDeniedConns closes connections whose peer is now denied, inbound and
+// outbound. Pooled client connections are dropped by their transports and
+// gRPC reconnects, so the next handshake is checked against the new list.
+func (s *SpiffeSDK) closeDeniedConns() {
+	s.denyList.mu.Lock()
+	conns := make(map[net.Conn]func() []*x509.Certificate, len(s.denyList.conns))
+	for conn, peerCertificates := range s.denyList.conns {
+		conns[conn] = peerCertificates
+	}
+	s.denyList.mu.Unlock()
+
+	for conn, peerCertificates := range conns {
+		// Connections still handshaking are checked by the authorizer
+		certs := peerCertificates()
+		if certs == nil {
+			continue
+		}
+		if err := s.CheckDenyList(certs); err != nil {
+			s.logf("Closing connection with %s: %v", conn.RemoteAddr(), err)
+			metrics.Add("denied_connections_closed_total", 1)
+			_ = conn.Close()
+			s.untrackConn(conn)
+		}
+	}
+}
+
+// trackedConn is a raw connection under an mTLS connection of the SDK's
+// clients or gRPC credentials. Closing it stops tracking it.
+type trackedConn struct {
+	net.Conn
+	sdk *SpiffeSDK
+}
+
+func (c *trackedConn) Close() error {
+	c.sdk.untrackConn(c)
+	return c.Conn.Close()
+}
+
+// trackTransport makes transport perform its own TLS handshakes so its
+// connections are tracked. Transports with a custom TLS dialer are left alone.
+// Requests sent through a proxy (CONNECT) are not tracked: the transport does
+// that handshake itself, so a newly denied peer is only cut off once the
+// pooled connection is closed, though new handshakes are still refused.
+func (s *SpiffeSDK) trackTransport(transport *http.Transport) *http.Transport {
+	if transport.DialTLSContext != nil || transport.DialTLS != nil {
+		return transport
+	}
+
+	dial := transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	}
+	transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		rawConn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		// Read at dial time: the transport adds its ALPN protocols lazily
+		config := &tls.Config{}
+		if transport.TLSClientConfig != nil {
+			config = transport.TLSClientConfig.Clone()
+		}
+		if config.ServerName == "" {
+			if host, _, err := net.SplitHostPort(addr); err == nil {
+				config.ServerName = host
+			}
+		}
+
+		conn := &trackedConn{Conn: rawConn, sdk: s}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			_ = rawConn.Close()
+			return nil, err
+		}
+		peerCertificates := tlsConn.ConnectionState().PeerCertificates
+		s.trackPeer(conn, func() []*x509.Certificate { return peerCertificates })
+		return tlsConn, nil
+	}
+	return transport
+}
+
+// trackingCredentials tracks the connections of gRPC transport credentials
+type trackingCredentials struct {
+	credentials.TransportCredentials
+	sdk *SpiffeSDK
+}
+
+func (c trackingCredentials) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	tracked := &trackedConn{Conn: rawConn, sdk: c.sdk}
+	conn, authInfo, err := c.TransportCredentials.ClientHandshake(ctx, authority, tracked)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.track(tracked, conn)
+	return conn, authInfo, nil
+}
+
+func (c trackingCredentials) ServerHandshake(rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	tracked := &trackedConn{Conn: rawConn, sdk: c.sdk}
+	conn, authInfo, err := c.TransportCredentials.ServerHandshake(tracked)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.track(tracked, conn)
+	return conn, authInfo, nil
+}
+
+// track records the raw connection under conn. gRPC returns the *tls.Conn
+// itself because trackedConn does not implement syscall.Conn.
+func (c trackingCredentials) track(tracked *trackedConn, conn net.Conn) {
+	tlsConn, ok := conn.(interface{ ConnectionState() tls.ConnectionState })
+	if !ok {
+		return
+	}
+	peerCertificates := tlsConn.ConnectionState().PeerCertificates
+	c.sdk.trackPeer(tracked, func() []*x509.Certificate { return peerCertificates })
+}
+
+func (c trackingCredentials) Clone() credentials.TransportCredentials {
+	return trackingCredentials{TransportCredentials: c.TransportCredentials.Clone(), sdk: c.sdk}
+}
+
+// FetchDenyList fetches the deny-list maintained by the headless API
+func (api *HeadlessAPI) FetchDenyList() (*DenyList, error) {
+	req, err := http.NewRequest("GET", api.BaseURL+"/spiresvc/api/v1/denylist", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if err := api.attest(req); err != nil {
+		return nil, err
+	}
+
+	resp, err := api.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("deny-list fetch failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var list DenyList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode deny-list: %w", err)
+	}
+	return &list, nil
+}
@@ -0,0 +1,247 @@
+package spiffesdk_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	spiffesdk "github.com/authsec-ai/spiffe-sdk"
+	"github.com/authsec-ai/spiffe-sdk/spiffetest"
+	"github.com/spiffe/go-spiffe/v2/bundle/spiffebundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	serverID   = "spiffe://authsec.dev/payment-service"
+	clientID   = "spiffe://authsec.dev/customer-service"
+	deniedID   = "spiffe://authsec.dev/compromised"
+	deniedYAML = "spiffe_ids:\n  - " + deniedID + "\n"
+)
+
+func TestDenyListFileRejectsHandshake(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	path := writeDenyList(t, "", deniedYAML)
+	server := newDenyListSDK(t, ca, serverID, &spiffesdk.Config{DenyListPath: path})
+	srv := spiffetest.NewServer(t, server, okHandler())
+
+	if err := get(ca.NewSDK(t, clientID), srv.URL); err != nil {
+		t.Fatalf("allowed client rejected: %v", err)
+	}
+	if err := get(ca.NewSDK(t, deniedID), srv.URL); err == nil {
+		t.Fatal("denied client accepted")
+	}
+
+	// Serials match any certificate of the chain
+	svid := ca.CreateX509SVID(clientID, 0)
+	writeDenyList(t, path, fmt.Sprintf("serials:\n  - %x\n", svid.Certificates[0].SerialNumber))
+	if err := server.ReloadDenyList(); err != nil {
+		t.Fatal(err)
+	}
+	if err := get(spiffetest.NewSDKWithSVID(t, svid, ca), srv.URL); err == nil {
+		t.Fatal("client with a denied serial accepted")
+	}
+	if err := get(ca.NewSDK(t, deniedID), srv.URL); err != nil {
+		t.Fatalf("client removed from the deny-list rejected: %v", err)
+	}
+}
+
+func TestDenyListRejectsServer(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	srv := spiffetest.NewServer(t, ca.NewSDK(t, deniedID), okHandler())
+	client := newDenyListSDK(t, ca, clientID, &spiffesdk.Config{DenyListPath: writeDenyList(t, "", deniedYAML)})
+
+	if err := get(client, srv.URL); err == nil {
+		t.Fatal("client accepted a denied server")
+	}
+}
+
+func TestDenyListFromHeadlessAPI(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	headless := spiffetest.NewHeadlessServer(t, ca)
+	headless.SetDenyList(spiffesdk.DenyList{SPIFFEIDs: []string{deniedID}})
+
+	var updates []spiffesdk.Event
+	server := newDenyListSDK(t, ca, serverID, &spiffesdk.Config{
+		HeadlessAPIURL:          headless.URL,
+		DenyListFromHeadlessAPI: true,
+		OnEvent: func(event spiffesdk.Event) {
+			if event.Type == spiffesdk.EventDenyListUpdated || event.Type == spiffesdk.EventDenyListFetchFailed {
+				updates = append(updates, event)
+			}
+		},
+	})
+	srv := spiffetest.NewServer(t, server, okHandler())
+
+	if err := get(ca.NewSDK(t, deniedID), srv.URL); err == nil {
+		t.Fatal("denied client accepted")
+	}
+	if err := get(ca.NewSDK(t, clientID), srv.URL); err != nil {
+		t.Fatalf("allowed client rejected: %v", err)
+	}
+
+	headless.SetDenyList(spiffesdk.DenyList{SPIFFEIDs: []string{clientID}})
+	if err := server.ReloadDenyList(); err != nil {
+		t.Fatal(err)
+	}
+	if err := get(ca.NewSDK(t, clientID), srv.URL); err == nil {
+		t.Fatal("newly denied client accepted")
+	}
+
+	// A failed fetch keeps the last good list
+	headless.InjectFault(spiffetest.EndpointDenyList, spiffetest.Fault{StatusCode: http.StatusServiceUnavailable})
+	if err := server.ReloadDenyList(); err != nil {
+		t.Fatal(err)
+	}
+	if err := get(ca.NewSDK(t, clientID), srv.URL); err == nil {
+		t.Fatal("denied client accepted after a failed fetch")
+	}
+
+	want := []spiffesdk.EventType{spiffesdk.EventDenyListUpdated, spiffesdk.EventDenyListUpdated, spiffesdk.EventDenyListFetchFailed}
+	if len(updates) != len(want) {
+		t.Fatalf("got %d deny-list events, want %d", len(updates), len(want))
+	}
+	for i, event := range updates {
+		if event.Type != want[i] {
+			t.Errorf("event %d is %s, want %s", i, event.Type, want[i])
+		}
+	}
+}
+
+func TestDenyListClosesServerConnections(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	path := writeDenyList(t, "", deniedYAML)
+	server := newDenyListSDK(t, ca, serverID, &spiffesdk.Config{DenyListPath: path})
+	srv := spiffetest.NewServer(t, server, okHandler())
+	client := ca.NewSDK(t, clientID).GetHTTPClient()
+
+	if err := getWith(client, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	// The handler doesn't check the deny-list, so only closing the pooled
+	// connection keeps the client out
+	writeDenyList(t, path, "spiffe_ids:\n  - "+clientID+"\n")
+	if err := server.ReloadDenyList(); err != nil {
+		t.Fatal(err)
+	}
+	if err := getWith(client, srv.URL); err == nil {
+		t.Fatal("denied client reused its connection")
+	}
+}
+
+func TestDenyListClosesClientConnections(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	srv := spiffetest.NewServer(t, ca.NewSDK(t, serverID), okHandler())
+	path := writeDenyList(t, "", deniedYAML)
+	sdk := newDenyListSDK(t, ca, clientID, &spiffesdk.Config{DenyListPath: path})
+	client := sdk.GetHTTPClient()
+
+	if err := getWith(client, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	writeDenyList(t, path, "spiffe_ids:\n  - "+serverID+"\n")
+	if err := sdk.ReloadDenyList(); err != nil {
+		t.Fatal(err)
+	}
+	if err := getWith(client, srv.URL); err == nil {
+		t.Fatal("client reused its connection to a denied server")
+	}
+}
+
+func TestDenyListClosesGRPCConnections(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	path := writeDenyList(t, "", deniedYAML)
+	server := newDenyListSDK(t, ca, serverID, &spiffesdk.Config{DenyListPath: path})
+
+	grpcServer := grpc.NewServer(grpc.Creds(server.GRPCServerCredentials(nil)))
+	healthpb.RegisterHealthServer(grpcServer, health.NewServer())
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = grpcServer.Serve(listener) }()
+	t.Cleanup(grpcServer.Stop)
+
+	client := ca.NewSDK(t, clientID)
+	conn, err := grpc.Dial(listener.Addr().String(), client.GRPCDialOption(spiffeid.RequireFromString(serverID)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	check := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+		return err
+	}
+	if err := check(); err != nil {
+		t.Fatalf("allowed client rejected: %v", err)
+	}
+
+	writeDenyList(t, path, "spiffe_ids:\n  - "+clientID+"\n")
+	if err := server.ReloadDenyList(); err != nil {
+		t.Fatal(err)
+	}
+	if err := check(); err == nil {
+		t.Fatal("denied client kept its gRPC connection")
+	}
+}
+
+// newDenyListSDK returns a static SDK for id with config, trusting ca
+func newDenyListSDK(t *testing.T, ca *spiffetest.CA, id string, config *spiffesdk.Config) *spiffesdk.SpiffeSDK {
+	t.Helper()
+	sdk, err := spiffesdk.NewStaticSDK(config, ca.CreateX509SVID(id, 0), spiffebundle.NewSet(ca.Bundle()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = sdk.Close(context.Background())
+	})
+	return sdk
+}
+
+// writeDenyList writes a deny-list to path, or to a new file if path is empty
+func writeDenyList(t *testing.T, path, content string) string {
+	t.Helper()
+	if path == "" {
+		path = filepath.Join(t.TempDir(), "denylist.yaml")
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func okHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	})
+}
+
+func get(sdk *spiffesdk.SpiffeSDK, url string) error {
+	return getWith(sdk.GetHTTPClient(), url)
+}
+
+func getWith(client *http.Client, url string) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
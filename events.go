@@ -0,0 +1,76 @@
+package spiffesdk
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// EventType identifies the kind of SDK event
+type EventType string
+
+// Event types emitted through Config.OnEvent
+const (
+	EventPolicyReloaded     EventType = "policy_reloaded"
+	EventPolicyReloadFailed EventType = "policy_reload_failed"
+
+	EventFederatedBundleUpdated     EventType = "federated_bundle_updated"
+	EventFederatedBundleFetchFailed EventType = "federated_bundle_fetch_failed"
+
+	EventWorkloadAPIConnected    EventType = "workload_api_connected"
+	EventWorkloadAPIDisconnected EventType = "workload_api_disconnected"
+	EventHeadlessFallback        EventType = "headless_fallback"
+	EventHeadlessFallbackFailed  EventType = "headless_fallback_failed"
+
+	EventStateChanged EventType = "state_changed"
+	EventSVIDRejected EventType = "svid_rejected"
+
+	EventTrustAnchorAdded   EventType = "trust_anchor_added"
+	EventTrustAnchorRemoved EventType = "trust_anchor_removed"
+
+	EventDenyListUpdated     EventType = "deny_list_updated"
+	EventDenyListFetchFailed EventType = "deny_list_fetch_failed"
+)
+
+// Event describes something that changed inside the SDK. Attributes carry
+// event-specific details such as the policy file and version.
+type Event struct {
+	Type       EventType
+	Time       time.Time
+	Message    string
+	Err        error
+	Attributes map[string]string
+}
+
+// defaultLogger writes SDK log messages to stdout when Config.Logger is nil
+var defaultLogger = log.New(os.Stdout, "", 0)
+
+// emit passes an event to Config.OnEvent, or logs it if OnEvent is nil
+func (s *SpiffeSDK) emit(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	if s.config.OnEvent != nil {
+		s.config.OnEvent(event)
+		return
+	}
+	if event.Err != nil {
+		s.logf("%s: %s: %v", event.Type, event.Message, event.Err)
+	} else {
+		s.logf("%s: %s", event.Type, event.Message)
+	}
+}
+
+// logf writes a message to the SDK's logger
+func (s *SpiffeSDK) logf(format string, args ...interface{}) {
+	s.config.logger().Printf(format, args...)
+}
+
+// logger returns Config.Logger, or the stdout logger if it is nil
+func (c *Config) logger() *log.Logger {
+	if c == nil || c.Logger == nil {
+		return defaultLogger
+	}
+	return c.Logger
+}
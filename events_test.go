@@ -0,0 +1,50 @@
+package spiffesdk_test
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+
+	spiffesdk "github.com/authsec-ai/spiffe-sdk"
+	"github.com/authsec-ai/spiffe-sdk/spiffetest"
+	"github.com/spiffe/go-spiffe/v2/bundle/spiffebundle"
+)
+
+func TestEventsAreLoggedOnlyWithoutOnEvent(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+
+	newSDK := func(config *spiffesdk.Config) *spiffesdk.SpiffeSDK {
+		sdk, err := spiffesdk.NewStaticSDK(config, ca.CreateX509SVID(serverID, 0), spiffebundle.NewSet(ca.Bundle()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sdk
+	}
+
+	var logged bytes.Buffer
+	sdk := newSDK(&spiffesdk.Config{Logger: log.New(&logged, "", 0)})
+	if err := sdk.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logged.String(), "state_changed: SDK is closed") {
+		t.Errorf("Logger got %q, want the state change", logged.String())
+	}
+
+	logged.Reset()
+	var events []spiffesdk.Event
+	sdk = newSDK(&spiffesdk.Config{
+		Logger:  log.New(&logged, "", 0),
+		OnEvent: func(event spiffesdk.Event) { events = append(events, event) },
+	})
+	if err := sdk.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) == 0 {
+		t.Error("OnEvent was not called")
+	}
+	if logged.Len() != 0 {
+		t.Errorf("events were logged although OnEvent is set: %q", logged.String())
+	}
+}
@@ -5,9 +5,12 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	spiffesdk "path/to/spiffesdk" // Replace with actual import path
+	spiffesdk "github.com/authsec-ai/spiffe-sdk"
 )
 
 // Example: Customer Service Integration
@@ -41,7 +44,7 @@ func main() {
 	if err != nil {
 		log.Fatal("Failed to create SPIFFE SDK:", err)
 	}
-	defer sdk.Close()
+	defer sdk.Close(context.Background())
 
 	// 3. Initialize the service (register with headless API, get initial SVID, start auto-renewal)
 	if err := sdk.Initialize(); err != nil {
@@ -58,18 +61,20 @@ func main() {
 	mux.HandleFunc("/customer/", customerHandler)
 	mux.HandleFunc("/internal/payment", paymentServiceHandler(sdk))
 
-	// 5. Wrap with SPIFFE incoming validation middleware
-	protectedHandler := sdk.IncomingValidationMiddleware(mux)
-
-	// 6. Start HTTPS server with SPIFFE TLS
-	server := &http.Server{
-		Addr:      ":8080",
-		Handler:   protectedHandler,
-		TLSConfig: sdk.GetHTTPClient().Transport.(*http.Transport).TLSClientConfig,
-	}
+	// 5. Stop gracefully on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
+	// 6. Start HTTPS server with SPIFFE mTLS and incoming validation middleware.
+	// Kubelet probes are served over plain HTTP on :8081.
 	fmt.Println("🚀 Customer Service starting on :8080 with SPIFFE mTLS")
-	log.Fatal(server.ListenAndServeTLS("", "")) // Certificates come from SPIFFE
+	err = sdk.Serve(ctx, ":8080", mux,
+		spiffesdk.WithIncomingValidation(),
+		spiffesdk.WithHealthProbes(":8081"),
+	)
+	if err != nil {
+		log.Fatal("Server failed:", err)
+	}
 }
 
 // Business logic handlers
@@ -80,7 +85,7 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 
 func customerHandler(w http.ResponseWriter, r *http.Request) {
 	// Extract SPIFFE ID from context (set by incoming validation middleware)
-	spiffeID := r.Context().Value("spiffe_id")
+	spiffeID, _ := spiffesdk.SPIFFEIDFromContext(r.Context())
 
 	w.Header().Set("Content-Type", "application/json")
 	fmt.Fprintf(w, `{
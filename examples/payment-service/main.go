@@ -5,9 +5,12 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	spiffesdk "path/to/spiffesdk" // Replace with actual import path
+	spiffesdk "github.com/authsec-ai/spiffe-sdk"
 )
 
 // Example: Payment Service Integration
@@ -41,7 +44,7 @@ func main() {
 	if err != nil {
 		log.Fatal("Failed to create SPIFFE SDK:", err)
 	}
-	defer sdk.Close()
+	defer sdk.Close(context.Background())
 
 	// 3. Initialize the service
 	if err := sdk.Initialize(); err != nil {
@@ -58,18 +61,33 @@ func main() {
 	mux.HandleFunc("/process", processPaymentHandler(sdk))
 	mux.HandleFunc("/validate", validatePaymentHandler(sdk))
 
-	// 5. Wrap with SPIFFE incoming validation middleware
-	protectedHandler := sdk.IncomingValidationMiddleware(mux)
-
-	// 6. Start server
-	server := &http.Server{
-		Addr:      ":8080",
-		Handler:   protectedHandler,
-		TLSConfig: sdk.GetHTTPClient().Transport.(*http.Transport).TLSClientConfig,
+	// 5. Declare which callers may reach which endpoints
+	policy := &spiffesdk.Policy{
+		Version: "2024-01",
+		Rules: []spiffesdk.PolicyRule{
+			{Name: "health", Path: "/health", Allow: []string{"spiffe://authsec.dev/**"}},
+			{Name: "process", Methods: []string{"POST"}, Path: "/process", Allow: []string{"spiffe://authsec.dev/customer-service"}},
+			{Name: "validate", Path: "/validate", Allow: []string{"spiffe://authsec.dev/customer-service"}},
+		},
+	}
+	if err := policy.Validate(); err != nil {
+		log.Fatal("Invalid authorization policy:", err)
 	}
 
+	// 6. Stop gracefully on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// 7. Start HTTPS server with SPIFFE mTLS, incoming validation and the policy.
+	// Kubelet probes are served over plain HTTP on :8081.
 	fmt.Println("🚀 Payment Service starting on :8080 with SPIFFE mTLS")
-	log.Fatal(server.ListenAndServeTLS("", ""))
+	err = sdk.Serve(ctx, ":8080", sdk.PolicyMiddleware(policy, mux),
+		spiffesdk.WithIncomingValidation(),
+		spiffesdk.WithHealthProbes(":8081"),
+	)
+	if err != nil {
+		log.Fatal("Server failed:", err)
+	}
 }
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
@@ -79,14 +97,9 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 
 func processPaymentHandler(sdk *spiffesdk.SpiffeSDK) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Extract caller's SPIFFE ID from context
-		spiffeID := r.Context().Value("spiffe_id")
-
-		// Validate that caller is authorized (e.g., customer-service)
-		if spiffeID != "spiffe://authsec.dev/customer-service" {
-			http.Error(w, "Unauthorized caller", http.StatusForbidden)
-			return
-		}
+		// Extract caller's SPIFFE ID from context; the policy has already
+		// checked that it is customer-service
+		spiffeID, _ := spiffesdk.SPIFFEIDFromContext(r.Context())
 
 		// Process payment logic here
 		w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,87 @@
+package spiffesdk
+
+import (
+	"crypto/x509"
+	"net/http"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+// Exported for the external tests, which use spiffetest and so cannot live in
+// this package
+
+// NewSVIDCache returns an empty cache that only accepts SVIDs for id
+func NewSVIDCache(id string) *SVIDCache {
+	return &SVIDCache{id: id}
+}
+
+// Update installs a PEM SVID the way issuance and rotation do
+func (c *SVIDCache) Update(certPEM, keyPEM, bundlePEM, source string) error {
+	return c.update(certPEM, keyPEM, bundlePEM, time.Time{}, source)
+}
+
+// X509SVID returns the installed SVID
+func (c *SVIDCache) X509SVID() (*x509svid.SVID, error) {
+	return c.x509SVID()
+}
+
+// TrustBundle exposes the local trust bundle's rotation logic
+type TrustBundle struct {
+	t *trustBundle
+}
+
+func NewTrustBundle() *TrustBundle {
+	return &TrustBundle{t: newTrustBundle()}
+}
+
+// Update publishes bundle at now and returns the number of authorities that
+// started or stopped being trusted
+func (b *TrustBundle) Update(bundle *x509bundle.Bundle, overlap time.Duration, now time.Time) int {
+	return len(b.t.update(bundle, overlap, now))
+}
+
+// Prune ends overlaps that are over at now and returns the number of
+// authorities removed
+func (b *TrustBundle) Prune(now time.Time) int {
+	return len(b.t.prune(now))
+}
+
+func (b *TrustBundle) Get(trustDomain spiffeid.TrustDomain) *x509bundle.Bundle {
+	return b.t.get(trustDomain)
+}
+
+func (b *TrustBundle) Sequence() uint64 {
+	b.t.mu.RLock()
+	defer b.t.mu.RUnlock()
+	return b.t.sequence
+}
+
+// ReloadDenyList reloads the deny-list like the refresher does
+func (s *SpiffeSDK) ReloadDenyList() error {
+	return s.loadDenyList(false)
+}
+
+// SetWebPKIRoots makes https_web bundle endpoints trust roots instead of the
+// system roots, e.g. for an httptest TLS server
+func (s *SpiffeSDK) SetWebPKIRoots(roots *x509.CertPool) {
+	s.webPKIRoots = roots
+}
+
+// HealthHandler serves the /health and /ready probes of WithHealthProbes
+func (s *SpiffeSDK) HealthHandler() http.Handler {
+	return s.healthHandler()
+}
+
+// RenewIdentities runs one renewal pass over the additional identities, as
+// the renewal loop does on every check
+func (s *SpiffeSDK) RenewIdentities() {
+	s.renewIdentities()
+}
+
+// GoBackground runs fn as background work that Close waits for
+func (s *SpiffeSDK) GoBackground(fn func()) bool {
+	return s.goBackground(fn)
+}
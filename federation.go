@@ -0,0 +1,230 @@
+package spiffesdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/spiffebundle"
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/federation"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+)
+
+// Bundle endpoint profiles from the SPIFFE federation specification
+const (
+	BundleEndpointProfileHTTPSWeb    = "https_web"
+	BundleEndpointProfileHTTPSSPIFFE = "https_spiffe"
+)
+
+const (
+	// defaultFederationRefresh is used when a bundle carries no spiffe_refresh_hint
+	defaultFederationRefresh = 5 * time.Minute
+
+	// federationRetryInterval is how soon a failed bundle fetch is retried
+	federationRetryInterval = 30 * time.Second
+
+	// minFederationRefresh protects endpoints from very small refresh hints
+	minFederationRefresh = 10 * time.Second
+)
+
+// FederatedTrustDomain configures trust in a partner trust domain whose bundle
+// is fetched from its SPIFFE bundle endpoint
+type FederatedTrustDomain struct {
+	TrustDomain       string `json:"trust_domain"`
+	BundleEndpointURL string `json:"bundle_endpoint_url"`
+
+	// BundleEndpointProfile is "https_web" (default, Web PKI server
+	// certificate) or "https_spiffe" (server presents an SVID)
+	BundleEndpointProfile string `json:"bundle_endpoint_profile"`
+
+	// EndpointSPIFFEID is the SPIFFE ID of the bundle endpoint server
+	// (https_spiffe only)
+	EndpointSPIFFEID string `json:"endpoint_spiffe_id"`
+
+	// BootstrapBundlePath is a PEM or SPIFFE bundle file used to authenticate
+	// an https_spiffe endpoint of the partner trust domain before its bundle has
+	// been fetched for the first time
+	BootstrapBundlePath string `json:"bootstrap_bundle_path"`
+}
+
+// validateFederation checks the federated trust domain configuration
+func (c *Config) validateFederation() error {
+	for _, fed := range c.FederatedTrustDomains {
+		td, err := spiffeid.TrustDomainFromString(fed.TrustDomain)
+		if err != nil {
+			return fmt.Errorf("invalid federated trust domain %q: %w", fed.TrustDomain, err)
+		}
+		if td.String() == c.TrustDomain {
+			return fmt.Errorf("cannot federate with the local trust domain %q", td)
+		}
+		if !strings.HasPrefix(fed.BundleEndpointURL, "https://") {
+			return fmt.Errorf("bundle endpoint URL for %q must use https", td)
+		}
+
+		switch fed.BundleEndpointProfile {
+		case "", BundleEndpointProfileHTTPSWeb:
+		case BundleEndpointProfileHTTPSSPIFFE:
+			if _, err := spiffeid.FromString(fed.EndpointSPIFFEID); err != nil {
+				return fmt.Errorf("invalid endpoint SPIFFE ID for %q: %w", td, err)
+			}
+		default:
+			return fmt.Errorf("unknown bundle endpoint profile %q for %q", fed.BundleEndpointProfile, td)
+		}
+	}
+	return nil
+}
+
+// FederatedBundles returns the X.509 bundles of all federated trust domains
+// fetched so far
+func (s *SpiffeSDK) FederatedBundles() *x509bundle.Set {
+	set := x509bundle.NewSet()
+	for _, bundle := range s.federatedBundles.Bundles() {
+		set.Add(bundle.X509Bundle())
+	}
+	return set
+}
+
+// startFederation starts one watcher per federated trust domain
+func (s *SpiffeSDK) startFederation() error {
+	for _, fed := range s.config.FederatedTrustDomains {
+		td, err := spiffeid.TrustDomainFromString(fed.TrustDomain)
+		if err != nil {
+			return err
+		}
+
+		options, err := s.federationFetchOptions(fed, td)
+		if err != nil {
+			return err
+		}
+
+		watcher := &federationWatcher{sdk: s, trustDomain: td, url: fed.BundleEndpointURL}
+		s.goBackground(func() {
+			err := federation.WatchBundle(s.ctx, watcher.trustDomain, watcher.url, watcher, options...)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				s.logf("Bundle watch for %s stopped: %v", watcher.trustDomain, err)
+			}
+		})
+	}
+	return nil
+}
+
+// fetchFederatedBundles fetches the bundle of every federated trust domain
+// once, for SDKs that don't watch them (FetchIdentity). Bundles the Workload
+// API already delivered are kept.
+func (s *SpiffeSDK) fetchFederatedBundles(ctx context.Context) error {
+	for _, fed := range s.config.FederatedTrustDomains {
+		td, err := spiffeid.TrustDomainFromString(fed.TrustDomain)
+		if err != nil {
+			return err
+		}
+		if s.federatedBundles.Has(td) {
+			continue
+		}
+
+		options, err := s.federationFetchOptions(fed, td)
+		if err != nil {
+			return err
+		}
+		bundle, err := federation.FetchBundle(ctx, td, fed.BundleEndpointURL, options...)
+		if err != nil {
+			return fmt.Errorf("failed to fetch bundle for %s: %w", td, err)
+		}
+		s.federatedBundles.Add(bundle)
+	}
+	return nil
+}
+
+// federationFetchOptions authenticates the bundle endpoint of fed. For
+// https_spiffe it loads the bootstrap bundle unless td's bundle is known.
+func (s *SpiffeSDK) federationFetchOptions(fed FederatedTrustDomain, td spiffeid.TrustDomain) ([]federation.FetchOption, error) {
+	if fed.BundleEndpointProfile != BundleEndpointProfileHTTPSSPIFFE {
+		if s.webPKIRoots != nil {
+			return []federation.FetchOption{federation.WithWebPKIRoots(s.webPKIRoots)}, nil
+		}
+		return nil, nil
+	}
+	if fed.BootstrapBundlePath != "" && !s.federatedBundles.Has(td) {
+		bundle, err := loadBootstrapBundle(td, fed.BootstrapBundlePath)
+		if err != nil {
+			return nil, err
+		}
+		s.federatedBundles.Add(bundle)
+	}
+	// The endpoint SVID is verified with the bundles the SDK already trusts,
+	// including the previously fetched bundle of td itself
+	return []federation.FetchOption{federation.WithSPIFFEAuth(s, spiffeid.RequireFromString(fed.EndpointSPIFFEID))}, nil
+}
+
+// federationWatcher keeps a federated bundle up to date. The last good bundle
+// stays in place when a fetch fails.
+type federationWatcher struct {
+	sdk         *SpiffeSDK
+	trustDomain spiffeid.TrustDomain
+	url         string
+	failed      bool
+}
+
+// NextRefresh honors the bundle's spiffe_refresh_hint and retries failed
+// fetches sooner
+func (w *federationWatcher) NextRefresh(refreshHint time.Duration) time.Duration {
+	next := defaultFederationRefresh
+	if refreshHint > 0 {
+		next = refreshHint
+	}
+	if w.failed && next > federationRetryInterval {
+		next = federationRetryInterval
+	}
+	w.failed = false
+
+	if next < minFederationRefresh {
+		next = minFederationRefresh
+	}
+	return next
+}
+
+func (w *federationWatcher) OnUpdate(bundle *spiffebundle.Bundle) {
+	w.sdk.federatedBundles.Add(bundle)
+	w.sdk.emit(Event{
+		Type:    EventFederatedBundleUpdated,
+		Message: fmt.Sprintf("bundle for %s updated from %s", w.trustDomain, w.url),
+		Attributes: map[string]string{
+			"trust_domain": w.trustDomain.String(),
+			"authorities":  fmt.Sprint(len(bundle.X509Authorities())),
+		},
+	})
+}
+
+func (w *federationWatcher) OnError(err error) {
+	w.failed = true
+	w.sdk.emit(Event{
+		Type:    EventFederatedBundleFetchFailed,
+		Message: fmt.Sprintf("keeping last good bundle for %s", w.trustDomain),
+		Err:     err,
+		Attributes: map[string]string{
+			"trust_domain": w.trustDomain.String(),
+			"url":          w.url,
+		},
+	})
+}
+
+// loadBootstrapBundle reads a SPIFFE (JWKS) bundle, falling back to PEM
+func loadBootstrapBundle(trustDomain spiffeid.TrustDomain, path string) (*spiffebundle.Bundle, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bootstrap bundle for %s: %w", trustDomain, err)
+	}
+
+	if bundle, err := spiffebundle.Parse(trustDomain, data); err == nil {
+		return bundle, nil
+	}
+
+	x509Bundle, err := x509bundle.Parse(trustDomain, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse bootstrap bundle for %s: %w", trustDomain, err)
+	}
+	return spiffebundle.FromX509Bundle(x509Bundle), nil
+}
@@ -0,0 +1,136 @@
+package spiffesdk_test
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	spiffesdk "github.com/authsec-ai/spiffe-sdk"
+	"github.com/authsec-ai/spiffe-sdk/spiffetest"
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+)
+
+const partnerID = "spiffe://partner.dev/bundle-endpoint"
+
+func TestFederationFetchesPartnerBundles(t *testing.T) {
+	for _, profile := range []string{spiffesdk.BundleEndpointProfileHTTPSWeb, spiffesdk.BundleEndpointProfileHTTPSSPIFFE} {
+		t.Run(profile, func(t *testing.T) {
+			ca := spiffetest.NewCA(t, "authsec.dev")
+			partnerCA := spiffetest.NewCA(t, "partner.dev")
+			partner := partnerCA.NewSDK(t, partnerID)
+
+			// partner.dev serves its bundle; broken.dev's endpoint fails
+			partnerFed := spiffesdk.FederatedTrustDomain{TrustDomain: "partner.dev", BundleEndpointProfile: profile}
+			brokenFed := spiffesdk.FederatedTrustDomain{TrustDomain: "broken.dev", BundleEndpointProfile: profile}
+			var webPKI *httptest.Server
+			if profile == spiffesdk.BundleEndpointProfileHTTPSWeb {
+				handler, err := partner.BundleEndpointHandler()
+				if err != nil {
+					t.Fatal(err)
+				}
+				webPKI = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.URL.Path == "/broken" {
+						http.Error(w, "unavailable", http.StatusServiceUnavailable)
+						return
+					}
+					handler.ServeHTTP(w, r)
+				}))
+				t.Cleanup(webPKI.Close)
+				partnerFed.BundleEndpointURL = webPKI.URL
+				brokenFed.BundleEndpointURL = webPKI.URL + "/broken"
+			} else {
+				addr := freeAddr(t)
+				srv, err := partner.NewBundleEndpointServer(addr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				go func() { _ = srv.ListenAndServe() }()
+				waitForListener(t, addr)
+
+				bootstrap := filepath.Join(t.TempDir(), "partner.pem")
+				pem, err := partnerCA.X509Bundle().Marshal()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(bootstrap, pem, 0o600); err != nil {
+					t.Fatal(err)
+				}
+				partnerFed.BundleEndpointURL = "https://" + addr
+				partnerFed.EndpointSPIFFEID = partnerID
+				partnerFed.BootstrapBundlePath = bootstrap
+				// broken.dev expects a different endpoint SPIFFE ID than the server presents
+				brokenFed.BundleEndpointURL = "https://" + addr
+				brokenFed.EndpointSPIFFEID = "spiffe://broken.dev/bundle-endpoint"
+			}
+
+			events := make(chan spiffesdk.Event, 16)
+			config := &spiffesdk.Config{
+				FederatedTrustDomains: []spiffesdk.FederatedTrustDomain{partnerFed, brokenFed},
+				OnEvent:               func(event spiffesdk.Event) { events <- event },
+			}
+			source := spiffesdk.NewStaticSource(ca.CreateX509SVID(paymentID, 0), x509bundle.NewSet(ca.X509Bundle()))
+			sdk, err := spiffesdk.NewSpiffeSDKWithSource(config, source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { _ = sdk.Close(context.Background()) })
+			if webPKI != nil {
+				roots := x509.NewCertPool()
+				roots.AddCert(webPKI.Certificate())
+				sdk.SetWebPKIRoots(roots)
+			}
+			if err := sdk.Start(); err != nil {
+				t.Fatal(err)
+			}
+
+			updated, failed := map[string]bool{}, map[string]bool{}
+			timeout := time.After(5 * time.Second)
+			for !updated["partner.dev"] || !failed["broken.dev"] {
+				select {
+				case event := <-events:
+					switch event.Type {
+					case spiffesdk.EventFederatedBundleUpdated:
+						updated[event.Attributes["trust_domain"]] = true
+					case spiffesdk.EventFederatedBundleFetchFailed:
+						failed[event.Attributes["trust_domain"]] = true
+					}
+				case <-timeout:
+					t.Fatalf("updated %v, failed %v; want partner.dev updated and broken.dev failed", updated, failed)
+				}
+			}
+			if updated["broken.dev"] || failed["partner.dev"] {
+				t.Errorf("updated %v, failed %v; want partner.dev updated and broken.dev failed", updated, failed)
+			}
+
+			bundle, err := sdk.GetX509BundleForTrustDomain(spiffeid.RequireTrustDomainFromString("partner.dev"))
+			if err != nil {
+				t.Fatalf("partner.dev bundle not resolved: %v", err)
+			}
+			if !bundle.Equal(partnerCA.X509Bundle()) {
+				t.Error("resolved partner.dev bundle differs from the one served")
+			}
+			if _, err := sdk.GetX509BundleForTrustDomain(spiffeid.RequireTrustDomainFromString("broken.dev")); err == nil {
+				t.Error("broken.dev bundle resolved although its endpoint failed")
+			}
+		})
+	}
+}
+
+// waitForListener waits until something accepts connections on addr
+func waitForListener(t *testing.T, addr string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return
+		}
+	}
+	t.Fatalf("nothing is listening on %s", addr)
+}
@@ -0,0 +1,108 @@
+package spiffesdk
+
+import (
+	"os"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+// defaultFileSourcePollInterval is how often FileSource checks its files for
+// changes when no interval is given
+const defaultFileSourcePollInterval = 10 * time.Second
+
+// FileSource is an IdentitySource reading the SVID, its key and the trust
+// bundle from PEM files, e.g. written by a SPIRE agent sidecar or
+// CertificateOutput on another host. The files are reloaded when they change;
+// if a reload fails the previous SVID stays in use.
+type FileSource struct {
+	*sourceState
+
+	certFile, keyFile, bundleFile string
+	modTimes                      [3]time.Time
+	done                          chan struct{}
+}
+
+// NewFileSource loads the SVID from certFile and keyFile and the bundle of its
+// trust domain from bundleFile, then polls them every pollInterval (10 seconds
+// if zero).
+func NewFileSource(certFile, keyFile, bundleFile string, pollInterval time.Duration) (*FileSource, error) {
+	if pollInterval <= 0 {
+		pollInterval = defaultFileSourcePollInterval
+	}
+
+	f := &FileSource{
+		sourceState: newSourceState(),
+		certFile:    certFile,
+		keyFile:     keyFile,
+		bundleFile:  bundleFile,
+		done:        make(chan struct{}),
+	}
+	if _, err := f.reload(); err != nil {
+		return nil, err
+	}
+	// Only report changes after the initial load
+	<-f.updated
+
+	go f.poll(pollInterval)
+	return f, nil
+}
+
+// Close stops polling the files
+func (f *FileSource) Close() error {
+	select {
+	case <-f.done:
+	default:
+		close(f.done)
+	}
+	return nil
+}
+
+func (f *FileSource) poll(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-ticker.C:
+			reloaded, err := f.reload()
+			if err != nil {
+				f.logf("Failed to reload SVID files, keeping the previous SVID: %v", err)
+			} else if reloaded {
+				f.logf("Reloaded SVID from %s", f.certFile)
+			}
+		}
+	}
+}
+
+// reload loads the files if any of them changed since the last load. It
+// reports whether a new SVID was installed.
+func (f *FileSource) reload() (bool, error) {
+	var modTimes [3]time.Time
+	for i, path := range []string{f.certFile, f.keyFile, f.bundleFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	if modTimes == f.modTimes {
+		return false, nil
+	}
+
+	svid, err := x509svid.Load(f.certFile, f.keyFile)
+	if err != nil {
+		return false, err
+	}
+	bundle, err := x509bundle.Load(svid.ID.TrustDomain(), f.bundleFile)
+	if err != nil {
+		return false, err
+	}
+
+	f.modTimes = modTimes
+	f.set(svid, x509bundle.NewSet(bundle))
+	return true, nil
+}
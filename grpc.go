@@ -0,0 +1,166 @@
+package spiffesdk
+
+import (
+	"context"
+	"crypto/x509"
+	"reflect"
+	"strings"
+
+	"github.com/spiffe/go-spiffe/v2/spiffegrpc/grpccredentials"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCMethodRules maps gRPC methods to the callers allowed to invoke them.
+// Keys are full method names ("/pkg.Service/Method"), service wildcards
+// ("/pkg.Service/*") or "*" for every method without a more specific rule.
+// Methods that match no rule are denied.
+type GRPCMethodRules map[string]tlsconfig.Authorizer
+
+// GRPCServerCredentials returns gRPC transport credentials that present the
+// SDK's current SVID and only accept clients allowed by authorizer (any SVID
+// from the trust bundle if nil)
+func (s *SpiffeSDK) GRPCServerCredentials(authorizer tlsconfig.Authorizer) credentials.TransportCredentials {
+	if authorizer == nil {
+		authorizer = tlsconfig.AuthorizeAny()
+	}
+	return trackingCredentials{
+		TransportCredentials: grpccredentials.MTLSServerCredentials(s, s, s.DenyListAuthorizer(authorizer)),
+		sdk:                  s,
+	}
+}
+
+// GRPCDialOption returns a dial option that presents the SDK's current SVID and
+// requires the server to present expectedID
+func (s *SpiffeSDK) GRPCDialOption(expectedID spiffeid.ID) grpc.DialOption {
+	return grpc.WithTransportCredentials(trackingCredentials{
+		TransportCredentials: grpccredentials.MTLSClientCredentials(s, s, s.DenyListAuthorizer(tlsconfig.AuthorizeID(expectedID))),
+		sdk:                  s,
+	})
+}
+
+// GRPCUnaryServerInterceptor adds the caller's SPIFFE ID to the context (read it
+// with SPIFFEIDFromContext) and enforces rules. A nil rules map allows every
+// authenticated caller. The server must use GRPCServerCredentials.
+func (s *SpiffeSDK) GRPCUnaryServerInterceptor(rules GRPCMethodRules) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := s.checkGRPCPeer(ctx); err != nil {
+			return nil, err
+		}
+		ctx, err := s.authorizeGRPCCall(ctx, info.FullMethod, rules)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// GRPCStreamServerInterceptor is the streaming counterpart of GRPCUnaryServerInterceptor
+func (s *SpiffeSDK) GRPCStreamServerInterceptor(rules GRPCMethodRules) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := s.checkGRPCPeer(ss.Context()); err != nil {
+			return err
+		}
+		ctx, err := s.authorizeGRPCCall(ss.Context(), info.FullMethod, rules)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &identityServerStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authorizeGRPCCall checks the peer of a call against rules and returns a
+// context carrying the peer's SPIFFE ID
+func (s *SpiffeSDK) authorizeGRPCCall(ctx context.Context, fullMethod string, rules GRPCMethodRules) (context.Context, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "caller did not present a SPIFFE ID")
+	}
+	peerID, ok := grpccredentials.PeerIDFromPeer(p)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "caller did not present a SPIFFE ID")
+	}
+
+	if rules != nil {
+		authorizer, ok := rules.lookup(fullMethod)
+		if !ok {
+			return nil, status.Errorf(codes.PermissionDenied, "no authorization rule for %s", fullMethod)
+		}
+		if err := authorizer(peerID, s.grpcPeerChains(p)); err != nil {
+			return nil, status.Errorf(codes.PermissionDenied, "%s is not allowed to call %s: %v", peerID, fullMethod, err)
+		}
+	}
+
+	return withSPIFFEID(ctx, peerID.String()), nil
+}
+
+// grpcPeerChains returns the verified chains of a gRPC peer's SVID, as an
+// authorizer sees them during the handshake. go-spiffe verifies SVIDs itself,
+// so the TLS state carries no VerifiedChains and they are rebuilt from the
+// peer certificates.
+func (s *SpiffeSDK) grpcPeerChains(p *peer.Peer) [][]*x509.Certificate {
+	tlsInfo, ok := peerTLSInfo(p)
+	if !ok {
+		return nil
+	}
+	if len(tlsInfo.State.VerifiedChains) > 0 {
+		return tlsInfo.State.VerifiedChains
+	}
+	_, chains, err := x509svid.Verify(tlsInfo.State.PeerCertificates, s)
+	if err != nil {
+		return nil
+	}
+	return chains
+}
+
+// peerTLSInfo returns the TLS details of a gRPC peer. go-spiffe wraps the
+// credentials.TLSInfo in its own AuthInfo type, so embedded AuthInfo fields are
+// unwrapped until a TLSInfo is found.
+func peerTLSInfo(p *peer.Peer) (credentials.TLSInfo, bool) {
+	authInfo := p.AuthInfo
+	for authInfo != nil {
+		if tlsInfo, ok := authInfo.(credentials.TLSInfo); ok {
+			return tlsInfo, true
+		}
+		v := reflect.ValueOf(authInfo)
+		if v.Kind() != reflect.Struct {
+			break
+		}
+		field, ok := v.Type().FieldByName("AuthInfo")
+		if !ok || !field.Anonymous {
+			break
+		}
+		authInfo, _ = v.FieldByIndex(field.Index).Interface().(credentials.AuthInfo)
+	}
+	return credentials.TLSInfo{}, false
+}
+
+// lookup returns the most specific rule for a full method name
+func (r GRPCMethodRules) lookup(fullMethod string) (tlsconfig.Authorizer, bool) {
+	if authorizer, ok := r[fullMethod]; ok {
+		return authorizer, true
+	}
+	if i := strings.LastIndex(fullMethod, "/"); i > 0 {
+		if authorizer, ok := r[fullMethod[:i+1]+"*"]; ok {
+			return authorizer, true
+		}
+	}
+	authorizer, ok := r["*"]
+	return authorizer, ok
+}
+
+// identityServerStream overrides the context of a server stream
+type identityServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *identityServerStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,75 @@
+package spiffesdk_test
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	spiffesdk "github.com/authsec-ai/spiffe-sdk"
+	"github.com/authsec-ai/spiffe-sdk/spiffetest"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPCInterceptorPassesVerifiedChains(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	server := ca.NewSDK(t, serverID)
+
+	// Only the customer service's certificate, chained to the CA's root, may
+	// check health
+	root := ca.X509Bundle().X509Authorities()[0]
+	rules := spiffesdk.GRPCMethodRules{
+		"/grpc.health.v1.Health/*": func(id spiffeid.ID, verifiedChains [][]*x509.Certificate) error {
+			for _, chain := range verifiedChains {
+				if len(chain) > 1 && chain[0].URIs[0].String() == clientID && chain[len(chain)-1].Equal(root) {
+					return nil
+				}
+			}
+			return errors.New("no verified chain from the customer service to the root CA")
+		},
+	}
+
+	grpcServer := grpc.NewServer(
+		grpc.Creds(server.GRPCServerCredentials(nil)),
+		grpc.UnaryInterceptor(server.GRPCUnaryServerInterceptor(rules)),
+	)
+	healthpb.RegisterHealthServer(grpcServer, health.NewServer())
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = grpcServer.Serve(listener) }()
+	t.Cleanup(grpcServer.Stop)
+
+	client := ca.NewSDK(t, clientID)
+	conn, err := grpc.Dial(listener.Addr().String(), client.GRPCDialOption(spiffeid.RequireFromString(serverID)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatalf("Check() error = %v, want the chain rule to allow the caller", err)
+	}
+
+	// The rule sees the impostor's own chain and turns it away
+	impostor := ca.NewSDK(t, deniedID)
+	impostorConn, err := grpc.Dial(listener.Addr().String(), impostor.GRPCDialOption(spiffeid.RequireFromString(serverID)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = impostorConn.Close() })
+	_, err = healthpb.NewHealthClient(impostorConn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("impostor Check() error = %v, want PermissionDenied", err)
+	}
+}
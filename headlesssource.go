@@ -0,0 +1,127 @@
+package spiffesdk
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+// Renewal defaults for HeadlessSource when the Config leaves them unset
+const (
+	defaultRenewalThreshold = 5 * time.Minute
+	defaultCheckInterval    = time.Minute
+)
+
+// HeadlessSource is an IdentitySource that registers the workload with the
+// headless API and renews its SVID before it expires, for hosts without a
+// SPIRE agent such as VMs
+type HeadlessSource struct {
+	*sourceState
+
+	api    *HeadlessAPI
+	config *Config
+	done   chan struct{}
+}
+
+// NewHeadlessSource registers config's workload with the headless API at
+// config.HeadlessAPIURL and fetches its first SVID. The SVID is renewed once
+// it expires within config.RenewalThreshold, checked every
+// config.CheckInterval.
+func NewHeadlessSource(config *Config) (*HeadlessSource, error) {
+	if config.HeadlessAPIURL == "" {
+		return nil, errors.New("headless API URL is required")
+	}
+	if config.SPIFFEID == "" {
+		return nil, errors.New("SPIFFE ID is required")
+	}
+
+	state := newSourceState()
+	state.logger = config.Logger
+	h := &HeadlessSource{
+		sourceState: state,
+		api: &HeadlessAPI{
+			BaseURL:     config.HeadlessAPIURL,
+			HTTPClient:  &http.Client{Timeout: 10 * time.Second},
+			Attestation: config.Attestation,
+		},
+		config: config,
+		done:   make(chan struct{}),
+	}
+
+	payload, err := config.RegistrationPayload()
+	if err == nil {
+		err = h.api.RegisterAndIssueSVID(payload)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("registration failed: %w", err)
+	}
+	if err := h.refresh(); err != nil {
+		return nil, fmt.Errorf("initial SVID fetch failed: %w", err)
+	}
+	// Only report renewals after the initial fetch
+	<-h.updated
+
+	go h.renew()
+	return h, nil
+}
+
+// Close stops renewing the SVID
+func (h *HeadlessSource) Close() error {
+	select {
+	case <-h.done:
+	default:
+		close(h.done)
+	}
+	return nil
+}
+
+func (h *HeadlessSource) renew() {
+	threshold, interval := h.config.RenewalThreshold, h.config.CheckInterval
+	if threshold <= 0 {
+		threshold = defaultRenewalThreshold
+	}
+	if interval <= 0 {
+		interval = defaultCheckInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-ticker.C:
+			svid, err := h.GetX509SVID()
+			if err == nil && time.Until(svid.Certificates[0].NotAfter) > threshold {
+				continue
+			}
+			if err := h.refresh(); err != nil {
+				h.logf("SVID renewal failed: %v", err)
+			}
+		}
+	}
+}
+
+// refresh fetches a new SVID and bundle from the headless API
+func (h *HeadlessSource) refresh() error {
+	resp, err := h.api.GetOrRefreshSVID(h.config.SPIFFEID)
+	if err != nil {
+		return err
+	}
+	svid, err := x509svid.Parse([]byte(resp.X509SVID), []byte(resp.PrivateKey))
+	if err != nil {
+		return fmt.Errorf("headless API issued an invalid SVID: %w", err)
+	}
+	bundle, err := x509bundle.Parse(svid.ID.TrustDomain(), []byte(resp.Bundle))
+	if err != nil {
+		return fmt.Errorf("headless API issued an invalid bundle: %w", err)
+	}
+
+	h.set(svid, x509bundle.NewSet(bundle))
+	return nil
+}
@@ -0,0 +1,197 @@
+package spiffesdk
+
+import (
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+// Identity is an additional SPIFFE ID managed by the SDK next to
+// Config.SPIFFEID. It is registered, renewed and streamed from the Workload
+// API like the primary identity, sharing the same headless API client and
+// agent connection.
+type Identity struct {
+	// Name refers to the identity in WithIdentity, WithSNIIdentities and
+	// GetX509SVIDFor; the SPIFFE ID can be used as well
+	Name string `json:"name"`
+
+	SPIFFEID    string `json:"spiffe_id"`
+	ServiceType string `json:"service_type"` // Defaults to Config.ServiceType
+
+	// Hint selects this identity's SVID when the Workload API returns several
+	// SVIDs for the same SPIFFE ID
+	Hint string `json:"hint"`
+}
+
+// managedIdentity is an additional identity and its current SVID
+type managedIdentity struct {
+	Identity
+	svid *SVIDCache
+}
+
+// validateIdentities checks the additional identities
+func (c *Config) validateIdentities() error {
+	seen := map[string]bool{c.SPIFFEID: true}
+	for _, identity := range c.Identities {
+		id, err := spiffeid.FromString(identity.SPIFFEID)
+		if err != nil {
+			return fmt.Errorf("invalid identity SPIFFE ID %q: %w", identity.SPIFFEID, err)
+		}
+		if c.TrustDomain != "" && id.TrustDomain().String() != c.TrustDomain {
+			return fmt.Errorf("identity %s is not in trust domain %s", id, c.TrustDomain)
+		}
+		if seen[identity.SPIFFEID] {
+			return fmt.Errorf("duplicate identity %s", id)
+		}
+		seen[identity.SPIFFEID] = true
+		if identity.Name != "" {
+			if seen[identity.Name] {
+				return fmt.Errorf("duplicate identity name %q", identity.Name)
+			}
+			seen[identity.Name] = true
+		}
+	}
+	return nil
+}
+
+// identitySVID returns the SVID cache of the identity with the given name or
+// SPIFFE ID. An empty name selects the primary identity.
+func (s *SpiffeSDK) identitySVID(nameOrID string) (*SVIDCache, error) {
+	if nameOrID == "" || nameOrID == s.config.SPIFFEID {
+		return s.currentSVID, nil
+	}
+	for _, identity := range s.identities {
+		if nameOrID == identity.Name || nameOrID == identity.SPIFFEID {
+			return identity.svid, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown identity %q", nameOrID)
+}
+
+// GetX509SVIDFor returns the current SVID of the identity with the given name
+// or SPIFFE ID
+func (s *SpiffeSDK) GetX509SVIDFor(nameOrID string) (*x509svid.SVID, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	cache, err := s.identitySVID(nameOrID)
+	if err != nil {
+		return nil, err
+	}
+	return cache.x509SVID()
+}
+
+// GetCurrentSVIDFor returns a snapshot of the current SVID of the identity
+// with the given name or SPIFFE ID, or nil if it is unknown or has no SVID yet
+func (s *SpiffeSDK) GetCurrentSVIDFor(nameOrID string) *SVIDCache {
+	cache, err := s.identitySVID(nameOrID)
+	if err != nil {
+		return nil
+	}
+	return cache.snapshot()
+}
+
+// identitySource is an x509svid.Source presenting one of the SDK's identities
+type identitySource struct {
+	sdk      *SpiffeSDK
+	nameOrID string
+}
+
+func (i identitySource) GetX509SVID() (*x509svid.SVID, error) {
+	return i.sdk.GetX509SVIDFor(i.nameOrID)
+}
+
+// ClientOption configures HTTP clients created by GetHTTPClient
+type ClientOption func(*clientOptions)
+
+type clientOptions struct {
+	identity string
+}
+
+// WithIdentity makes the client present the identity with the given name or
+// SPIFFE ID instead of the primary one. Requests fail the TLS handshake if the
+// identity is unknown or has no SVID.
+func WithIdentity(nameOrID string) ClientOption {
+	return func(o *clientOptions) {
+		o.identity = nameOrID
+	}
+}
+
+// WithSNIIdentities makes the server present a different identity depending on
+// the server name the client requests via SNI. identities maps server names to
+// identity names or SPIFFE IDs; other server names get the primary identity.
+func WithSNIIdentities(identities map[string]string) ServerOption {
+	return func(o *serverOptions) {
+		o.sniIdentities = identities
+	}
+}
+
+// applySNIIdentities makes config choose the server certificate by SNI
+func (s *SpiffeSDK) applySNIIdentities(config *tls.Config, identities map[string]string) error {
+	getCertificates := make(map[string]func(*tls.ClientHelloInfo) (*tls.Certificate, error), len(identities))
+	for serverName, nameOrID := range identities {
+		if _, err := s.identitySVID(nameOrID); err != nil {
+			return fmt.Errorf("server name %q: %w", serverName, err)
+		}
+		getCertificates[serverName] = tlsconfig.GetCertificate(identitySource{s, nameOrID})
+	}
+
+	getDefault := config.GetCertificate
+	config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if getCertificate, ok := getCertificates[hello.ServerName]; ok {
+			return getCertificate(hello)
+		}
+		return getDefault(hello)
+	}
+	return nil
+}
+
+// registerIdentities registers the additional identities with the headless API
+func (s *SpiffeSDK) registerIdentities() error {
+	for _, identity := range s.identities {
+		serviceType := identity.ServiceType
+		if serviceType == "" {
+			serviceType = s.config.ServiceType
+		}
+		payload, err := s.config.registrationPayloadFor(identity.SPIFFEID, serviceType)
+		if err != nil {
+			return fmt.Errorf("registration of %s failed: %w", identity.SPIFFEID, err)
+		}
+		if err := s.headlessAPI.RegisterAndIssueSVID(payload); err != nil {
+			return fmt.Errorf("registration of %s failed: %w", identity.SPIFFEID, err)
+		}
+	}
+	return nil
+}
+
+// refreshIdentitySVID fetches a new SVID for an additional identity from the
+// headless API
+func (s *SpiffeSDK) refreshIdentitySVID(identity *managedIdentity) error {
+	svid, err := s.headlessAPI.GetOrRefreshSVID(identity.SPIFFEID)
+	if err != nil {
+		return err
+	}
+	if err := identity.svid.update(svid.X509SVID, svid.PrivateKey, svid.Bundle, svid.IssuedAt, SVIDSourceHeadlessAPI); err != nil {
+		s.rejectSVID(identity.SPIFFEID, SVIDSourceHeadlessAPI, err)
+		return fmt.Errorf("headless API issued an invalid SVID for %s: %w", identity.SPIFFEID, err)
+	}
+	return nil
+}
+
+// renewIdentities renews additional identities that expire within the
+// renewal threshold, or have no SVID at all
+func (s *SpiffeSDK) renewIdentities() {
+	for _, identity := range s.identities {
+		current := identity.svid.snapshot()
+		if current != nil && time.Until(current.ExpiresAt) > s.config.RenewalThreshold {
+			continue
+		}
+		if err := s.refreshIdentitySVID(identity); err != nil {
+			s.logf("SVID renewal for %s failed: %v", identity.SPIFFEID, err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package spiffesdk_test
+
+import (
+	"crypto/tls"
+	"strings"
+	"testing"
+	"time"
+
+	spiffesdk "github.com/authsec-ai/spiffe-sdk"
+	"github.com/authsec-ai/spiffe-sdk/spiffetest"
+	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+const (
+	adminID   = "spiffe://authsec.dev/payment-admin"
+	metricsID = "spiffe://authsec.dev/payment-metrics"
+)
+
+// withExtraIdentities adds the admin and metrics identities to a headless SDK
+func withExtraIdentities(config *spiffesdk.Config) {
+	config.Identities = []spiffesdk.Identity{
+		{Name: "admin", SPIFFEID: adminID},
+		{Name: "metrics", SPIFFEID: metricsID},
+	}
+}
+
+func TestSNISelectsIdentity(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	server := newHeadlessSDK(t, spiffetest.NewHeadlessServer(t, ca), withExtraIdentities)
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	srv := spiffetest.NewServer(t, server, okHandler(), spiffesdk.WithSNIIdentities(map[string]string{
+		"admin.payments.internal":   "admin",
+		"metrics.payments.internal": metricsID,
+	}))
+	client := ca.NewSDK(t, clientID)
+
+	tests := []struct {
+		serverName string
+		want       string
+	}{
+		{"admin.payments.internal", adminID},
+		{"metrics.payments.internal", metricsID},
+		{"payments.internal", paymentID},
+		{"", paymentID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.serverName, func(t *testing.T) {
+			config := tlsconfig.MTLSClientConfig(client, client, tlsconfig.AuthorizeAny())
+			config.ServerName = tt.serverName
+			conn, err := tls.Dial("tcp", strings.TrimPrefix(srv.URL, "https://"), config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			id, err := x509svid.IDFromCert(conn.ConnectionState().PeerCertificates[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id.String() != tt.want {
+				t.Errorf("server presented %s, want %s", id, tt.want)
+			}
+		})
+	}
+}
+
+func TestRejectedIdentitySVIDLeavesOthersUntouched(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	headless := spiffetest.NewHeadlessServer(t, ca)
+	// SVIDs within the renewal threshold are renewed on every pass
+	headless.SVIDTTL = time.Minute
+
+	events := make(chan spiffesdk.Event, 16)
+	sdk := newHeadlessSDK(t, headless, withExtraIdentities, func(config *spiffesdk.Config) {
+		config.OnEvent = func(event spiffesdk.Event) { events <- event }
+	})
+	if err := sdk.Start(); err != nil {
+		t.Fatal(err)
+	}
+	primary := sdk.GetCurrentSVIDFor("")
+	admin := sdk.GetCurrentSVIDFor("admin")
+	metrics := sdk.GetCurrentSVIDFor("metrics")
+
+	// The admin identity is renewed first and gets an expired SVID
+	headless.InjectFault(spiffetest.EndpointIssueSVID, spiffetest.Fault{ExpiredSVID: true, Times: 1})
+	sdk.RenewIdentities()
+
+	if got := sdk.GetCurrentSVIDFor("admin"); got.SVID != admin.SVID {
+		t.Error("admin SVID was replaced by the rejected one")
+	}
+	if got := sdk.GetCurrentSVIDFor("metrics"); got.SVID == metrics.SVID {
+		t.Error("metrics SVID was not renewed after the admin SVID was rejected")
+	}
+	if got := sdk.GetCurrentSVIDFor(""); got.SVID != primary.SVID {
+		t.Error("primary SVID changed during identity renewal")
+	}
+	if _, err := sdk.GetX509SVIDFor("admin"); err != nil {
+		t.Errorf("GetX509SVIDFor(admin) error = %v", err)
+	}
+
+	for {
+		select {
+		case event := <-events:
+			if event.Type != spiffesdk.EventSVIDRejected {
+				continue
+			}
+			if id := event.Attributes["spiffe_id"]; id != adminID {
+				t.Errorf("rejected SVID for %s, want %s", id, adminID)
+			}
+			return
+		case <-time.After(5 * time.Second):
+			t.Fatal("no SVID rejection event")
+		}
+	}
+}
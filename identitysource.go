@@ -0,0 +1,338 @@
+package spiffesdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+)
+
+// IdentitySource provides the SVID a service presents and the X.509 bundles it
+// trusts. Implementations ship for the Workload API, the headless API, PEM
+// files on disk and in-memory SVIDs, and CompositeSource combines them in
+// priority order. Pass one to NewSpiffeSDKWithSource; every TLS config, HTTP
+// client, middleware and gRPC credential the SDK creates then uses it.
+type IdentitySource interface {
+	x509svid.Source
+	x509bundle.Source
+
+	// Updated returns a channel that receives a value whenever the SVID or
+	// bundles change. It is meant for a single consumer.
+	Updated() <-chan struct{}
+
+	// Close releases the source's resources
+	Close() error
+}
+
+// loggingSource is a source that can log to the SDK's Config.Logger
+type loggingSource interface {
+	setLogger(logger *log.Logger)
+}
+
+// SVIDSourceIdentitySource is reported in SVIDCache.Source for SDKs created
+// with NewSpiffeSDKWithSource
+const SVIDSourceIdentitySource = "identity_source"
+
+// NewSpiffeSDKWithSource creates an SDK whose identity comes from source
+// instead of the built-in Workload API and headless API handling. config may
+// be nil; its SPIFFEID and TrustDomain are filled in from the source's SVID.
+// Start follows the source's updates and starts federation; it does not
+// register with the headless API. Close also closes source.
+func NewSpiffeSDKWithSource(config *Config, source IdentitySource) (*SpiffeSDK, error) {
+	if config == nil {
+		config = &Config{}
+	}
+	if len(config.Identities) > 0 {
+		return nil, errors.New("SDKs with an identity source support a single identity")
+	}
+	svid, err := source.GetX509SVID()
+	if err != nil {
+		return nil, fmt.Errorf("identity source has no SVID: %w", err)
+	}
+	if err := config.adoptIdentity(svid.ID); err != nil {
+		return nil, err
+	}
+
+	sdk, err := newSDK(config)
+	if err != nil {
+		return nil, err
+	}
+	sdk.source = source
+	if config.Logger != nil {
+		if source, ok := source.(loggingSource); ok {
+			source.setLogger(config.Logger)
+		}
+	}
+	if err := sdk.syncIdentitySource(); err != nil {
+		return nil, err
+	}
+
+	sdk.setupTLSConfig()
+	return sdk, nil
+}
+
+// adoptIdentity checks id against the configured SPIFFE ID and trust domain,
+// filling them in if they are empty
+func (c *Config) adoptIdentity(id spiffeid.ID) error {
+	td := id.TrustDomain()
+	if c.SPIFFEID != "" && c.SPIFFEID != id.String() {
+		return fmt.Errorf("SVID is for %s, not the configured %s", id, c.SPIFFEID)
+	}
+	if c.TrustDomain != "" && c.TrustDomain != td.String() {
+		return fmt.Errorf("SVID is for trust domain %s, not the configured %s", td, c.TrustDomain)
+	}
+	c.SPIFFEID = id.String()
+	c.TrustDomain = td.String()
+	return nil
+}
+
+// watchIdentitySource installs every SVID the identity source publishes. It
+// also re-checks periodically, since a CompositeSource switches to a lower
+// priority source when an SVID expires without any source changing.
+func (s *SpiffeSDK) watchIdentitySource() {
+	interval := s.config.CheckInterval
+	if interval <= 0 {
+		interval = defaultCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.source.Updated():
+		case <-ticker.C:
+		}
+		if err := s.syncIdentitySource(); err != nil {
+			s.logf("Ignoring identity source update: %v", err)
+			s.markDegraded()
+		} else {
+			s.markReady()
+		}
+	}
+}
+
+// syncIdentitySource installs the identity source's current SVID
+func (s *SpiffeSDK) syncIdentitySource() error {
+	svid, err := s.source.GetX509SVID()
+	if err != nil {
+		return err
+	}
+	if svid.ID.String() != s.config.SPIFFEID {
+		return fmt.Errorf("identity source returned an SVID for %s, not %s", svid.ID, s.config.SPIFFEID)
+	}
+	return s.installSVID(svid, s.source, SVIDSourceIdentitySource)
+}
+
+// NewWorkloadAPISource connects to the SPIRE agent at socketPath and waits for
+// the first SVID. The source reconnects on its own if the agent restarts.
+func NewWorkloadAPISource(ctx context.Context, socketPath string) (*workloadapi.X509Source, error) {
+	return workloadapi.NewX509Source(ctx, workloadapi.WithClientOptions(
+		workloadapi.WithAddr("unix://"+socketPath),
+	))
+}
+
+// sourceState holds the current SVID and bundles of a source and signals
+// updates
+type sourceState struct {
+	mu      sync.RWMutex
+	svid    *x509svid.SVID
+	bundles *x509bundle.Set
+	logger  *log.Logger
+	updated chan struct{}
+}
+
+func newSourceState() *sourceState {
+	return &sourceState{updated: make(chan struct{}, 1)}
+}
+
+// set replaces the SVID and bundles and notifies the consumer
+func (st *sourceState) set(svid *x509svid.SVID, bundles *x509bundle.Set) {
+	st.mu.Lock()
+	st.svid = svid
+	st.bundles = bundles
+	st.mu.Unlock()
+
+	select {
+	case st.updated <- struct{}{}:
+	default:
+	}
+}
+
+// setLogger makes the source log to logger instead of stdout
+func (st *sourceState) setLogger(logger *log.Logger) {
+	st.mu.Lock()
+	st.logger = logger
+	st.mu.Unlock()
+}
+
+// logf writes a message to the source's logger
+func (st *sourceState) logf(format string, args ...interface{}) {
+	st.mu.RLock()
+	logger := st.logger
+	st.mu.RUnlock()
+
+	if logger == nil {
+		logger = defaultLogger
+	}
+	logger.Printf(format, args...)
+}
+
+// GetX509SVID returns the current SVID. It implements x509svid.Source.
+func (st *sourceState) GetX509SVID() (*x509svid.SVID, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	if st.svid == nil {
+		return nil, errors.New("no SVID has been issued yet")
+	}
+	return st.svid, nil
+}
+
+// GetX509BundleForTrustDomain returns the bundle for trustDomain. It
+// implements x509bundle.Source.
+func (st *sourceState) GetX509BundleForTrustDomain(trustDomain spiffeid.TrustDomain) (*x509bundle.Bundle, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	if st.bundles == nil {
+		return nil, fmt.Errorf("no bundle for trust domain %q", trustDomain)
+	}
+	return st.bundles.GetX509BundleForTrustDomain(trustDomain)
+}
+
+// Updated returns a channel that receives a value whenever the source changes
+func (st *sourceState) Updated() <-chan struct{} {
+	return st.updated
+}
+
+// StaticSource is an IdentitySource holding a fixed SVID and bundles, for
+// tests and SVIDs provisioned out of band
+type StaticSource struct {
+	*sourceState
+}
+
+// NewStaticSource returns a source that always presents svid and trusts
+// bundles
+func NewStaticSource(svid *x509svid.SVID, bundles *x509bundle.Set) *StaticSource {
+	st := newSourceState()
+	st.svid = svid
+	st.bundles = bundles
+	return &StaticSource{sourceState: st}
+}
+
+// Set replaces the SVID and bundles, e.g. to simulate a rotation in tests
+func (s *StaticSource) Set(svid *x509svid.SVID, bundles *x509bundle.Set) {
+	s.set(svid, bundles)
+}
+
+// Close does nothing
+func (s *StaticSource) Close() error {
+	return nil
+}
+
+// CompositeSource combines identity sources in priority order. The SVID comes
+// from the first source that has one that hasn't expired, so e.g. the Workload
+// API can be preferred with the headless API or files as a fallback. Bundles
+// are looked up in the same order.
+type CompositeSource struct {
+	sources   []IdentitySource
+	updated   chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewCompositeSource combines sources, highest priority first. The composite
+// takes ownership of the sources: it consumes their Updated channels and
+// closes them on Close.
+func NewCompositeSource(sources ...IdentitySource) *CompositeSource {
+	c := &CompositeSource{
+		sources: sources,
+		updated: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	for _, source := range sources {
+		go c.forwardUpdates(source)
+	}
+	return c
+}
+
+func (c *CompositeSource) forwardUpdates(source IdentitySource) {
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-source.Updated():
+			select {
+			case c.updated <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// GetX509SVID returns the SVID of the highest priority source that has a
+// valid one. It implements x509svid.Source.
+func (c *CompositeSource) GetX509SVID() (*x509svid.SVID, error) {
+	var errs []error
+	for i, source := range c.sources {
+		svid, err := source.GetX509SVID()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("source %d: %w", i, err))
+			continue
+		}
+		if expiresAt := svid.Certificates[0].NotAfter; !time.Now().Before(expiresAt) {
+			errs = append(errs, fmt.Errorf("source %d: SVID expired at %v", i, expiresAt))
+			continue
+		}
+		return svid, nil
+	}
+	return nil, fmt.Errorf("no identity source has a valid SVID: %w", errors.Join(errs...))
+}
+
+// GetX509BundleForTrustDomain returns the bundle from the highest priority
+// source that has one. It implements x509bundle.Source.
+func (c *CompositeSource) GetX509BundleForTrustDomain(trustDomain spiffeid.TrustDomain) (*x509bundle.Bundle, error) {
+	for _, source := range c.sources {
+		if bundle, err := source.GetX509BundleForTrustDomain(trustDomain); err == nil {
+			return bundle, nil
+		}
+	}
+	return nil, fmt.Errorf("no bundle for trust domain %q", trustDomain)
+}
+
+// setLogger passes logger on to the combined sources
+func (c *CompositeSource) setLogger(logger *log.Logger) {
+	for _, source := range c.sources {
+		if source, ok := source.(loggingSource); ok {
+			source.setLogger(logger)
+		}
+	}
+}
+
+// Updated returns a channel that receives a value whenever any source changes
+func (c *CompositeSource) Updated() <-chan struct{} {
+	return c.updated
+}
+
+// Close closes every source
+func (c *CompositeSource) Close() error {
+	var errs []error
+	c.closeOnce.Do(func() {
+		close(c.done)
+		for _, source := range c.sources {
+			if err := source.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	})
+	return errors.Join(errs...)
+}
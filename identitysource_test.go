@@ -0,0 +1,151 @@
+package spiffesdk_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	spiffesdk "github.com/authsec-ai/spiffe-sdk"
+	"github.com/authsec-ai/spiffe-sdk/spiffetest"
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+func TestCompositeSourceFallsBackWhenPrimarySVIDExpires(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	bundles := x509bundle.NewSet(ca.X509Bundle())
+	primarySVID := ca.CreateX509SVID(paymentID, time.Second)
+	fallbackSVID := ca.CreateX509SVID(paymentID, time.Hour)
+
+	composite := spiffesdk.NewCompositeSource(
+		spiffesdk.NewStaticSource(primarySVID, bundles),
+		spiffesdk.NewStaticSource(fallbackSVID, bundles),
+	)
+	sdk, err := spiffesdk.NewSpiffeSDKWithSource(&spiffesdk.Config{CheckInterval: 50 * time.Millisecond}, composite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = sdk.Close(context.Background()) })
+	if err := sdk.Start(); err != nil {
+		t.Fatal(err)
+	}
+	assertSourceSerial(t, sdk, primarySVID)
+
+	// Neither source changes; the SDK notices the expiry on its next check
+	waitForSerial(t, sdk, fallbackSVID)
+	if state := sdk.State(); state != spiffesdk.StateReady {
+		t.Errorf("State() = %v, want %v", state, spiffesdk.StateReady)
+	}
+}
+
+func TestCompositeSourcePrefersHigherPriority(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	bundles := x509bundle.NewSet(ca.X509Bundle())
+	primary := spiffesdk.NewStaticSource(ca.CreateX509SVID(paymentID, -time.Minute), bundles)
+	fallbackSVID := ca.CreateX509SVID(paymentID, time.Hour)
+	composite := spiffesdk.NewCompositeSource(primary, spiffesdk.NewStaticSource(fallbackSVID, bundles))
+	t.Cleanup(func() { _ = composite.Close() })
+
+	svid, err := composite.GetX509SVID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svid != fallbackSVID {
+		t.Error("GetX509SVID() returned the expired primary SVID, want the fallback")
+	}
+
+	// Once the primary is renewed it takes over again
+	renewed := ca.CreateX509SVID(paymentID, time.Hour)
+	primary.Set(renewed, bundles)
+	select {
+	case <-composite.Updated():
+	case <-time.After(5 * time.Second):
+		t.Fatal("composite did not report the primary's update")
+	}
+	if svid, _ := composite.GetX509SVID(); svid != renewed {
+		t.Error("GetX509SVID() did not return the renewed primary SVID")
+	}
+}
+
+func TestFileSourceReloadsChangedFiles(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "svid.pem")
+	keyFile := filepath.Join(dir, "svid_key.pem")
+	bundleFile := filepath.Join(dir, "bundle.pem")
+
+	// Each write moves the modification times forward, so a coarse file
+	// system clock can't hide a change
+	modTime := time.Now()
+	writeFiles := func(cert, key, bundle string) {
+		t.Helper()
+		modTime = modTime.Add(time.Second)
+		for path, data := range map[string]string{certFile: cert, keyFile: key, bundleFile: bundle} {
+			if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(path, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	first := ca.CreateX509SVID(paymentID, time.Hour)
+	cert, key := marshalSVID(t, first)
+	writeFiles(cert, key, marshalBundle(t, ca))
+
+	source, err := spiffesdk.NewFileSource(certFile, keyFile, bundleFile, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = source.Close() })
+	assertSourceSerial(t, source, first)
+
+	second := ca.CreateX509SVID(paymentID, time.Hour)
+	cert, key = marshalSVID(t, second)
+	writeFiles(cert, key, marshalBundle(t, ca))
+	select {
+	case <-source.Updated():
+	case <-time.After(5 * time.Second):
+		t.Fatal("FileSource did not report the changed files")
+	}
+	assertSourceSerial(t, source, second)
+
+	// A broken rewrite keeps the previous SVID
+	writeFiles("not a certificate", key, marshalBundle(t, ca))
+	time.Sleep(200 * time.Millisecond)
+	select {
+	case <-source.Updated():
+		t.Error("FileSource reported an update for an invalid certificate")
+	default:
+	}
+	assertSourceSerial(t, source, second)
+}
+
+func assertSourceSerial(t *testing.T, source x509svid.Source, want *x509svid.SVID) {
+	t.Helper()
+	svid, err := source.GetX509SVID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := svid.Certificates[0].SerialNumber; got.Cmp(want.Certificates[0].SerialNumber) != 0 {
+		t.Errorf("SVID serial = %x, want %x", got, want.Certificates[0].SerialNumber)
+	}
+}
+
+func waitForSerial(t *testing.T, sdk *spiffesdk.SpiffeSDK, want *x509svid.SVID) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		svid, err := sdk.GetX509SVID()
+		if err == nil && svid.Certificates[0].SerialNumber.Cmp(want.Certificates[0].SerialNumber) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("SDK did not switch to SVID %x", want.Certificates[0].SerialNumber)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,394 @@
+package spiffesdk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/jwtbundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+)
+
+const (
+	// jwtSVIDRefreshMargin is how long before expiry a cached JWT-SVID is replaced
+	jwtSVIDRefreshMargin = 30 * time.Second
+
+	// jwtSVIDFetchTimeout bounds a JWT-SVID fetch shared by concurrent callers
+	jwtSVIDFetchTimeout = 30 * time.Second
+
+	// jwtBundleRefreshInterval is how long a JWT bundle from the headless API is reused
+	jwtBundleRefreshInterval = 5 * time.Minute
+
+	// jwtBundleMinRefetch rate-limits forced bundle refreshes after a failed validation
+	jwtBundleMinRefetch = 30 * time.Second
+)
+
+// JWTSVIDResponse is a JWT-SVID issued by the headless API
+type JWTSVIDResponse struct {
+	SPIFFEID  string    `json:"spiffe_id"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// jwtSVIDCache holds JWT-SVIDs per audience set and the headless JWT bundle
+type jwtSVIDCache struct {
+	mu       sync.Mutex
+	svids    map[string]*jwtsvid.SVID
+	inflight map[string]*jwtSVIDFetch // Fetches in progress, by audience set
+
+	bundleMu        sync.Mutex
+	bundle          *jwtbundle.Bundle
+	bundleFetchedAt time.Time
+}
+
+func newJWTSVIDCache() *jwtSVIDCache {
+	return &jwtSVIDCache{
+		svids:    make(map[string]*jwtsvid.SVID),
+		inflight: make(map[string]*jwtSVIDFetch),
+	}
+}
+
+// jwtSVIDFetch is a fetch shared by concurrent callers asking for the same
+// audiences. done is closed once svid and err are set.
+type jwtSVIDFetch struct {
+	done chan struct{}
+	svid *jwtsvid.SVID
+	err  error
+}
+
+// FetchJWTSVID returns a JWT-SVID for this service valid for the given
+// audiences. Tokens are fetched from the workload API when connected, otherwise
+// from the headless API, and cached until shortly before they expire.
+// Concurrent calls for the same audiences share one fetch; other audiences
+// aren't held up by it. Cancelling ctx only stops this call from waiting.
+func (s *SpiffeSDK) FetchJWTSVID(ctx context.Context, audience string, extraAudiences ...string) (*jwtsvid.SVID, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	audiences := append([]string{audience}, extraAudiences...)
+	key := audienceKey(audiences)
+
+	cache := s.jwtCache
+	cache.mu.Lock()
+	if svid, ok := cache.svids[key]; ok && time.Until(svid.Expiry) > jwtSVIDRefreshMargin {
+		cache.mu.Unlock()
+		return svid, nil
+	}
+	fetch, ok := cache.inflight[key]
+	if !ok {
+		fetch = &jwtSVIDFetch{done: make(chan struct{})}
+		cache.inflight[key] = fetch
+		if !s.goBackground(func() { s.runJWTSVIDFetch(key, fetch, audience, extraAudiences) }) {
+			delete(cache.inflight, key)
+			cache.mu.Unlock()
+			return nil, ErrClosed
+		}
+	}
+	cache.mu.Unlock()
+
+	select {
+	case <-fetch.done:
+		return fetch.svid, fetch.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// runJWTSVIDFetch performs a shared fetch. It runs on the SDK's context rather
+// than any caller's, so one caller giving up doesn't fail the others.
+func (s *SpiffeSDK) runJWTSVIDFetch(key string, fetch *jwtSVIDFetch, audience string, extraAudiences []string) {
+	ctx, cancel := context.WithTimeout(s.ctx, jwtSVIDFetchTimeout)
+	defer cancel()
+
+	fetch.svid, fetch.err = s.fetchJWTSVID(ctx, audience, extraAudiences)
+
+	cache := s.jwtCache
+	cache.mu.Lock()
+	delete(cache.inflight, key)
+	if fetch.err == nil {
+		cache.svids[key] = fetch.svid
+	}
+	cache.mu.Unlock()
+	close(fetch.done)
+}
+
+func (s *SpiffeSDK) fetchJWTSVID(ctx context.Context, audience string, extraAudiences []string) (*jwtsvid.SVID, error) {
+	source := s.activeJWTSource()
+
+	if source != nil {
+		return source.FetchJWTSVID(ctx, jwtsvid.Params{
+			Audience:       audience,
+			ExtraAudiences: extraAudiences,
+		})
+	}
+
+	audiences := append([]string{audience}, extraAudiences...)
+	resp, err := s.headlessAPI.IssueJWTSVID(ctx, s.config.SPIFFEID, audiences)
+	if err != nil {
+		return nil, err
+	}
+
+	// The token comes straight from our own issuer over the headless API, so it
+	// is parsed without signature verification; audience and expiry are still checked
+	svid, err := jwtsvid.ParseInsecure(resp.Token, audiences)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT-SVID from headless API: %w", err)
+	}
+	return svid, nil
+}
+
+// ValidateJWTSVID verifies a JWT-SVID token against the JWT bundles and checks
+// that it was issued for audience
+func (s *SpiffeSDK) ValidateJWTSVID(token, audience string) (*jwtsvid.SVID, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	svid, err := jwtsvid.ParseAndValidate(token, s, []string{audience})
+	if err == nil || !s.refreshStaleJWTBundle() {
+		return svid, err
+	}
+
+	// The signing key may have rotated since the bundle was fetched
+	return jwtsvid.ParseAndValidate(token, s, []string{audience})
+}
+
+// GetJWTBundleForTrustDomain returns the JWT bundle used to verify JWT-SVIDs.
+// It implements jwtbundle.Source.
+func (s *SpiffeSDK) GetJWTBundleForTrustDomain(trustDomain spiffeid.TrustDomain) (*jwtbundle.Bundle, error) {
+	if bundle, ok := s.federatedBundles.Get(trustDomain); ok {
+		return bundle.JWTBundle(), nil
+	}
+
+	if source := s.activeJWTSource(); source != nil {
+		return source.GetJWTBundleForTrustDomain(trustDomain)
+	}
+
+	localTD, err := spiffeid.TrustDomainFromString(s.config.TrustDomain)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trust domain %q: %w", s.config.TrustDomain, err)
+	}
+	if trustDomain != localTD {
+		return nil, fmt.Errorf("no JWT bundle for trust domain %q", trustDomain)
+	}
+
+	s.jwtCache.bundleMu.Lock()
+	defer s.jwtCache.bundleMu.Unlock()
+
+	// Static SDKs have no headless API to fetch the bundle from
+	if s.static {
+		if s.jwtCache.bundle == nil {
+			return nil, fmt.Errorf("no JWT bundle for trust domain %q", trustDomain)
+		}
+		return s.jwtCache.bundle, nil
+	}
+
+	if s.jwtCache.bundle == nil || time.Since(s.jwtCache.bundleFetchedAt) > jwtBundleRefreshInterval {
+		if err := s.fetchJWTBundleLocked(localTD); err != nil {
+			if s.jwtCache.bundle == nil {
+				return nil, err
+			}
+			// Keep using the last good bundle
+			s.logf("JWT bundle refresh failed: %v", err)
+		}
+	}
+	return s.jwtCache.bundle, nil
+}
+
+// refreshStaleJWTBundle re-fetches the headless JWT bundle unless it was
+// fetched very recently, and reports whether a new bundle was loaded
+func (s *SpiffeSDK) refreshStaleJWTBundle() bool {
+	if s.activeJWTSource() != nil || s.static {
+		return false
+	}
+
+	localTD, err := spiffeid.TrustDomainFromString(s.config.TrustDomain)
+	if err != nil {
+		return false
+	}
+
+	s.jwtCache.bundleMu.Lock()
+	defer s.jwtCache.bundleMu.Unlock()
+
+	if time.Since(s.jwtCache.bundleFetchedAt) < jwtBundleMinRefetch {
+		return false
+	}
+	return s.fetchJWTBundleLocked(localTD) == nil
+}
+
+func (s *SpiffeSDK) fetchJWTBundleLocked(trustDomain spiffeid.TrustDomain) error {
+	// Record the attempt even on failure so a broken endpoint isn't hammered
+	s.jwtCache.bundleFetchedAt = time.Now()
+
+	bundle, err := s.headlessAPI.FetchJWTBundle(trustDomain)
+	if err != nil {
+		return err
+	}
+	s.jwtCache.bundle = bundle
+	return nil
+}
+
+// JWTAttachmentMiddleware returns a RoundTripper that adds this service's
+// JWT-SVID for audience to every request as an Authorization: Bearer header.
+// A nil rt uses http.DefaultTransport.
+func (s *SpiffeSDK) JWTAttachmentMiddleware(rt http.RoundTripper, audience string) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &jwtTransport{
+		sdk:       s,
+		audience:  audience,
+		transport: rt,
+	}
+}
+
+// jwtTransport implements http.RoundTripper with JWT-SVID bearer tokens
+type jwtTransport struct {
+	sdk       *SpiffeSDK
+	audience  string
+	transport http.RoundTripper
+}
+
+func (t *jwtTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	svid, err := t.sdk.FetchJWTSVID(req.Context(), t.audience)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWT-SVID: %w", err)
+	}
+
+	// RoundTrippers must not modify the caller's request
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+svid.Marshal())
+
+	return t.transport.RoundTrip(req)
+}
+
+// JWTValidationMiddleware rejects requests without a valid JWT-SVID for
+// audience in the Authorization: Bearer header. The caller's SPIFFE ID is added
+// to the request context and can be read with SPIFFEIDFromContext.
+func (s *SpiffeSDK) JWTValidationMiddleware(audience string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="spiffe"`)
+			http.Error(w, "Missing bearer token", http.StatusUnauthorized)
+			return
+		}
+
+		svid, err := s.ValidateJWTSVID(token, audience)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="spiffe", error="invalid_token"`)
+			http.Error(w, "Invalid JWT-SVID", http.StatusUnauthorized)
+			return
+		}
+		if err := s.checkDeniedID(svid.ID); err != nil {
+			http.Error(w, "Caller is denied", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(withSPIFFEID(r.Context(), svid.ID.String())))
+	})
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(auth[len(prefix):]), true
+}
+
+// audienceKey is the cache key for a set of audiences, independent of order
+func audienceKey(audiences []string) string {
+	sorted := append([]string(nil), audiences...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, "\x00")
+}
+
+// IssueJWTSVID issues a JWT-SVID for a registered workload
+func (api *HeadlessAPI) IssueJWTSVID(ctx context.Context, spiffeID string, audience []string) (*JWTSVIDResponse, error) {
+	if len(audience) == 0 {
+		return nil, errors.New("at least one audience is required")
+	}
+
+	workloadID, err := api.findWorkloadID(ctx, spiffeID)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"audience": audience,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", api.BaseURL+"/spiresvc/api/v1/workloads/"+workloadID+"/jwt-svid", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JWT-SVID request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if err := api.attest(req); err != nil {
+		return nil, err
+	}
+
+	resp, err := api.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send JWT-SVID request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("JWT-SVID issuance failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var svid JWTSVIDResponse
+	if err := json.NewDecoder(resp.Body).Decode(&svid); err != nil {
+		return nil, fmt.Errorf("failed to decode JWT-SVID response: %w", err)
+	}
+
+	return &svid, nil
+}
+
+// FetchJWTBundle fetches the trust domain's JWT signing keys (JWKS)
+func (api *HeadlessAPI) FetchJWTBundle(trustDomain spiffeid.TrustDomain) (*jwtbundle.Bundle, error) {
+	req, err := http.NewRequest("GET", api.BaseURL+"/spiresvc/api/v1/bundles/jwt", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if err := api.attest(req); err != nil {
+		return nil, err
+	}
+
+	resp, err := api.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWT bundle: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWT bundle fetch failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	bundle, err := jwtbundle.Parse(trustDomain, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWT bundle: %w", err)
+	}
+
+	return bundle, nil
+}
@@ -0,0 +1,62 @@
+package spiffesdk_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/authsec-ai/spiffe-sdk/spiffetest"
+)
+
+func TestFetchJWTSVIDSharedFetchOutlivesCaller(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	headless := spiffetest.NewHeadlessServer(t, ca)
+	sdk := newHeadlessSDK(t, headless)
+	if err := sdk.Start(); err != nil {
+		t.Fatal(err)
+	}
+	headless.InjectFault(spiffetest.EndpointIssueJWTSVID, spiffetest.Fault{Latency: 300 * time.Millisecond, Times: 1})
+
+	// The first caller starts the fetch, then gives up
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := sdk.FetchJWTSVID(leaderCtx, "ledger")
+		leaderErr <- err
+	}()
+	for len(headless.Requests(spiffetest.EndpointIssueJWTSVID)) == 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	waiterCtx, cancelWaiter := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelWaiter()
+	type result struct {
+		audience []string
+		err      error
+	}
+	waiter := make(chan result, 1)
+	go func() {
+		svid, err := sdk.FetchJWTSVID(waiterCtx, "ledger")
+		if err != nil {
+			waiter <- result{err: err}
+			return
+		}
+		waiter <- result{audience: svid.Audience}
+	}()
+
+	cancelLeader()
+	if err := <-leaderErr; err != context.Canceled {
+		t.Errorf("cancelled caller got %v, want context.Canceled", err)
+	}
+
+	got := <-waiter
+	if got.err != nil {
+		t.Fatalf("waiter failed after the first caller was cancelled: %v", got.err)
+	}
+	if len(got.audience) != 1 || got.audience[0] != "ledger" {
+		t.Errorf("audience = %v, want [ledger]", got.audience)
+	}
+	if n := len(headless.Requests(spiffetest.EndpointIssueJWTSVID)); n != 1 {
+		t.Errorf("headless API saw %d JWT-SVID requests, want 1 shared fetch", n)
+	}
+}
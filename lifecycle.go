@@ -0,0 +1,221 @@
+package spiffesdk
+
+import (
+	"context"
+	"errors"
+)
+
+// State is the lifecycle state of an SDK
+type State int
+
+const (
+	// StateNew is an SDK that has not been started
+	StateNew State = iota
+
+	// StateStarting is an SDK registering and fetching its first SVID
+	StateStarting
+
+	// StateReady is an SDK holding a current SVID that is kept renewed
+	StateReady
+
+	// StateDegraded is an SDK still serving its last SVID while renewal, the
+	// Workload API or its identity source is failing
+	StateDegraded
+
+	// StateClosed is an SDK that has been closed. It cannot be restarted.
+	StateClosed
+)
+
+// String returns the state's name, e.g. "ready"
+func (st State) String() string {
+	switch st {
+	case StateNew:
+		return "new"
+	case StateStarting:
+		return "starting"
+	case StateReady:
+		return "ready"
+	case StateDegraded:
+		return "degraded"
+	case StateClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// ErrClosed is returned when a closed SDK is used
+var ErrClosed = errors.New("SDK is closed")
+
+// stateChangeBuffer is how many transitions a StateChanges channel holds
+// before further ones are dropped for that receiver
+const stateChangeBuffer = 16
+
+// State returns the SDK's current lifecycle state
+func (s *SpiffeSDK) State() State {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return s.state
+}
+
+// StateChanges returns a channel that receives every subsequent state
+// transition. It is closed after StateClosed has been delivered. A receiver
+// that falls behind misses transitions; State always has the latest one.
+func (s *SpiffeSDK) StateChanges() <-chan State {
+	ch := make(chan State, stateChangeBuffer)
+
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	if s.state == StateClosed {
+		ch <- StateClosed
+		close(ch)
+		return ch
+	}
+	s.stateSubscribers = append(s.stateSubscribers, ch)
+	return ch
+}
+
+// setState moves the SDK to state. Closed SDKs stay closed.
+func (s *SpiffeSDK) setState(state State) {
+	s.stateMu.Lock()
+	previous := s.state
+	if previous == state || previous == StateClosed {
+		s.stateMu.Unlock()
+		return
+	}
+	s.state = state
+	for _, ch := range s.stateSubscribers {
+		select {
+		case ch <- state:
+		default:
+		}
+		if state == StateClosed {
+			close(ch)
+		}
+	}
+	if state == StateClosed {
+		s.stateSubscribers = nil
+	}
+	s.stateMu.Unlock()
+
+	s.emit(Event{
+		Type:    EventStateChanged,
+		Message: "SDK is " + state.String(),
+		Attributes: map[string]string{
+			"state":    state.String(),
+			"previous": previous.String(),
+		},
+	})
+}
+
+// markReady records that the SDK holds a fresh SVID again. It only moves
+// between StateReady and StateDegraded.
+func (s *SpiffeSDK) markReady() {
+	if s.State() == StateDegraded {
+		s.setState(StateReady)
+	}
+}
+
+// markDegraded records that the SDK can't renew its SVID. It only moves
+// between StateReady and StateDegraded.
+func (s *SpiffeSDK) markDegraded() {
+	if s.State() == StateReady {
+		s.setState(StateDegraded)
+	}
+}
+
+// goBackground runs fn in a goroutine that Close waits for. It reports false,
+// without running fn, once the SDK is closed.
+func (s *SpiffeSDK) goBackground(fn func()) bool {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	if s.state == StateClosed {
+		return false
+	}
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		fn()
+	}()
+	return true
+}
+
+// Start registers the service, fetches its first SVID and starts renewal,
+// federation and the other background work. Once the SDK is ready (or
+// degraded, e.g. running on a cached SVID) further calls return nil. If Start
+// fails the SDK returns to StateNew and Start may be retried.
+func (s *SpiffeSDK) Start() error {
+	s.startMu.Lock()
+	defer s.startMu.Unlock()
+
+	switch s.State() {
+	case StateClosed:
+		return ErrClosed
+	case StateReady, StateDegraded:
+		return nil
+	}
+
+	s.setState(StateStarting)
+	if err := s.start(); err != nil {
+		s.setState(StateNew)
+		return err
+	}
+	// Close may have run while starting
+	if s.State() == StateClosed {
+		return ErrClosed
+	}
+	return nil
+}
+
+// Initialize performs the complete setup process. It is equivalent to Start.
+func (s *SpiffeSDK) Initialize() error {
+	return s.Start()
+}
+
+// Close stops the SDK: servers created with NewServer or Serve are shut down
+// gracefully, background work is stopped and identity sources are closed. It
+// then waits until all background goroutines have exited, or ctx is done. Close
+// is idempotent and safe to call concurrently; every call waits for the same
+// shutdown. Afterwards the SDK returns ErrClosed.
+func (s *SpiffeSDK) Close(ctx context.Context) error {
+	s.closeOnce.Do(func() {
+		s.setState(StateClosed)
+		s.cancel()
+
+		go func() {
+			s.shutdownServers()
+			s.background.Wait()
+
+			s.mu.RLock()
+			jwtSource := s.jwtSource
+			s.mu.RUnlock()
+
+			var errs []error
+			if jwtSource != nil {
+				errs = append(errs, jwtSource.Close())
+			}
+			if s.source != nil {
+				errs = append(errs, s.source.Close())
+			}
+			s.closeErr = errors.Join(errs...)
+			close(s.closed)
+		}()
+	})
+
+	select {
+	case <-s.closed:
+		return s.closeErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// checkOpen returns ErrClosed once the SDK has been closed
+func (s *SpiffeSDK) checkOpen() error {
+	if s.ctx.Err() != nil {
+		return ErrClosed
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package spiffesdk_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	spiffesdk "github.com/authsec-ai/spiffe-sdk"
+	"github.com/authsec-ai/spiffe-sdk/spiffetest"
+)
+
+func TestStartIsIdempotent(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	headless := spiffetest.NewHeadlessServer(t, ca)
+	sdk := newHeadlessSDK(t, headless)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := sdk.Start(); err != nil {
+				t.Errorf("Start() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := sdk.Start(); err != nil {
+		t.Errorf("Start() on a started SDK error = %v", err)
+	}
+
+	if n := len(headless.Requests(spiffetest.EndpointRegister)); n != 1 {
+		t.Errorf("registered %d times, want once", n)
+	}
+	if state := sdk.State(); state != spiffesdk.StateReady {
+		t.Errorf("State() = %v, want %v", state, spiffesdk.StateReady)
+	}
+}
+
+func TestCloseIsIdempotent(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	sdk := newHeadlessSDK(t, spiffetest.NewHeadlessServer(t, ca))
+	if err := sdk.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := sdk.Close(context.Background()); err != nil {
+				t.Errorf("Close() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := sdk.Close(context.Background()); err != nil {
+		t.Errorf("Close() on a closed SDK error = %v", err)
+	}
+	if state := sdk.State(); state != spiffesdk.StateClosed {
+		t.Errorf("State() = %v, want %v", state, spiffesdk.StateClosed)
+	}
+}
+
+func TestCloseWaitsForBackgroundWork(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	sdk := ca.NewSDK(t, paymentID)
+
+	release := make(chan struct{})
+	exited := make(chan struct{})
+	if !sdk.GoBackground(func() {
+		<-release
+		close(exited)
+	}) {
+		t.Fatal("background work did not start")
+	}
+
+	// Close gives up when ctx is done, while the work is still running
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := sdk.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Close() with running work = %v, want context.DeadlineExceeded", err)
+	}
+	if sdk.GoBackground(func() {}) {
+		t.Error("background work started after Close")
+	}
+
+	close(release)
+	if err := sdk.Close(context.Background()); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	select {
+	case <-exited:
+	default:
+		t.Error("Close() returned before the background work exited")
+	}
+}
+
+func TestStateChangesOrder(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	sdk := newHeadlessSDK(t, spiffetest.NewHeadlessServer(t, ca))
+	changes := sdk.StateChanges()
+
+	if err := sdk.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sdk.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []spiffesdk.State
+	for state := range changes {
+		got = append(got, state)
+	}
+	want := []spiffesdk.State{spiffesdk.StateStarting, spiffesdk.StateReady, spiffesdk.StateClosed}
+	if len(got) != len(want) {
+		t.Fatalf("state changes = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("state changes = %v, want %v", got, want)
+		}
+	}
+
+	// Subscribing after Close delivers StateClosed and closes the channel
+	late := sdk.StateChanges()
+	if state, ok := <-late; !ok || state != spiffesdk.StateClosed {
+		t.Errorf("late StateChanges() delivered %v, %t; want %v", state, ok, spiffesdk.StateClosed)
+	}
+	if _, ok := <-late; ok {
+		t.Error("late StateChanges() channel was not closed")
+	}
+}
+
+func TestFailedStartCanBeRetried(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	headless := spiffetest.NewHeadlessServer(t, ca)
+	sdk := newHeadlessSDK(t, headless)
+
+	headless.InjectFault(spiffetest.EndpointRegister, spiffetest.Fault{StatusCode: 503, Times: 1})
+	if err := sdk.Start(); err == nil {
+		t.Fatal("Start() succeeded with the registration failing")
+	}
+	if state := sdk.State(); state != spiffesdk.StateNew {
+		t.Errorf("State() after a failed Start = %v, want %v", state, spiffesdk.StateNew)
+	}
+	if err := sdk.Start(); err != nil {
+		t.Errorf("retried Start() error = %v", err)
+	}
+}
+
+func TestClosedSDKReturnsErrClosed(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	sdk := newHeadlessSDK(t, spiffetest.NewHeadlessServer(t, ca))
+	if err := sdk.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sdk.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sdk.Start(); !errors.Is(err, spiffesdk.ErrClosed) {
+		t.Errorf("Start() = %v, want ErrClosed", err)
+	}
+	if _, err := sdk.GetX509SVID(); !errors.Is(err, spiffesdk.ErrClosed) {
+		t.Errorf("GetX509SVID() = %v, want ErrClosed", err)
+	}
+	if _, err := sdk.GetX509SVIDFor(""); !errors.Is(err, spiffesdk.ErrClosed) {
+		t.Errorf("GetX509SVIDFor() = %v, want ErrClosed", err)
+	}
+	if _, err := sdk.FetchJWTSVID(context.Background(), "billing"); !errors.Is(err, spiffesdk.ErrClosed) {
+		t.Errorf("FetchJWTSVID() = %v, want ErrClosed", err)
+	}
+	if _, err := sdk.ValidateJWTSVID("token", "billing"); !errors.Is(err, spiffesdk.ErrClosed) {
+		t.Errorf("ValidateJWTSVID() = %v, want ErrClosed", err)
+	}
+	if _, err := sdk.ValidateIncomingSVID("cert"); !errors.Is(err, spiffesdk.ErrClosed) {
+		t.Errorf("ValidateIncomingSVID() = %v, want ErrClosed", err)
+	}
+	if _, err := sdk.NewServer("127.0.0.1:0", okHandler()); !errors.Is(err, spiffesdk.ErrClosed) {
+		t.Errorf("NewServer() = %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,29 @@
+package spiffesdk
+
+import "expvar"
+
+// metrics are published through expvar under "spiffesdk", so they appear on
+// /debug/vars when the expvar handler is mounted
+var metrics = expvar.NewMap("spiffesdk")
+
+// policyVersions maps each watched policy file to its active version
+var policyVersions = new(expvar.Map).Init()
+
+// svidExpiresAt and svidSource describe the SVID currently presented, and
+// bundleSequence the local trust bundle
+var (
+	svidExpiresAt  = new(expvar.Int)
+	svidSource     = new(expvar.String)
+	bundleSequence = new(expvar.Int)
+)
+
+// denyListEntries is the size of the merged deny-list
+var denyListEntries = new(expvar.Int)
+
+func init() {
+	metrics.Set("policy_versions", policyVersions)
+	metrics.Set("svid_expires_at_seconds", svidExpiresAt)
+	metrics.Set("svid_source", svidSource)
+	metrics.Set("bundle_sequence", bundleSequence)
+	metrics.Set("deny_list_entries", denyListEntries)
+}
@@ -0,0 +1,188 @@
+package spiffesdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/spiffebundle"
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+)
+
+// deadlineTTLSlack is added to the time left until the context deadline when
+// WithDeadlineTTL derives the requested SVID lifetime, so the SVID outlives
+// the job's final requests
+const deadlineTTLSlack = time.Minute
+
+// FetchOption configures FetchIdentity
+type FetchOption func(*fetchOptions)
+
+type fetchOptions struct {
+	ttl         time.Duration
+	deadlineTTL bool
+}
+
+// WithTTL requests an SVID valid for ttl from the headless API. The server may
+// issue a shorter one. The Workload API always uses the agent's TTL.
+func WithTTL(ttl time.Duration) FetchOption {
+	return func(o *fetchOptions) {
+		o.ttl = ttl
+	}
+}
+
+// WithDeadlineTTL requests an SVID from the headless API that lives until the
+// deadline of the context passed to FetchIdentity, plus a minute of slack. It
+// has no effect if the context has no deadline.
+func WithDeadlineTTL() FetchOption {
+	return func(o *fetchOptions) {
+		o.deadlineTTL = true
+	}
+}
+
+// FetchIdentity fetches a single SVID for short-lived workloads such as Jobs
+// and CronJobs. It tries the Workload API first if config.SocketPath is set,
+// otherwise (or if the agent isn't reachable) it registers with the headless
+// API if needed and has it issue an SVID.
+//
+// The returned SDK is already started and never renews the SVID: it starts no
+// background goroutines, so there is nothing to stop besides servers the
+// caller creates. It provides the usual TLS configs, HTTP clients, servers and
+// middleware, and writes config.CertificateOutput once if set. Bundles of
+// config.FederatedTrustDomains are fetched once as well.
+//
+// ctx bounds every request, so a Job's deadline or cancellation stops the
+// fetch, and its deadline can set the SVID's TTL via WithDeadlineTTL.
+func FetchIdentity(ctx context.Context, config *Config, opts ...FetchOption) (*SpiffeSDK, error) {
+	var options fetchOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.deadlineTTL {
+		if deadline, ok := ctx.Deadline(); ok {
+			options.ttl = time.Until(deadline) + deadlineTTLSlack
+		}
+	}
+
+	if config.SPIFFEID == "" {
+		return nil, errors.New("SPIFFE ID is required")
+	}
+	if len(config.Identities) > 0 {
+		return nil, errors.New("FetchIdentity supports a single identity")
+	}
+	if config.SocketPath == "" && config.HeadlessAPIURL == "" {
+		return nil, errors.New("FetchIdentity needs a Workload API socket or a headless API URL")
+	}
+
+	var (
+		svid    *x509svid.SVID
+		bundles *spiffebundle.Set
+		source  string
+		errs    []error
+	)
+	if config.SocketPath != "" {
+		var err error
+		svid, bundles, err = fetchFromWorkloadAPI(ctx, config)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("workload API: %w", err))
+		}
+		source = SVIDSourceWorkloadAPI
+	}
+	if svid == nil && config.HeadlessAPIURL != "" {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var err error
+		svid, bundles, err = fetchFromHeadlessAPI(ctx, config, options.ttl)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("headless API: %w", err))
+		}
+		source = SVIDSourceHeadlessAPI
+	}
+	if svid == nil {
+		return nil, fmt.Errorf("failed to fetch SVID: %w", errors.Join(errs...))
+	}
+
+	sdk, err := newStaticSDK(config, svid, bundles, source)
+	if err != nil {
+		return nil, err
+	}
+	if err := sdk.fetchFederatedBundles(ctx); err != nil {
+		return nil, err
+	}
+	sdk.writeCertificateFiles()
+	return sdk, nil
+}
+
+// fetchFromWorkloadAPI fetches the configured SVID and all bundles from the
+// SPIRE agent once
+func fetchFromWorkloadAPI(ctx context.Context, config *Config) (*x509svid.SVID, *spiffebundle.Set, error) {
+	ctx, cancel := context.WithTimeout(ctx, workloadAPIStartTimeout)
+	defer cancel()
+
+	x509Context, err := workloadapi.FetchX509Context(ctx, workloadapi.WithAddr("unix://"+config.SocketPath))
+	if err != nil {
+		return nil, nil, err
+	}
+	svid, err := pickSVID(x509Context.SVIDs, config.SPIFFEID, config.SVIDHint)
+	if err != nil {
+		return nil, nil, err
+	}
+	return svid, toSPIFFEBundleSet(x509Context.Bundles), nil
+}
+
+// fetchFromHeadlessAPI registers the workload unless it already is, and has
+// the headless API issue an SVID for it
+func fetchFromHeadlessAPI(ctx context.Context, config *Config, ttl time.Duration) (*x509svid.SVID, *spiffebundle.Set, error) {
+	api := &HeadlessAPI{
+		BaseURL:     config.HeadlessAPIURL,
+		HTTPClient:  &http.Client{Timeout: 10 * time.Second},
+		Attestation: config.Attestation,
+	}
+
+	workloads, err := api.listWorkloads(ctx, config.SPIFFEID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(workloads) == 0 {
+		payload, err := config.RegistrationPayload()
+		if err == nil {
+			err = api.registerAndIssueSVID(ctx, payload)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("registration failed: %w", err)
+		}
+		if workloads, err = api.listWorkloads(ctx, config.SPIFFEID); err != nil {
+			return nil, nil, err
+		}
+		if len(workloads) == 0 {
+			return nil, nil, fmt.Errorf("workload not found for SPIFFE ID: %s", config.SPIFFEID)
+		}
+	}
+
+	resp, err := api.issueSVID(ctx, workloads[0].ID, ttl)
+	if err != nil {
+		return nil, nil, err
+	}
+	svid, err := x509svid.Parse([]byte(resp.X509SVID), []byte(resp.PrivateKey))
+	if err != nil {
+		return nil, nil, fmt.Errorf("headless API issued an invalid SVID: %w", err)
+	}
+	bundle, err := x509bundle.Parse(svid.ID.TrustDomain(), []byte(resp.Bundle))
+	if err != nil {
+		return nil, nil, fmt.Errorf("headless API returned an invalid bundle: %w", err)
+	}
+	return svid, spiffebundle.NewSet(spiffebundle.FromX509Bundle(bundle)), nil
+}
+
+// toSPIFFEBundleSet converts X.509 bundles to SPIFFE bundles
+func toSPIFFEBundleSet(bundles *x509bundle.Set) *spiffebundle.Set {
+	set := spiffebundle.NewSet()
+	for _, bundle := range bundles.Bundles() {
+		set.Add(spiffebundle.FromX509Bundle(bundle))
+	}
+	return set
+}
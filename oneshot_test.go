@@ -0,0 +1,126 @@
+package spiffesdk_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	spiffesdk "github.com/authsec-ai/spiffe-sdk"
+	"github.com/authsec-ai/spiffe-sdk/spiffetest"
+)
+
+// oneShotConfig is a FetchIdentity config for the payment service
+func oneShotConfig(headless *spiffetest.HeadlessServer, socketPath string) *spiffesdk.Config {
+	return &spiffesdk.Config{
+		SPIFFEID:       paymentID,
+		TrustDomain:    "authsec.dev",
+		ServiceType:    "application",
+		Namespace:      "payments",
+		ServiceAccount: "payments",
+		HeadlessAPIURL: headless.URL,
+		SocketPath:     socketPath,
+	}
+}
+
+func TestFetchIdentityPrefersWorkloadAPI(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	headless := spiffetest.NewHeadlessServer(t, ca)
+	agent := spiffetest.NewWorkloadAPI(t, ca, paymentID)
+
+	sdk, err := spiffesdk.FetchIdentity(context.Background(), oneShotConfig(headless, agent.SocketPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source := sdk.GetCurrentSVID().Source; source != spiffesdk.SVIDSourceWorkloadAPI {
+		t.Errorf("SVID source = %q, want %q", source, spiffesdk.SVIDSourceWorkloadAPI)
+	}
+	if requests := headless.Requests(); len(requests) != 0 {
+		t.Errorf("headless API received %d requests, want none", len(requests))
+	}
+}
+
+func TestFetchIdentityFallsBackToHeadlessAPI(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	headless := spiffetest.NewHeadlessServer(t, ca)
+	agent := spiffetest.NewWorkloadAPI(t, ca, paymentID)
+	agent.Stop()
+
+	sdk, err := spiffesdk.FetchIdentity(context.Background(), oneShotConfig(headless, agent.SocketPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source := sdk.GetCurrentSVID().Source; source != spiffesdk.SVIDSourceHeadlessAPI {
+		t.Errorf("SVID source = %q, want %q", source, spiffesdk.SVIDSourceHeadlessAPI)
+	}
+	if n := len(headless.Requests(spiffetest.EndpointIssueSVID)); n != 1 {
+		t.Errorf("headless API issued %d SVIDs, want 1", n)
+	}
+}
+
+func TestFetchIdentityDeadlineTTL(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	headless := spiffetest.NewHeadlessServer(t, ca)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	sdk, err := spiffesdk.FetchIdentity(ctx, oneShotConfig(headless, ""), spiffesdk.WithDeadlineTTL())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := headless.Requests(spiffetest.EndpointIssueSVID)
+	if len(requests) != 1 {
+		t.Fatalf("headless API issued %d SVIDs, want 1", len(requests))
+	}
+	var body struct {
+		TTL int64 `json:"ttl"`
+	}
+	if err := json.Unmarshal(requests[0].Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	// Time to the deadline plus a minute of slack, less the time spent so far
+	ttl := time.Duration(body.TTL) * time.Second
+	if want := 11 * time.Minute; ttl > want || ttl < want-10*time.Second {
+		t.Errorf("requested ttl = %v, want about %v", ttl, want)
+	}
+
+	// The issued SVID honours the requested lifetime
+	expiresIn := time.Until(sdk.GetCurrentSVID().ExpiresAt)
+	if expiresIn > 11*time.Minute || expiresIn < 10*time.Minute {
+		t.Errorf("SVID expires in %v, want about 11m", expiresIn)
+	}
+}
+
+func TestFetchIdentityStopsWhenContextEnds(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	headless := spiffetest.NewHeadlessServer(t, ca)
+	headless.InjectFault(spiffetest.EndpointListWorkloads, spiffetest.Fault{Latency: time.Minute})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := spiffesdk.FetchIdentity(ctx, oneShotConfig(headless, ""))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("FetchIdentity() = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("FetchIdentity() took %v after the deadline", elapsed)
+	}
+	if n := len(headless.Requests(spiffetest.EndpointRegister, spiffetest.EndpointIssueSVID)); n != 0 {
+		t.Errorf("headless API received %d requests after the deadline, want none", n)
+	}
+
+	// An already cancelled context stops before the headless API is called
+	headless.ClearFaults()
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	before := len(headless.Requests())
+	if _, err := spiffesdk.FetchIdentity(cancelled, oneShotConfig(headless, "")); !errors.Is(err, context.Canceled) {
+		t.Errorf("FetchIdentity() with a cancelled context = %v, want context.Canceled", err)
+	}
+	if after := len(headless.Requests()); after != before {
+		t.Errorf("headless API received %d requests for a cancelled context, want none", after-before)
+	}
+}
@@ -0,0 +1,290 @@
+package spiffesdk
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
+	"gopkg.in/yaml.v3"
+)
+
+// Policy actions for requests that match no rule
+const (
+	PolicyActionDeny  = "deny"
+	PolicyActionAllow = "allow"
+)
+
+// Policy is a declarative set of per-route authorization rules evaluated
+// against the caller's SPIFFE ID. Rules are checked in order and the first rule
+// whose methods and path match the request decides.
+//
+// Path and SPIFFE ID patterns use path.Match syntax, where "*" matches within
+// a single segment. A trailing "/**" matches any number of further segments,
+// e.g. "/payments/**" or "spiffe://authsec.dev/**".
+type Policy struct {
+	// Version identifies the policy revision in logs and decisions
+	Version string `json:"version" yaml:"version"`
+
+	// DefaultAction applies when no rule matches: "deny" (default) or "allow"
+	DefaultAction string `json:"default_action" yaml:"default_action"`
+
+	// DryRun logs every decision without enforcing it (audit mode)
+	DryRun bool `json:"dry_run" yaml:"dry_run"`
+
+	Rules []PolicyRule `json:"rules" yaml:"rules"`
+}
+
+// PolicyRule allows callers matching Allow to reach Methods on Path
+type PolicyRule struct {
+	Name    string   `json:"name" yaml:"name"`
+	Methods []string `json:"methods" yaml:"methods"` // empty matches every method
+	Path    string   `json:"path" yaml:"path"`
+	Allow   []string `json:"allow" yaml:"allow"` // SPIFFE ID patterns
+}
+
+// PolicyDecision is the outcome of evaluating a request against a Policy
+type PolicyDecision struct {
+	Allowed  bool   `json:"allowed"`
+	Version  string `json:"policy_version,omitempty"`
+	Rule     string `json:"rule,omitempty"`
+	SPIFFEID string `json:"spiffe_id,omitempty"`
+	Reason   string `json:"reason"`
+}
+
+// LoadPolicy parses a policy from YAML or JSON and validates it
+func LoadPolicy(data []byte) (*Policy, error) {
+	var policy Policy
+	// YAML is a superset of JSON, so one decoder handles both formats
+	if err := yaml.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %w", err)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// LoadPolicyFile reads and validates a YAML or JSON policy file
+func LoadPolicyFile(filename string) (*Policy, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %w", err)
+	}
+	return LoadPolicy(data)
+}
+
+// Validate checks that every rule is well formed
+func (p *Policy) Validate() error {
+	switch p.DefaultAction {
+	case "", PolicyActionDeny, PolicyActionAllow:
+	default:
+		return fmt.Errorf("invalid default_action %q: must be %q or %q", p.DefaultAction, PolicyActionDeny, PolicyActionAllow)
+	}
+
+	for i, rule := range p.Rules {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+
+		if !strings.HasPrefix(rule.Path, "/") {
+			return fmt.Errorf("rule %s: path %q must start with /", name, rule.Path)
+		}
+		if err := checkPattern(rule.Path); err != nil {
+			return fmt.Errorf("rule %s: invalid path pattern: %w", name, err)
+		}
+		for _, method := range rule.Methods {
+			if method == "" || strings.ToUpper(method) != method {
+				return fmt.Errorf("rule %s: method %q must be an upper-case HTTP method", name, method)
+			}
+		}
+		if len(rule.Allow) == 0 {
+			return fmt.Errorf("rule %s: allow must list at least one SPIFFE ID pattern", name)
+		}
+		for _, pattern := range rule.Allow {
+			if !strings.HasPrefix(pattern, "spiffe://") {
+				return fmt.Errorf("rule %s: allow pattern %q must start with spiffe://", name, pattern)
+			}
+			if err := checkPattern(pattern); err != nil {
+				return fmt.Errorf("rule %s: invalid allow pattern: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Evaluate decides whether the caller spiffeID may send method to urlPath
+func (p *Policy) Evaluate(method, urlPath, spiffeID string) PolicyDecision {
+	decision := p.evaluate(method, urlPath, spiffeID)
+	decision.Version = p.Version
+	return decision
+}
+
+func (p *Policy) evaluate(method, urlPath, spiffeID string) PolicyDecision {
+	for i, rule := range p.Rules {
+		if !rule.matchesRequest(method, urlPath) {
+			continue
+		}
+
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+
+		if spiffeID == "" {
+			return PolicyDecision{Rule: name, Reason: "caller has no authenticated SPIFFE ID"}
+		}
+		for _, pattern := range rule.Allow {
+			if matchPattern(pattern, spiffeID) {
+				return PolicyDecision{Allowed: true, Rule: name, SPIFFEID: spiffeID, Reason: "allowed by rule"}
+			}
+		}
+		return PolicyDecision{
+			Rule:     name,
+			SPIFFEID: spiffeID,
+			Reason:   fmt.Sprintf("%s is not allowed to %s %s", spiffeID, method, urlPath),
+		}
+	}
+
+	if p.DefaultAction == PolicyActionAllow {
+		return PolicyDecision{Allowed: true, SPIFFEID: spiffeID, Reason: "no rule matched, default action is allow"}
+	}
+	return PolicyDecision{SPIFFEID: spiffeID, Reason: "no rule matched, default action is deny"}
+}
+
+func (r *PolicyRule) matchesRequest(method, urlPath string) bool {
+	if len(r.Methods) > 0 {
+		found := false
+		for _, m := range r.Methods {
+			if m == method {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return matchPattern(r.Path, urlPath)
+}
+
+// PolicySource provides the policy to enforce. *Policy is a fixed source;
+// *PolicyWatcher returns the latest valid policy from a watched file.
+type PolicySource interface {
+	CurrentPolicy() *Policy
+}
+
+// CurrentPolicy implements PolicySource
+func (p *Policy) CurrentPolicy() *Policy {
+	return p
+}
+
+// PolicyAuthorizer returns an mTLS authorizer that accepts peers allowed by at
+// least one rule of the current policy (or any peer if the default action is
+// allow). Use it to reject unknown callers during the handshake; per-route
+// checks still need PolicyMiddleware. Dry-run policies accept every peer.
+func PolicyAuthorizer(source PolicySource) tlsconfig.Authorizer {
+	return func(id spiffeid.ID, _ [][]*x509.Certificate) error {
+		policy := source.CurrentPolicy()
+		if policy.DryRun || policy.DefaultAction == PolicyActionAllow {
+			return nil
+		}
+		for _, rule := range policy.Rules {
+			for _, pattern := range rule.Allow {
+				if matchPattern(pattern, id.String()) {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("%s is not allowed by policy version %q", id, policy.Version)
+	}
+}
+
+// PolicyMiddleware enforces the source's policy on every request. It reads
+// the caller's SPIFFE ID set by IncomingValidationMiddleware or
+// JWTValidationMiddleware, so it must be wrapped by one of them. Denied
+// requests get a 403 with a JSON body describing the decision.
+//
+// The path is cleaned before it is evaluated, so "//admin/x" and
+// "/public/../admin/x" are decided as "/admin/x".
+func (s *SpiffeSDK) PolicyMiddleware(source PolicySource, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy := source.CurrentPolicy()
+		spiffeID, _ := SPIFFEIDFromContext(r.Context())
+		urlPath := cleanPath(r.URL.Path)
+		decision := policy.Evaluate(r.Method, urlPath, spiffeID)
+
+		if policy.DryRun {
+			verdict := "allow"
+			if !decision.Allowed {
+				verdict = "deny"
+			}
+			s.logf("Policy dry-run (version %q): would %s %s %s for %q: %s",
+				policy.Version, verdict, r.Method, urlPath, spiffeID, decision.Reason)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !decision.Allowed {
+			writePolicyDenial(w, decision)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cleanPath returns the canonical form of a request path, with a leading slash
+// and without empty, "." or ".." segments
+func cleanPath(urlPath string) string {
+	return path.Clean("/" + urlPath)
+}
+
+func writePolicyDenial(w http.ResponseWriter, decision PolicyDecision) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusForbidden)
+	_ = json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+		PolicyDecision
+	}{
+		Error:          "forbidden",
+		PolicyDecision: decision,
+	})
+}
+
+// matchPattern matches value against a path.Match pattern, where a trailing
+// "/**" matches any remaining segments
+func matchPattern(pattern, value string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "/**"); ok {
+		if ok, _ := path.Match(prefix, value); ok {
+			return true
+		}
+		// Match the prefix against the same number of leading segments
+		segments := strings.Count(prefix, "/")
+		parts := strings.SplitAfterN(value, "/", segments+2)
+		if len(parts) <= segments+1 {
+			return false
+		}
+		head := strings.TrimSuffix(strings.Join(parts[:segments+1], ""), "/")
+		ok, _ := path.Match(prefix, head)
+		return ok
+	}
+	ok, _ := path.Match(pattern, value)
+	return ok
+}
+
+func checkPattern(pattern string) error {
+	pattern = strings.TrimSuffix(pattern, "/**")
+	if strings.Contains(pattern, "**") {
+		return errors.New("\"**\" is only supported as the final path segment")
+	}
+	_, err := path.Match(pattern, "")
+	return err
+}
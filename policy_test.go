@@ -0,0 +1,124 @@
+package spiffesdk
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern, value string
+		want           bool
+	}{
+		{"/health", "/health", true},
+		{"/health", "/healthz", false},
+		{"/api/*/orders", "/api/v1/orders", true},
+		{"/api/*/orders", "/api/v1/extra/orders", false},
+		{"/api/*", "/api/", true},
+		{"/api/*", "/api/v1/orders", false},
+		{"/payments/**", "/payments", true},
+		{"/payments/**", "/payments/", true},
+		{"/payments/**", "/payments/refunds", true},
+		{"/payments/**", "/payments/refunds/42", true},
+		{"/payments/**", "/paymentsx", false},
+		{"/payments/**", "/paymentsx/refunds", false},
+		{"/payments/**", "/", false},
+		{"/api/*/admin/**", "/api/v1/admin/users/7", true},
+		{"/api/*/admin/**", "/api/v1/public/admin", false},
+		{"spiffe://authsec.dev/**", "spiffe://authsec.dev/ns/billing/sa/api", true},
+		{"spiffe://authsec.dev/**", "spiffe://authsec.dev.evil/ns/billing", false},
+		{"spiffe://authsec.dev/*", "spiffe://authsec.dev/billing", true},
+		{"spiffe://authsec.dev/*", "spiffe://authsec.dev/ns/billing", false},
+	}
+	for _, tt := range tests {
+		if got := matchPattern(tt.pattern, tt.value); got != tt.want {
+			t.Errorf("matchPattern(%q, %q) = %v, want %v", tt.pattern, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyEvaluate(t *testing.T) {
+	policy := &Policy{
+		Version: "v1",
+		Rules: []PolicyRule{
+			{Name: "health", Path: "/health", Allow: []string{"spiffe://authsec.dev/**"}},
+			{Name: "refund", Methods: []string{"POST"}, Path: "/payments/refunds/**", Allow: []string{"spiffe://authsec.dev/support"}},
+			{Name: "payments", Methods: []string{"GET", "POST"}, Path: "/payments/**", Allow: []string{"spiffe://authsec.dev/checkout"}},
+			{Name: "admin", Path: "/admin/**", Allow: []string{"spiffe://authsec.dev/ops"}},
+		},
+	}
+
+	tests := []struct {
+		name                   string
+		method, path, spiffeID string
+		wantAllowed            bool
+		wantRule               string
+	}{
+		{"any method", "DELETE", "/health", "spiffe://authsec.dev/checkout", true, "health"},
+		{"method matches", "GET", "/payments/42", "spiffe://authsec.dev/checkout", true, "payments"},
+		{"method not listed", "DELETE", "/payments/42", "spiffe://authsec.dev/checkout", false, ""},
+		{"first match wins", "POST", "/payments/refunds/1", "spiffe://authsec.dev/support", true, "refund"},
+		{"first match denies", "POST", "/payments/refunds/1", "spiffe://authsec.dev/checkout", false, "refund"},
+		{"later rule for other methods", "GET", "/payments/refunds/1", "spiffe://authsec.dev/checkout", true, "payments"},
+		{"caller not allowed", "GET", "/admin/users", "spiffe://authsec.dev/checkout", false, "admin"},
+		{"unauthenticated", "GET", "/admin/users", "", false, "admin"},
+		{"default deny", "GET", "/unknown", "spiffe://authsec.dev/ops", false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := policy.Evaluate(tt.method, tt.path, tt.spiffeID)
+			if decision.Allowed != tt.wantAllowed || decision.Rule != tt.wantRule {
+				t.Errorf("Evaluate(%s %s, %q) = allowed %v by rule %q, want %v by %q (%s)",
+					tt.method, tt.path, tt.spiffeID, decision.Allowed, decision.Rule, tt.wantAllowed, tt.wantRule, decision.Reason)
+			}
+			if decision.Version != "v1" {
+				t.Errorf("Version = %q, want v1", decision.Version)
+			}
+		})
+	}
+
+	t.Run("default allow", func(t *testing.T) {
+		allowAll := &Policy{DefaultAction: PolicyActionAllow, Rules: policy.Rules}
+		if !allowAll.Evaluate("GET", "/unknown", "").Allowed {
+			t.Error("unmatched request denied with default action allow")
+		}
+		if allowAll.Evaluate("GET", "/admin/users", "spiffe://authsec.dev/checkout").Allowed {
+			t.Error("matching rule ignored with default action allow")
+		}
+	})
+}
+
+func TestPolicyMiddlewareCleansPath(t *testing.T) {
+	// Default allow, so a path that slips past the admin rule is let through
+	policy := &Policy{DefaultAction: PolicyActionAllow, Rules: []PolicyRule{
+		{Name: "admin", Path: "/admin/**", Allow: []string{"spiffe://authsec.dev/ops"}},
+		{Name: "public", Path: "/public/**", Allow: []string{"spiffe://authsec.dev/**"}},
+	}}
+	sdk := &SpiffeSDK{}
+	handler := sdk.PolicyMiddleware(policy, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		path     string
+		spiffeID string
+		want     int
+	}{
+		{"/public/page", "spiffe://authsec.dev/web", http.StatusOK},
+		{"/admin/x", "spiffe://authsec.dev/web", http.StatusForbidden},
+		{"//admin/x", "spiffe://authsec.dev/web", http.StatusForbidden},
+		{"/public/../admin/x", "spiffe://authsec.dev/web", http.StatusForbidden},
+		{"/public/./../admin/x", "spiffe://authsec.dev/web", http.StatusForbidden},
+		{"/admin//x/", "spiffe://authsec.dev/web", http.StatusForbidden},
+		{"/public/../admin/x", "spiffe://authsec.dev/ops", http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		r.URL.Path = tt.path
+		r = r.WithContext(withSPIFFEID(r.Context(), tt.spiffeID))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("GET %s as %s = %d, want %d", tt.path, tt.spiffeID, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package spiffesdk
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"expvar"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// defaultPolicyPollInterval is how often a watched policy file is re-read
+const defaultPolicyPollInterval = 10 * time.Second
+
+// PolicyWatcher keeps a policy loaded from a file (e.g. a ConfigMap mount) up
+// to date. Changes are validated before they are swapped in atomically; an
+// invalid file leaves the last good policy in place.
+type PolicyWatcher struct {
+	sdk      *SpiffeSDK
+	path     string
+	interval time.Duration
+	policy   atomic.Pointer[Policy]
+	hash     [sha256.Size]byte
+	badHash  [sha256.Size]byte
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// WatchPolicyFile loads the policy at path and reloads it whenever the file
+// content changes, checking every interval (10s if zero). The file is polled
+// rather than watched with inotify so that ConfigMap symlink swaps are picked
+// up reliably. The initial load must succeed. Watching stops on Close or when
+// the SDK is closed.
+//
+// Policies without a version are identified by a hash of the file content.
+// Each reload emits an EventPolicyReloaded or EventPolicyReloadFailed event and
+// updates the "policy_versions" metric.
+func (s *SpiffeSDK) WatchPolicyFile(path string, interval time.Duration) (*PolicyWatcher, error) {
+	if interval <= 0 {
+		interval = defaultPolicyPollInterval
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	w := &PolicyWatcher{
+		sdk:      s,
+		path:     path,
+		interval: interval,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+
+	if _, err := w.reload(); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if !s.goBackground(func() { w.run(ctx) }) {
+		cancel()
+		return nil, ErrClosed
+	}
+	return w, nil
+}
+
+// CurrentPolicy returns the active policy. It implements PolicySource.
+func (w *PolicyWatcher) CurrentPolicy() *Policy {
+	return w.policy.Load()
+}
+
+// Version returns the version of the active policy
+func (w *PolicyWatcher) Version() string {
+	return w.CurrentPolicy().Version
+}
+
+// Close stops watching the file; the last loaded policy stays available
+func (w *PolicyWatcher) Close() error {
+	w.cancel()
+	<-w.done
+	return nil
+}
+
+func (w *PolicyWatcher) run(ctx context.Context) {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			previous := w.Version()
+			changed, err := w.reload()
+			if err != nil {
+				metrics.Add("policy_reload_failures_total", 1)
+				w.sdk.emit(Event{
+					Type:    EventPolicyReloadFailed,
+					Message: fmt.Sprintf("keeping policy version %q from %s", previous, w.path),
+					Err:     err,
+					Attributes: map[string]string{
+						"path":    w.path,
+						"version": previous,
+					},
+				})
+				continue
+			}
+			if changed {
+				metrics.Add("policy_reloads_total", 1)
+				w.sdk.emit(Event{
+					Type:    EventPolicyReloaded,
+					Message: fmt.Sprintf("policy version %q from %s is active (was %q)", w.Version(), w.path, previous),
+					Attributes: map[string]string{
+						"path":             w.path,
+						"version":          w.Version(),
+						"previous_version": previous,
+					},
+				})
+			}
+		}
+	}
+}
+
+// reload reads the file and swaps in its policy if the content changed and is
+// valid. It reports whether a new policy was installed.
+func (w *PolicyWatcher) reload() (bool, error) {
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read policy file: %w", err)
+	}
+
+	hash := sha256.Sum256(data)
+	if w.policy.Load() != nil && (hash == w.hash || hash == w.badHash) {
+		// Unchanged, or the same invalid content that was already reported
+		return false, nil
+	}
+
+	policy, err := LoadPolicy(data)
+	if err != nil {
+		w.badHash = hash
+		return false, err
+	}
+	if policy.Version == "" {
+		policy.Version = "sha256:" + hex.EncodeToString(hash[:6])
+	}
+
+	w.hash = hash
+	w.policy.Store(policy)
+
+	version := new(expvar.String)
+	version.Set(policy.Version)
+	policyVersions.Set(w.path, version)
+
+	return true, nil
+}
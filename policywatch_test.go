@@ -0,0 +1,102 @@
+package spiffesdk_test
+
+import (
+	"expvar"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	spiffesdk "github.com/authsec-ai/spiffe-sdk"
+	"github.com/authsec-ai/spiffe-sdk/spiffetest"
+)
+
+func TestPolicyWatcherFollowsConfigMapSwaps(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	events := make(chan spiffesdk.Event, 16)
+	sdk := newDenyListSDK(t, ca, serverID, &spiffesdk.Config{
+		OnEvent: func(event spiffesdk.Event) { events <- event },
+	})
+
+	// Laid out like a ConfigMap volume: policy.yaml -> ..data/policy.yaml,
+	// with ..data pointing at a timestamped directory that is swapped atomically
+	dir := t.TempDir()
+	swapConfigMap(t, dir, "version: v1\nrules:\n  - path: /orders\n    allow: [\""+clientID+"\"]\n")
+	if err := os.Symlink(filepath.Join("..data", "policy.yaml"), filepath.Join(dir, "policy.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "policy.yaml")
+
+	watcher, err := sdk.WatchPolicyFile(path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = watcher.Close() })
+	assertPolicyVersion(t, watcher, path, "v1")
+
+	swapConfigMap(t, dir, "version: v2\nrules: [this is not a rule")
+	event := waitForPolicyEvent(t, events)
+	if event.Type != spiffesdk.EventPolicyReloadFailed || event.Attributes["version"] != "v1" {
+		t.Errorf("invalid policy: got %s for version %q, want %s keeping v1", event.Type, event.Attributes["version"], spiffesdk.EventPolicyReloadFailed)
+	}
+	assertPolicyVersion(t, watcher, path, "v1")
+
+	swapConfigMap(t, dir, "version: v3\nrules:\n  - path: /orders/**\n    allow: [\""+clientID+"\"]\n")
+	event = waitForPolicyEvent(t, events)
+	if event.Type != spiffesdk.EventPolicyReloaded || event.Attributes["version"] != "v3" || event.Attributes["previous_version"] != "v1" {
+		t.Errorf("valid policy: got %s %v, want %s from v1 to v3", event.Type, event.Attributes, spiffesdk.EventPolicyReloaded)
+	}
+	assertPolicyVersion(t, watcher, path, "v3")
+	if decision := watcher.CurrentPolicy().Evaluate("GET", "/orders/42", clientID); !decision.Allowed {
+		t.Errorf("v3 policy denied GET /orders/42: %s", decision.Reason)
+	}
+}
+
+// swapConfigMap writes content to a new timestamped directory under dir and
+// atomically repoints ..data at it, as the kubelet does
+func swapConfigMap(t *testing.T, dir, content string) {
+	t.Helper()
+	generation, err := os.MkdirTemp(dir, "..gen_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(generation, "policy.yaml"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tmp := filepath.Join(dir, "..data_tmp")
+	if err := os.Symlink(filepath.Base(generation), tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitForPolicyEvent returns the next policy reload event
+func waitForPolicyEvent(t *testing.T, events <-chan spiffesdk.Event) spiffesdk.Event {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.Type == spiffesdk.EventPolicyReloaded || event.Type == spiffesdk.EventPolicyReloadFailed {
+				return event
+			}
+		case <-timeout:
+			t.Fatal("no policy reload event")
+		}
+	}
+}
+
+// assertPolicyVersion checks the watcher and the policy_versions metric
+func assertPolicyVersion(t *testing.T, watcher *spiffesdk.PolicyWatcher, path, want string) {
+	t.Helper()
+	if got := watcher.Version(); got != want {
+		t.Errorf("active policy version = %q, want %q", got, want)
+	}
+	versions := expvar.Get("spiffesdk").(*expvar.Map).Get("policy_versions").(*expvar.Map)
+	if got := versions.Get(path); got == nil || got.String() != strconv.Quote(want) {
+		t.Errorf("policy_versions[%s] = %v, want %q", path, got, want)
+	}
+}
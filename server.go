@@ -0,0 +1,253 @@
+package spiffesdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
+)
+
+// defaultShutdownTimeout bounds how long in-flight requests may take to finish
+// when a server is shut down
+const defaultShutdownTimeout = 15 * time.Second
+
+// ServerOption configures servers created by NewServer and Serve
+type ServerOption func(*serverOptions)
+
+type serverOptions struct {
+	authorizer       tlsconfig.Authorizer
+	validateIncoming bool
+	healthAddr       string
+	shutdownTimeout  time.Duration
+	sniIdentities    map[string]string
+}
+
+// WithServerAuthorizer restricts which client SPIFFE IDs may complete the mTLS
+// handshake. By default any ID that chains to the trust bundle is accepted.
+func WithServerAuthorizer(authorizer tlsconfig.Authorizer) ServerOption {
+	return func(o *serverOptions) {
+		o.authorizer = authorizer
+	}
+}
+
+// WithIncomingValidation wraps the handler with IncomingValidationMiddleware
+func WithIncomingValidation() ServerOption {
+	return func(o *serverOptions) {
+		o.validateIncoming = true
+	}
+}
+
+// WithHealthProbes serves plain-HTTP /health and /ready endpoints on a
+// separate address (e.g. ":8081") so kubelet probes don't need a client SVID
+func WithHealthProbes(addr string) ServerOption {
+	return func(o *serverOptions) {
+		o.healthAddr = addr
+	}
+}
+
+// WithShutdownTimeout sets how long graceful shutdown waits for in-flight requests
+func WithShutdownTimeout(timeout time.Duration) ServerOption {
+	return func(o *serverOptions) {
+		o.shutdownTimeout = timeout
+	}
+}
+
+// Server is an HTTPS server whose TLS config always presents the SDK's current
+// SVID and (except for bundle endpoint servers) requires client SVIDs. It is
+// shut down gracefully when the SDK is closed.
+type Server struct {
+	sdk             *SpiffeSDK
+	httpServer      *http.Server
+	healthServer    *http.Server
+	shutdownTimeout time.Duration
+}
+
+// NewServer creates an mTLS server for handler. The SDK must hold an SVID
+// (i.e. Start has succeeded) so the server can complete handshakes.
+func (s *SpiffeSDK) NewServer(addr string, handler http.Handler, opts ...ServerOption) (*Server, error) {
+	options := serverOptions{
+		authorizer:      tlsconfig.AuthorizeAny(),
+		shutdownTimeout: defaultShutdownTimeout,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	if _, err := s.GetX509SVID(); err != nil {
+		return nil, fmt.Errorf("server identity unavailable: %w", err)
+	}
+
+	tlsConfig := tlsconfig.MTLSServerConfig(s, s, s.DenyListAuthorizer(options.authorizer))
+	if len(options.sniIdentities) > 0 {
+		if err := s.applySNIIdentities(tlsConfig, options.sniIdentities); err != nil {
+			return nil, err
+		}
+	}
+
+	if options.validateIncoming {
+		handler = s.IncomingValidationMiddleware(handler)
+	}
+
+	srv := &Server{
+		sdk: s,
+		httpServer: &http.Server{
+			Addr:      addr,
+			Handler:   handler,
+			TLSConfig: tlsConfig,
+			ConnState: s.trackConn,
+		},
+		shutdownTimeout: options.shutdownTimeout,
+	}
+
+	if options.healthAddr != "" {
+		srv.healthServer = &http.Server{
+			Addr:    options.healthAddr,
+			Handler: s.healthHandler(),
+		}
+	}
+
+	return srv, nil
+}
+
+// Serve runs an mTLS server for handler until ctx is cancelled or the SDK is
+// closed, then shuts it down gracefully. It returns nil after a clean shutdown.
+func (s *SpiffeSDK) Serve(ctx context.Context, addr string, handler http.Handler, opts ...ServerOption) error {
+	srv, err := s.NewServer(addr, handler, opts...)
+	if err != nil {
+		return err
+	}
+	return s.runServer(ctx, srv)
+}
+
+// runServer runs srv until ctx is cancelled or the SDK is closed, then shuts
+// it down gracefully
+func (s *SpiffeSDK) runServer(ctx context.Context, srv *Server) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	case <-s.ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), srv.shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	return <-errCh
+}
+
+// ListenAndServe starts the health server (if configured) and the mTLS server.
+// It blocks until the server fails or is shut down; a graceful shutdown returns nil.
+func (srv *Server) ListenAndServe() error {
+	if !srv.sdk.trackServer(srv) {
+		return ErrClosed
+	}
+	defer srv.sdk.untrackServer(srv)
+
+	if srv.healthServer != nil {
+		go func() {
+			if err := srv.healthServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				srv.sdk.logf("Health probe server failed: %v", err)
+			}
+		}()
+	}
+
+	// Certificates come from the TLS config callbacks, not from files
+	err := srv.httpServer.ListenAndServeTLS("", "")
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	if srv.healthServer != nil {
+		_ = srv.healthServer.Close()
+	}
+	return err
+}
+
+// Shutdown gracefully stops the mTLS server and the health server
+func (srv *Server) Shutdown(ctx context.Context) error {
+	var healthErr error
+	if srv.healthServer != nil {
+		healthErr = srv.healthServer.Shutdown(ctx)
+	}
+	if err := srv.httpServer.Shutdown(ctx); err != nil {
+		return err
+	}
+	return healthErr
+}
+
+// HTTPServer exposes the underlying *http.Server for further tuning (timeouts,
+// ErrorLog, ...). It must not be modified after ListenAndServe is called.
+func (srv *Server) HTTPServer() *http.Server {
+	return srv.httpServer
+}
+
+// healthHandler serves liveness and readiness probes. Readiness requires a
+// currently valid SVID.
+func (s *SpiffeSDK) healthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, `{"status": "healthy"}`)
+	})
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		svid, err := s.GetX509SVID()
+		if err != nil || time.Now().After(svid.Certificates[0].NotAfter) {
+			http.Error(w, `{"status": "not ready"}`, http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, `{"status": "ready"}`)
+	})
+	return mux
+}
+
+func (s *SpiffeSDK) trackServer(srv *Server) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ctx.Err() != nil {
+		return false
+	}
+	s.servers[srv] = struct{}{}
+	return true
+}
+
+func (s *SpiffeSDK) untrackServer(srv *Server) {
+	s.mu.Lock()
+	delete(s.servers, srv)
+	s.mu.Unlock()
+}
+
+// shutdownServers gracefully stops every running server, in parallel
+func (s *SpiffeSDK) shutdownServers() {
+	s.mu.RLock()
+	servers := make([]*Server, 0, len(s.servers))
+	for srv := range s.servers {
+		servers = append(servers, srv)
+	}
+	s.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv *Server) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), srv.shutdownTimeout)
+			defer cancel()
+			if err := srv.Shutdown(ctx); err != nil {
+				s.logf("Server shutdown failed: %v", err)
+			}
+		}(srv)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,142 @@
+package spiffesdk_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	spiffesdk "github.com/authsec-ai/spiffe-sdk"
+	"github.com/authsec-ai/spiffe-sdk/spiffetest"
+)
+
+// newHeadlessSDK returns an unstarted SDK for paymentID backed by headless.
+// It is closed when the test ends.
+func newHeadlessSDK(t *testing.T, headless *spiffetest.HeadlessServer, configure ...func(*spiffesdk.Config)) *spiffesdk.SpiffeSDK {
+	t.Helper()
+	config := &spiffesdk.Config{
+		SPIFFEID:         paymentID,
+		TrustDomain:      "authsec.dev",
+		ServiceType:      "application",
+		Namespace:        "payments",
+		ServiceAccount:   "payments",
+		HeadlessAPIURL:   headless.URL,
+		RenewalThreshold: 5 * time.Minute,
+		CheckInterval:    time.Minute,
+	}
+	for _, fn := range configure {
+		fn(config)
+	}
+	sdk, err := spiffesdk.NewSpiffeSDK(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = sdk.Close(context.Background())
+	})
+	return sdk
+}
+
+func probe(t *testing.T, handler http.Handler, path string) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w.Code
+}
+
+func TestReadyProbe(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	sdk := newHeadlessSDK(t, spiffetest.NewHeadlessServer(t, ca))
+	health := sdk.HealthHandler()
+
+	if code := probe(t, health, "/health"); code != http.StatusOK {
+		t.Errorf("/health before Start = %d, want 200", code)
+	}
+	if code := probe(t, health, "/ready"); code != http.StatusServiceUnavailable {
+		t.Errorf("/ready before Start = %d, want 503", code)
+	}
+
+	if err := sdk.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if code := probe(t, health, "/ready"); code != http.StatusOK {
+		t.Errorf("/ready after Start = %d, want 200", code)
+	}
+
+	if err := sdk.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if code := probe(t, health, "/ready"); code != http.StatusServiceUnavailable {
+		t.Errorf("/ready after Close = %d, want 503", code)
+	}
+}
+
+func TestNewServerRequiresSVID(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	sdk := newHeadlessSDK(t, spiffetest.NewHeadlessServer(t, ca))
+
+	if _, err := sdk.NewServer("127.0.0.1:0", okHandler()); err == nil {
+		t.Error("NewServer() succeeded without an SVID")
+	}
+}
+
+func TestServeShutsDownWithSDK(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	sdk := ca.NewSDK(t, paymentID)
+	addr, healthAddr := freeAddr(t), freeAddr(t)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- sdk.Serve(context.Background(), addr, okHandler(), spiffesdk.WithHealthProbes(healthAddr))
+	}()
+
+	// The health probes are plain HTTP and need no client SVID
+	var resp *http.Response
+	var err error
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if resp, err = http.Get("http://" + healthAddr + "/ready"); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("health probe server not reachable: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("/ready = %d, want 200", resp.StatusCode)
+	}
+	if err := getWith(ca.NewSDK(t, clientID).GetHTTPClient(), "https://"+addr); err != nil {
+		t.Errorf("mTLS request failed: %v", err)
+	}
+
+	if err := sdk.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Serve() = %v after Close, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve() did not return after Close")
+	}
+
+	// Servers can't be started on a closed SDK
+	if err := sdk.Serve(context.Background(), addr, okHandler()); !errors.Is(err, spiffesdk.ErrClosed) {
+		t.Errorf("Serve() on a closed SDK = %v, want ErrClosed", err)
+	}
+}
+
+// freeAddr returns a local address that was free a moment ago
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
@@ -7,69 +7,197 @@ import (
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/spiffe/go-spiffe/v2/bundle/spiffebundle"
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
 	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
 	"github.com/spiffe/go-spiffe/v2/workloadapi"
 )
 
 // SpiffeSDK provides complete SPIFFE integration for microservices
 type SpiffeSDK struct {
-	config       *Config
-	headlessAPI  *HeadlessAPI
-	workloadAPI  *workloadapi.X509Source
-	currentSVID  *SVIDCache
-	httpClient   *http.Client
-	tlsConfig    *tls.Config
-	mu           sync.RWMutex
-	ctx          context.Context
-	cancel       context.CancelFunc
+	config           *Config
+	headlessAPI      *HeadlessAPI
+	workloadBundles  *x509bundle.Set // nil while the Workload API is disconnected
+	workloadReady    chan struct{}   // closed on the first Workload API update
+	workloadOnce     sync.Once
+	jwtSource        *workloadapi.JWTSource
+	source           IdentitySource // set by NewSpiffeSDKWithSource
+	identities       []*managedIdentity
+	currentSVID      *SVIDCache
+	jwtCache         *jwtSVIDCache
+	trustBundle      *trustBundle // X.509 authorities of the local trust domain
+	denyList         denyListState
+	deniedPeers      atomic.Pointer[deniedPeers]
+	federatedBundles *spiffebundle.Set
+	webPKIRoots      *x509.CertPool // Replaces the system roots for https_web bundle endpoints
+	httpClient       *http.Client
+	tlsConfig        *tls.Config
+	cacheKey         []byte
+	certOutput       *CertificateOutput // Config.CertificateOutput with defaults
+	servers          map[*Server]struct{}
+	static           bool
+	outputMu         sync.Mutex
+	mu               sync.RWMutex
+	ctx              context.Context
+	cancel           context.CancelFunc
+
+	// Lifecycle, see lifecycle.go
+	state            State
+	stateSubscribers []chan State
+	stateMu          sync.Mutex
+	startMu          sync.Mutex
+	background       sync.WaitGroup
+	closeOnce        sync.Once
+	closed           chan struct{} // closed once Close has finished
+	closeErr         error
 }
 
 // Config holds SDK configuration
 type Config struct {
 	// Service Identity
-	ServiceName     string `json:"service_name"`
-	SPIFFEID        string `json:"spiffe_id"`
-	ServiceType     string `json:"service_type"`     // "application" or "system"
+	ServiceName string `json:"service_name"`
+	SPIFFEID    string `json:"spiffe_id"`
+	ServiceType string `json:"service_type"` // "application" or "system"
+	SVIDHint    string `json:"svid_hint"`    // Picks among several Workload API SVIDs for SPIFFEID
+
+	// Further identities presented via WithIdentity and WithSNIIdentities
+	Identities []Identity `json:"identities"`
 
 	// Kubernetes selectors
-	Namespace       string `json:"namespace"`
-	ServiceAccount  string `json:"service_account"`
-	PodLabels       map[string]string `json:"pod_labels"`
+	Namespace      string            `json:"namespace"`
+	ServiceAccount string            `json:"service_account"`
+	PodLabels      map[string]string `json:"pod_labels"`
+
+	// Evidence sent with registration so the headless API need not trust the
+	// selectors above; see AttestationConfig
+	Attestation *AttestationConfig `json:"attestation"`
 
 	// SPIRE Configuration
-	HeadlessAPIURL  string `json:"headless_api_url"`
-	SocketPath      string `json:"socket_path"`
-	TrustDomain     string `json:"trust_domain"`
+	HeadlessAPIURL string `json:"headless_api_url"`
+	SocketPath     string `json:"socket_path"`
+	TrustDomain    string `json:"trust_domain"`
+
+	// Partner trust domains whose bundles are fetched from bundle endpoints
+	FederatedTrustDomains []FederatedTrustDomain `json:"federated_trust_domains"`
+
+	// Optional on-disk cache of the last good SVID, so the service can restart
+	// while the headless API is unavailable. The key (32 bytes, hex or base64)
+	// read from SVIDCacheKeyEnv or SVIDCacheKeyFile enables AES-GCM encryption.
+	SVIDCachePath    string `json:"svid_cache_path"`
+	SVIDCacheKeyEnv  string `json:"svid_cache_key_env"`
+	SVIDCacheKeyFile string `json:"svid_cache_key_file"`
+
+	// Optional output of the SVID to files for processes that can't use the SDK
+	CertificateOutput *CertificateOutput `json:"certificate_output"`
 
 	// Auto-renewal settings
 	RenewalThreshold time.Duration `json:"renewal_threshold"` // Renew when TTL < threshold
 	CheckInterval    time.Duration `json:"check_interval"`    // How often to check expiry
+
+	// Trust bundle settings. The local bundle is refreshed every
+	// BundleRefreshInterval (default 5m); authorities that leave it are still
+	// trusted for BundleOverlap (default 1h) to cover CA rotation.
+	BundleRefreshInterval time.Duration `json:"bundle_refresh_interval"`
+	BundleOverlap         time.Duration `json:"bundle_overlap"`
+
+	// Deny-list of compromised peers, loaded from a YAML or JSON file and/or
+	// the headless API and reloaded every DenyListRefreshInterval (default 1m)
+	DenyListPath            string        `json:"deny_list_path"`
+	DenyListFromHeadlessAPI bool          `json:"deny_list_from_headless_api"`
+	DenyListRefreshInterval time.Duration `json:"deny_list_refresh_interval"`
+
+	// OnEvent is called for SDK lifecycle events such as policy reloads.
+	// Events are only logged when it is nil.
+	OnEvent func(Event) `json:"-"`
+
+	// Logger receives the SDK's log messages, stdout if nil. Use
+	// log.New(io.Discard, "", 0) to silence them.
+	Logger *log.Logger `json:"-"`
 }
 
-// SVIDCache holds current SVID and metadata
+// SVIDCache holds the SVID the SDK presents and its metadata. It is the single
+// identity used by TLS, file output and the on-disk cache, whichever source
+// issued it.
 type SVIDCache struct {
 	SVID       string    `json:"svid"`
 	PrivateKey string    `json:"private_key"`
 	Bundle     string    `json:"bundle"`
 	ExpiresAt  time.Time `json:"expires_at"`
 	IssuedAt   time.Time `json:"issued_at"`
+	Source     string    `json:"source"` // One of the SVIDSource constants
+	svid       *x509svid.SVID
+	id         string // SPIFFE ID every new SVID must have
+	metrics    bool   // Publish expiry and source to expvar (primary identity only)
 	mu         sync.RWMutex
 }
 
+// Sources an SVID can come from, reported in SVIDCache.Source
+const (
+	SVIDSourceWorkloadAPI = "workload_api"
+	SVIDSourceHeadlessAPI = "headless_api"
+	SVIDSourceDiskCache   = "disk_cache"
+	SVIDSourceStatic      = "static"
+)
+
 // HeadlessAPI client for headless SPIRE service
 type HeadlessAPI struct {
 	BaseURL    string
 	HTTPClient *http.Client
+
+	// Attestation, if set, sends fresh evidence with every workload lookup
+	// and SVID issuance, not just with registration
+	Attestation *AttestationConfig
 }
 
 // NewSpiffeSDK creates a new SPIFFE SDK instance
 func NewSpiffeSDK(config *Config) (*SpiffeSDK, error) {
+	sdk, err := newSDK(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Initialize workload API for direct SPIRE integration (optional - may not be available yet)
+	// If it fails, the supervisor keeps retrying in the background
+	_ = sdk.initWorkloadAPI()
+
+	// The TLS config resolves the SVID on every handshake, so it can be built
+	// before the first SVID arrives and keeps working across renewals
+	sdk.setupTLSConfig()
+
+	return sdk, nil
+}
+
+// newSDK validates config and creates an SDK with no identity yet
+func newSDK(config *Config) (*SpiffeSDK, error) {
+	if err := config.validateFederation(); err != nil {
+		return nil, err
+	}
+	certOutput, err := config.certificateOutput()
+	if err != nil {
+		return nil, err
+	}
+	if err := config.validateIdentities(); err != nil {
+		return nil, err
+	}
+	cacheKey, err := config.loadSVIDCacheKey()
+	if err != nil {
+		return nil, err
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	sdk := &SpiffeSDK{
@@ -79,68 +207,153 @@ func NewSpiffeSDK(config *Config) (*SpiffeSDK, error) {
 			HTTPClient: &http.Client{
 				Timeout: 10 * time.Second, // Add timeout to prevent hanging
 			},
+			Attestation: config.Attestation,
 		},
-		currentSVID: &SVIDCache{},
-		ctx:         ctx,
-		cancel:      cancel,
+		currentSVID:      &SVIDCache{id: config.SPIFFEID, metrics: true},
+		jwtCache:         newJWTSVIDCache(),
+		trustBundle:      newTrustBundle(),
+		federatedBundles: spiffebundle.NewSet(),
+		cacheKey:         cacheKey,
+		certOutput:       certOutput,
+		servers:          make(map[*Server]struct{}),
+		workloadReady:    make(chan struct{}),
+		closed:           make(chan struct{}),
+		ctx:              ctx,
+		cancel:           cancel,
+	}
+	sdk.denyList.conns = make(map[net.Conn]func() []*x509.Certificate)
+	for _, identity := range config.Identities {
+		sdk.identities = append(sdk.identities, &managedIdentity{Identity: identity, svid: &SVIDCache{id: identity.SPIFFEID}})
 	}
-
-	// Initialize workload API for direct SPIRE integration (optional - may not be available yet)
-	// If it fails, we'll try again during Initialize() after registration
-	_ = sdk.initWorkloadAPI()
 
 	return sdk, nil
 }
 
-// Initialize performs the complete setup process
-func (s *SpiffeSDK) Initialize() error {
+// start performs the complete setup process for Start
+func (s *SpiffeSDK) start() error {
+	// Steps 1-2: Register and get the initial SVID. A valid cached SVID lets
+	// the service start right away while registration continues in the
+	// background; an SVID streamed by the Workload API always wins. SDKs
+	// with an identity source just follow its updates.
+	if s.config.hasDenyList() {
+		if err := s.loadDenyList(true); err != nil {
+			return fmt.Errorf("deny-list setup failed: %w", err)
+		}
+	}
+
+	ready := StateReady
+	switch {
+	case s.source != nil:
+	case !s.hasWorkloadAPI() && s.loadCachedSVID():
+		s.writeCertificateFiles()
+		ready = StateDegraded
+	default:
+		if err := s.bootstrap(); err != nil {
+			return err
+		}
+	}
+
+	// Step 3: Start fetching bundles of federated trust domains
+	if err := s.startFederation(); err != nil {
+		return fmt.Errorf("federation setup failed: %w", err)
+	}
+
+	// Step 4: Start auto-renewal background process
+	s.setState(ready)
+	switch {
+	case s.source != nil:
+		s.goBackground(s.watchIdentitySource)
+	case ready == StateDegraded:
+		s.goBackground(s.retryBootstrap)
+		s.goBackground(s.startAutoRenewal)
+	default:
+		s.goBackground(s.startAutoRenewal)
+	}
+
+	if s.source == nil {
+		s.goBackground(s.runBundleRefresher)
+	}
+	if s.config.hasDenyList() {
+		s.goBackground(s.runDenyListRefresher)
+	}
+	if s.certOutput != nil && len(s.certOutput.JWTSVIDs) > 0 {
+		s.goBackground(s.runJWTFileWriter)
+	}
+
+	return nil
+}
+
+// bootstrap registers the service and fetches its first SVID
+func (s *SpiffeSDK) bootstrap() error {
 	// Step 1: Register with headless API (owner registration)
 	if err := s.registerWithHeadlessAPI(); err != nil {
 		return fmt.Errorf("registration failed: %w", err)
 	}
+	if err := s.registerIdentities(); err != nil {
+		return err
+	}
 
 	// Step 1.5: Try to initialize workload API now (after registration)
-	if s.workloadAPI == nil {
+	if !s.hasWorkloadAPI() {
 		_ = s.initWorkloadAPI() // Ignore error, will use headless API for SVIDs
 	}
 
-	// Step 2: Get initial SVID
+	// Step 2: Get initial SVIDs, unless the Workload API already streams them
+	for _, identity := range s.identities {
+		if identity.svid.snapshot() != nil {
+			continue
+		}
+		if err := s.refreshIdentitySVID(identity); err != nil {
+			return fmt.Errorf("initial SVID fetch failed: %w", err)
+		}
+	}
+	if s.hasWorkloadAPI() {
+		return nil
+	}
 	if err := s.refreshSVID(); err != nil {
 		return fmt.Errorf("initial SVID fetch failed: %w", err)
 	}
-
-	// Step 3: Setup TLS configuration (only if workload API is available)
-	if s.workloadAPI != nil {
-		if err := s.setupTLSConfig(); err != nil {
-			return fmt.Errorf("TLS setup failed: %w", err)
-		}
-	}
-
-	// Step 4: Start auto-renewal background process
-	go s.startAutoRenewal()
-
 	return nil
 }
 
 // Register service with headless SPIRE API
 func (s *SpiffeSDK) registerWithHeadlessAPI() error {
-	selectors := []string{
-		fmt.Sprintf("k8s:ns:%s", s.config.Namespace),
-		fmt.Sprintf("k8s:sa:%s", s.config.ServiceAccount),
+	payload, err := s.config.RegistrationPayload()
+	if err != nil {
+		return err
 	}
+	return s.headlessAPI.RegisterAndIssueSVID(payload)
+}
 
-	// Add pod labels as selectors
-	for key, value := range s.config.PodLabels {
-		selectors = append(selectors, fmt.Sprintf("k8s:pod-label:%s:%s", key, value))
-	}
+// RegistrationPayload is the body of the headless API registration request,
+// including the attestation evidence if Attestation is configured
+func (c *Config) RegistrationPayload() (map[string]interface{}, error) {
+	return c.registrationPayloadFor(c.SPIFFEID, c.ServiceType)
+}
 
+// registrationPayloadFor is the registration request for one of the
+// workload's identities, all sharing its selectors and attestation evidence
+func (c *Config) registrationPayloadFor(spiffeID, serviceType string) (map[string]interface{}, error) {
 	payload := map[string]interface{}{
-		"spiffe_id": s.config.SPIFFEID,
-		"type":      s.config.ServiceType,
-		"selectors": selectors,
+		"spiffe_id": spiffeID,
+		"type":      serviceType,
+	}
+	if c.Attestation == nil {
+		payload["selectors"] = c.Selectors()
+		return payload, nil
 	}
 
-	return s.headlessAPI.RegisterAndIssueSVID(payload)
+	evidence, err := c.Attestation.evidence()
+	if err != nil {
+		return nil, fmt.Errorf("attestation evidence unavailable: %w", err)
+	}
+	payload["attestation"] = evidence
+	if c.Attestation.DeriveSelectors {
+		payload["derive_selectors"] = true
+	} else {
+		payload["selectors"] = c.Selectors()
+	}
+	return payload, nil
 }
 
 // Refresh SVID from headless API
@@ -150,18 +363,21 @@ func (s *SpiffeSDK) refreshSVID() error {
 		return err
 	}
 
-	s.currentSVID.mu.Lock()
-	s.currentSVID.SVID = svid.X509SVID
-	s.currentSVID.PrivateKey = svid.PrivateKey
-	s.currentSVID.Bundle = svid.Bundle
-	s.currentSVID.ExpiresAt = svid.ExpiresAt
-	s.currentSVID.IssuedAt = svid.IssuedAt
-	s.currentSVID.mu.Unlock()
+	if err := s.currentSVID.update(svid.X509SVID, svid.PrivateKey, svid.Bundle, svid.IssuedAt, SVIDSourceHeadlessAPI); err != nil {
+		s.rejectSVID(s.config.SPIFFEID, SVIDSourceHeadlessAPI, err)
+		return fmt.Errorf("headless API issued an invalid SVID: %w", err)
+	}
+
+	s.syncTrustBundle(SVIDSourceHeadlessAPI)
+	s.persistSVID()
+	s.writeCertificateFiles()
 
 	return nil
 }
 
-// Auto-renewal background process
+// Auto-renewal background process. While the Workload API is connected it
+// rotates the SVID itself; the headless API is only polled if the SVID gets
+// close to expiry anyway, e.g. because the agent is unreachable.
 func (s *SpiffeSDK) startAutoRenewal() {
 	ticker := time.NewTicker(s.config.CheckInterval)
 	defer ticker.Stop()
@@ -176,24 +392,40 @@ func (s *SpiffeSDK) startAutoRenewal() {
 			s.currentSVID.mu.RUnlock()
 
 			if timeToExpiry <= s.config.RenewalThreshold {
+				if s.hasWorkloadAPI() {
+					s.logf("Workload API has not rotated the SVID, falling back to headless API")
+				}
 				if err := s.refreshSVID(); err != nil {
 					// Log error but continue trying
-					fmt.Printf("SVID renewal failed: %v\n", err)
+					s.logf("SVID renewal failed: %v", err)
+					s.markDegraded()
 				} else {
-					fmt.Printf("SVID renewed successfully, expires at: %v\n", s.currentSVID.ExpiresAt)
+					s.markReady()
+					s.logf("SVID renewed successfully, expires at: %v", s.GetCurrentSVID().ExpiresAt)
 				}
 			}
+			s.renewIdentities()
 		}
 	}
 }
 
 // GetHTTPClient returns an HTTP client configured with SPIFFE mTLS for internal service calls
 // Use this for calling other services in the same trust domain
-func (s *SpiffeSDK) GetHTTPClient() *http.Client {
+func (s *SpiffeSDK) GetHTTPClient(opts ...ClientOption) *http.Client {
+	var options clientOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	tlsConfig := s.tlsConfig
+	if options.identity != "" {
+		tlsConfig = tlsconfig.MTLSClientConfig(identitySource{s, options.identity}, s, s.DenyListAuthorizer(nil))
+	}
+
 	return &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: s.tlsConfig,
-		},
+		Transport: s.trackTransport(&http.Transport{
+			TLSClientConfig: tlsConfig,
+		}),
 		Timeout: 30 * time.Second,
 	}
 }
@@ -205,45 +437,135 @@ func (s *SpiffeSDK) NewInternalHTTPClient(internalDomains []string) *http.Client
 		Transport: &smartTransport{
 			sdk:             s,
 			internalDomains: internalDomains,
-			mtlsTransport: &http.Transport{
+			mtlsTransport: s.trackTransport(&http.Transport{
 				TLSClientConfig: s.tlsConfig,
-			},
+			}),
 			regularTransport: http.DefaultTransport,
 		},
 		Timeout: 30 * time.Second,
 	}
 }
 
+// Route pins the SPIFFE identity a destination must present
+type Route struct {
+	// Host is matched like an internal domain: an exact hostname
+	// (e.g. "payment-service.authsec.svc.cluster.local") or a ".suffix" pattern
+	Host string `json:"host"`
+
+	// ExpectedID is the SPIFFE ID the server behind Host must present
+	ExpectedID string `json:"expected_id"`
+
+	// Authorizer replaces ExpectedID for custom checks (e.g. AuthorizeOneOf)
+	Authorizer tlsconfig.Authorizer `json:"-"`
+}
+
+// NewRoutedHTTPClient creates an HTTP client like NewInternalHTTPClient that also
+// verifies the server identity per destination. Requests to a host matching a
+// route fail the TLS handshake unless the server presents the route's SPIFFE ID.
+// Hosts matching only internalDomains accept any SVID from the trust bundle.
+// When several routes match, exact hosts win over suffixes and longer patterns
+// win over shorter ones.
+func (s *SpiffeSDK) NewRoutedHTTPClient(routes []Route, internalDomains []string) (*http.Client, error) {
+	routeTransports := make([]routeTransport, 0, len(routes))
+	for _, route := range routes {
+		if route.Host == "" {
+			return nil, errors.New("route host must not be empty")
+		}
+
+		authorizer := route.Authorizer
+		if authorizer == nil {
+			expectedID, err := spiffeid.FromString(route.ExpectedID)
+			if err != nil {
+				return nil, fmt.Errorf("invalid expected SPIFFE ID for route %q: %w", route.Host, err)
+			}
+			authorizer = tlsconfig.AuthorizeID(expectedID)
+		}
+
+		routeTransports = append(routeTransports, routeTransport{
+			host: route.Host,
+			transport: s.trackTransport(&http.Transport{
+				TLSClientConfig: tlsconfig.MTLSClientConfig(s, s, s.DenyListAuthorizer(authorizer)),
+			}),
+		})
+	}
+
+	sort.SliceStable(routeTransports, func(i, j int) bool {
+		a, b := routeTransports[i].host, routeTransports[j].host
+		if (a[0] == '.') != (b[0] == '.') {
+			return b[0] == '.'
+		}
+		return len(a) > len(b)
+	})
+
+	return &http.Client{
+		Transport: &smartTransport{
+			sdk:             s,
+			internalDomains: internalDomains,
+			routes:          routeTransports,
+			mtlsTransport: s.trackTransport(&http.Transport{
+				TLSClientConfig: s.tlsConfig,
+			}),
+			regularTransport: http.DefaultTransport,
+		},
+		Timeout: 30 * time.Second,
+	}, nil
+}
+
 // smartTransport switches between mTLS and regular HTTP based on target
 type smartTransport struct {
 	sdk              *SpiffeSDK
 	internalDomains  []string
+	routes           []routeTransport
 	mtlsTransport    http.RoundTripper
 	regularTransport http.RoundTripper
 }
 
+// routeTransport is an mTLS transport that only accepts the route's server identity
+type routeTransport struct {
+	host      string
+	transport http.RoundTripper
+}
+
 func (t *smartTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	// Check if this is an internal service call
-	host := req.URL.Host
+	// Check if this is an internal service call; ports never take part in matching
+	host := req.URL.Hostname()
 	if host == "" {
 		host = req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+	}
+
+	// Pinned routes take precedence over the generic internal domains
+	for _, route := range t.routes {
+		if matchesDomain(host, route.host) {
+			return route.transport.RoundTrip(req)
+		}
 	}
 
 	for _, domain := range t.internalDomains {
-		// Check for exact match or suffix match (e.g., .svc.cluster.local)
-		if host == domain || (len(domain) > 0 && domain[0] == '.' && hasSuffix(host, domain)) {
+		if matchesDomain(host, domain) {
 			// Use mTLS for internal services
 			return t.mtlsTransport.RoundTrip(req)
 		}
-		// Check if host contains the domain (for k8s services like service.namespace.svc.cluster.local)
-		if len(domain) > 1 && domain[0] != '.' && (host == domain || hasSuffix(host, "."+domain)) {
-			return t.mtlsTransport.RoundTrip(req)
-		}
 	}
 	// Use regular HTTP for external services
 	return t.regularTransport.RoundTrip(req)
 }
 
+// matchesDomain reports whether host is domain or, for k8s services like
+// service.namespace.svc.cluster.local, a subdomain of it. A leading dot
+// (e.g. ".svc.cluster.local") only matches as a suffix.
+func matchesDomain(host, domain string) bool {
+	if host == domain {
+		return true
+	}
+	if len(domain) > 0 && domain[0] == '.' {
+		return hasSuffix(host, domain)
+	}
+	return len(domain) > 1 && hasSuffix(host, "."+domain)
+}
+
 func hasSuffix(s, suffix string) bool {
 	return len(s) >= len(suffix) && s[len(s)-len(suffix):] == suffix
 }
@@ -261,12 +583,22 @@ func (s *SpiffeSDK) GetHTTPServer(addr string, handler http.Handler, validateInc
 	return &http.Server{
 		Addr:      addr,
 		Handler:   finalHandler,
-		TLSConfig: tlsconfig.MTLSServerConfig(s.workloadAPI, s.workloadAPI, tlsconfig.AuthorizeAny()),
+		TLSConfig: tlsconfig.MTLSServerConfig(s, s, s.DenyListAuthorizer(nil)),
+		ConnState: s.trackConn,
 	}
 }
 
 // ValidateIncomingSVID validates an incoming certificate
 func (s *SpiffeSDK) ValidateIncomingSVID(cert string) (*ValidationResult, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+
+	// Without a headless API (e.g. static SDKs) certificates are verified locally
+	if s.config.HeadlessAPIURL == "" {
+		return s.verifyCertificateLocally(cert)
+	}
+
 	payload := map[string]string{
 		"certificate": cert,
 	}
@@ -279,6 +611,9 @@ func (s *SpiffeSDK) IncomingValidationMiddleware(next http.Handler) http.Handler
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Extract client certificate from TLS connection
 		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+			if s.denyPeerRequest(w, r) {
+				return
+			}
 			clientCert := r.TLS.PeerCertificates[0]
 
 			// Convert to PEM format for validation
@@ -291,40 +626,83 @@ func (s *SpiffeSDK) IncomingValidationMiddleware(next http.Handler) http.Handler
 			}
 
 			// Add SPIFFE ID to request context
-			ctx := context.WithValue(r.Context(), "spiffe_id", result.SPIFFEID)
-			r = r.WithContext(ctx)
+			r = r.WithContext(withSPIFFEID(r.Context(), result.SPIFFEID))
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
 
-// OutgoingAttachmentMiddleware for HTTP clients
+// spiffeIDContextKey is kept as the plain "spiffe_id" string so handlers that
+// read r.Context().Value("spiffe_id") keep working
+const spiffeIDContextKey = "spiffe_id"
+
+// SPIFFEIDFromContext returns the authenticated caller's SPIFFE ID stored by
+// the SDK's validation middlewares
+func SPIFFEIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(spiffeIDContextKey).(string)
+	return id, ok && id != ""
+}
+
+func withSPIFFEID(ctx context.Context, spiffeID string) context.Context {
+	return context.WithValue(ctx, spiffeIDContextKey, spiffeID)
+}
+
+// OutgoingAttachmentMiddleware for HTTP clients. It returns a clone of rt that
+// presents this service's current SVID on every outbound TLS connection and
+// verifies the server SVID against the trust bundle. Proxies, timeouts,
+// connection pooling and HTTP/2 settings of rt are preserved.
+//
+// rt must be nil (http.DefaultTransport is used) or an *http.Transport. Any other
+// RoundTripper cannot be given a client certificate, so the returned
+// RoundTripper fails every request rather than silently sending it without an
+// SVID; use AttachSVID to get the error up front instead.
 func (s *SpiffeSDK) OutgoingAttachmentMiddleware(rt http.RoundTripper) http.RoundTripper {
-	return &spiffeMTLSTransport{
-		sdk:       s,
-		transport: rt,
+	transport, err := s.AttachSVID(rt)
+	if err != nil {
+		return failingTransport{err}
 	}
+	return transport
 }
 
-// spiffeMTLSTransport implements http.RoundTripper with SPIFFE mTLS
-type spiffeMTLSTransport struct {
-	sdk       *SpiffeSDK
-	transport http.RoundTripper
+// failingTransport fails every request with err
+type failingTransport struct {
+	err error
 }
 
-func (t *spiffeMTLSTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	// Clone the request and add SPIFFE TLS config
-	req = req.Clone(req.Context())
+func (t failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+	return nil, t.err
+}
 
-	// Use the SPIFFE-configured transport
-	if t.transport == nil {
-		t.transport = &http.Transport{
-			TLSClientConfig: t.sdk.tlsConfig,
-		}
+// AttachSVID is like OutgoingAttachmentMiddleware but returns an error for
+// RoundTrippers it cannot adapt
+func (s *SpiffeSDK) AttachSVID(rt http.RoundTripper) (*http.Transport, error) {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+
+	base, ok := rt.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("cannot attach SVID to RoundTripper of type %T, only *http.Transport is supported", rt)
+	}
+
+	transport := base.Clone()
+
+	// Keep non-authentication TLS settings such as MinVersion and NextProtos;
+	// the hook replaces certificates and verification with SPIFFE callbacks
+	var tlsConfig *tls.Config
+	if base.TLSClientConfig != nil {
+		tlsConfig = base.TLSClientConfig.Clone()
+	} else {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
 	}
+	tlsconfig.HookMTLSClientConfig(tlsConfig, s, s, s.DenyListAuthorizer(nil))
+	transport.TLSClientConfig = tlsConfig
 
-	return t.transport.RoundTrip(req)
+	return s.trackTransport(transport), nil
 }
 
 // Helper functions and types...
@@ -338,6 +716,14 @@ type ValidationResult struct {
 	NotAfter  string `json:"not_after"`
 }
 
+// Workload is a workload registration in the headless API
+type Workload struct {
+	ID        string   `json:"id"`
+	SPIFFEID  string   `json:"spiffe_id"`
+	Type      string   `json:"type,omitempty"`
+	Selectors []string `json:"selectors,omitempty"`
+}
+
 type SVIDResponse struct {
 	ID         string    `json:"id"`
 	WorkloadID string    `json:"workload_id"`
@@ -350,30 +736,186 @@ type SVIDResponse struct {
 }
 
 // Implementation of helper methods...
-func (s *SpiffeSDK) initWorkloadAPI() error {
-	// Create a timeout context for workload API initialization
-	ctx, cancel := context.WithTimeout(s.ctx, 5*time.Second)
-	defer cancel()
-
-	source, err := workloadapi.NewX509Source(
-		ctx,
-		workloadapi.WithClientOptions(
-			workloadapi.WithAddr("unix://"+s.config.SocketPath),
-		),
-	)
+func (s *SpiffeSDK) setupTLSConfig() {
+	// Create SPIFFE-aware TLS config backed by the SDK itself, so it works in
+	// both headless and workload API modes
+	s.tlsConfig = tlsconfig.MTLSClientConfig(s, s, s.DenyListAuthorizer(nil))
+}
+
+// GetX509SVID returns the SVID this service currently presents. It implements
+// x509svid.Source.
+func (s *SpiffeSDK) GetX509SVID() (*x509svid.SVID, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	return s.currentSVID.x509SVID()
+}
+
+// GetCurrentSVID returns a snapshot of the SVID this service currently
+// presents, or nil if none has been issued yet
+func (s *SpiffeSDK) GetCurrentSVID() *SVIDCache {
+	return s.currentSVID.snapshot()
+}
+
+// GetX509BundleForTrustDomain returns the trust bundle used to verify peers.
+// It implements x509bundle.Source.
+func (s *SpiffeSDK) GetX509BundleForTrustDomain(trustDomain spiffeid.TrustDomain) (*x509bundle.Bundle, error) {
+	// Federated trust domains use the bundles fetched from their endpoints
+	if bundle, ok := s.federatedBundles.Get(trustDomain); ok {
+		return bundle.X509Bundle(), nil
+	}
+
+	if s.source != nil {
+		return s.source.GetX509BundleForTrustDomain(trustDomain)
+	}
+
+	// The local trust domain, including authorities in their overlap period
+	if bundle := s.trustBundle.get(trustDomain); bundle != nil {
+		return bundle, nil
+	}
+
+	s.mu.RLock()
+	bundles := s.workloadBundles
+	s.mu.RUnlock()
+
+	if bundles != nil {
+		return bundles.GetX509BundleForTrustDomain(trustDomain)
+	}
+
+	localTD, err := spiffeid.TrustDomainFromString(s.config.TrustDomain)
 	if err != nil {
+		return nil, fmt.Errorf("invalid trust domain %q: %w", s.config.TrustDomain, err)
+	}
+	if trustDomain != localTD {
+		return nil, fmt.Errorf("no bundle for trust domain %q", trustDomain)
+	}
+	return s.currentSVID.x509Bundle(localTD)
+}
+
+// installSVID makes svid, issued by source, the SDK's identity if it changed
+func (s *SpiffeSDK) installSVID(svid *x509svid.SVID, bundles x509bundle.Source, source string) error {
+	changed, err := s.currentSVID.install(svid, bundles, source)
+	if err != nil {
+		s.rejectSVID(s.config.SPIFFEID, source, err)
 		return err
 	}
-	s.workloadAPI = source
+	if !changed {
+		return nil
+	}
+
+	s.logf("Installed SVID from %s, expires at: %v", source, svid.Certificates[0].NotAfter)
+	s.persistSVID()
+	s.writeCertificateFiles()
 	return nil
 }
 
-func (s *SpiffeSDK) setupTLSConfig() error {
-	// Create SPIFFE-aware TLS config
-	s.tlsConfig = tlsconfig.MTLSClientConfig(s.workloadAPI, s.workloadAPI, tlsconfig.AuthorizeAny())
+// install stores svid and its trust domain's bundle if the SVID changed, and
+// reports whether it did
+func (c *SVIDCache) install(svid *x509svid.SVID, bundles x509bundle.Source, source string) (bool, error) {
+	c.mu.RLock()
+	unchanged := c.svid != nil && c.svid.Certificates[0].Equal(svid.Certificates[0])
+	c.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	certPEM, keyPEM, err := svid.Marshal()
+	if err != nil {
+		return false, err
+	}
+	bundle, err := bundles.GetX509BundleForTrustDomain(svid.ID.TrustDomain())
+	if err != nil {
+		return false, err
+	}
+	bundlePEM, err := bundle.Marshal()
+	if err != nil {
+		return false, err
+	}
+	if err := c.update(string(certPEM), string(keyPEM), string(bundlePEM), time.Time{}, source); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// snapshot returns a copy of the cache, or nil if it holds no SVID
+func (c *SVIDCache) snapshot() *SVIDCache {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.svid == nil {
+		return nil
+	}
+	return &SVIDCache{
+		SVID:       c.SVID,
+		PrivateKey: c.PrivateKey,
+		Bundle:     c.Bundle,
+		ExpiresAt:  c.ExpiresAt,
+		IssuedAt:   c.IssuedAt,
+		Source:     c.Source,
+		svid:       c.svid,
+	}
+}
+
+// x509SVID returns the cached SVID
+func (c *SVIDCache) x509SVID() (*x509svid.SVID, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.svid == nil {
+		return nil, errors.New("no SVID has been issued yet")
+	}
+	return c.svid, nil
+}
+
+// update parses, verifies and installs a PEM SVID from source. If it is
+// rejected the cache keeps the previous SVID. ExpiresAt always comes from the
+// certificate; a zero issuedAt defaults to its NotBefore.
+func (c *SVIDCache) update(certPEM, keyPEM, bundlePEM string, issuedAt time.Time, source string) error {
+	svid, err := x509svid.Parse([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return err
+	}
+	bundle, err := x509bundle.Parse(svid.ID.TrustDomain(), []byte(bundlePEM))
+	if err != nil {
+		return fmt.Errorf("invalid trust bundle: %w", err)
+	}
+	if err := VerifySVID(svid, bundle, c.id); err != nil {
+		return err
+	}
+	leaf := svid.Certificates[0]
+	if issuedAt.IsZero() {
+		issuedAt = leaf.NotBefore
+	}
+
+	c.mu.Lock()
+	c.SVID = certPEM
+	c.PrivateKey = keyPEM
+	c.Bundle = bundlePEM
+	c.ExpiresAt = leaf.NotAfter
+	c.IssuedAt = issuedAt
+	c.Source = source
+	c.svid = svid
+	c.mu.Unlock()
+
+	if c.metrics {
+		svidExpiresAt.Set(leaf.NotAfter.Unix())
+		svidSource.Set(source)
+		metrics.Add("svid_updates_total", 1)
+	}
 	return nil
 }
 
+// x509Bundle parses the cached PEM trust bundle
+func (c *SVIDCache) x509Bundle(trustDomain spiffeid.TrustDomain) (*x509bundle.Bundle, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.Bundle == "" {
+		return nil, errors.New("no trust bundle has been issued yet")
+	}
+	return x509bundle.Parse(trustDomain, []byte(c.Bundle))
+}
+
 func (s *SpiffeSDK) certToPEM(cert *x509.Certificate) string {
 	// Convert x509.Certificate to PEM format
 	certPEM := &pem.Block{
@@ -385,15 +927,22 @@ func (s *SpiffeSDK) certToPEM(cert *x509.Certificate) string {
 
 // HeadlessAPI methods...
 func (api *HeadlessAPI) RegisterAndIssueSVID(payload map[string]interface{}) error {
+	return api.registerAndIssueSVID(context.Background(), payload)
+}
+
+func (api *HeadlessAPI) registerAndIssueSVID(ctx context.Context, payload map[string]interface{}) error {
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", api.BaseURL+"/spiresvc/api/v1/workloads/register-and-issue", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", api.BaseURL+"/spiresvc/api/v1/workloads/register-and-issue", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
+	if err := api.attest(req); err != nil {
+		return err
+	}
 
 	req.Header.Set("Content-Type", "application/json")
 
@@ -413,10 +962,98 @@ func (api *HeadlessAPI) RegisterAndIssueSVID(payload map[string]interface{}) err
 
 func (api *HeadlessAPI) GetOrRefreshSVID(spiffeID string) (*SVIDResponse, error) {
 	// Try to get existing SVID first by listing workloads
-	req, err := http.NewRequest("GET", api.BaseURL+"/spiresvc/api/v1/workloads?spiffe_id="+spiffeID, nil)
+	workloadID, err := api.findWorkloadID(context.Background(), spiffeID)
+	if err != nil {
+		return nil, err
+	}
+
+	return api.IssueSVID(workloadID)
+}
+
+// IssueSVID issues a new X.509-SVID for a registered workload
+func (api *HeadlessAPI) IssueSVID(workloadID string) (*SVIDResponse, error) {
+	return api.IssueSVIDWithTTL(workloadID, 0)
+}
+
+// IssueSVIDWithTTL issues a new X.509-SVID for a registered workload,
+// requesting the given lifetime. The server may shorten it; a zero ttl uses
+// the server's default.
+func (api *HeadlessAPI) IssueSVIDWithTTL(workloadID string, ttl time.Duration) (*SVIDResponse, error) {
+	return api.issueSVID(context.Background(), workloadID, ttl)
+}
+
+func (api *HeadlessAPI) issueSVID(ctx context.Context, workloadID string, ttl time.Duration) (*SVIDResponse, error) {
+	var body io.Reader
+	if ttl > 0 {
+		jsonData, err := json.Marshal(map[string]int64{"ttl": int64(ttl / time.Second)})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal payload: %w", err)
+		}
+		body = bytes.NewBuffer(jsonData)
+	}
+
+	svidReq, err := http.NewRequestWithContext(ctx, "POST", api.BaseURL+"/spiresvc/api/v1/workloads/"+workloadID+"/svid", body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SVID request: %w", err)
+	}
+	if body != nil {
+		svidReq.Header.Set("Content-Type", "application/json")
+	}
+	if err := api.attest(svidReq); err != nil {
+		return nil, err
+	}
+
+	svidResp, err := api.HTTPClient.Do(svidReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send SVID request: %w", err)
+	}
+	defer svidResp.Body.Close()
+
+	if svidResp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(svidResp.Body)
+		return nil, fmt.Errorf("SVID issuance failed with status %d: %s", svidResp.StatusCode, string(body))
+	}
+
+	var svid SVIDResponse
+	if err := json.NewDecoder(svidResp.Body).Decode(&svid); err != nil {
+		return nil, fmt.Errorf("failed to decode SVID response: %w", err)
+	}
+
+	return &svid, nil
+}
+
+// findWorkloadID looks up the registered workload for a SPIFFE ID
+func (api *HeadlessAPI) findWorkloadID(ctx context.Context, spiffeID string) (string, error) {
+	workloads, err := api.listWorkloads(ctx, spiffeID)
+	if err != nil {
+		return "", err
+	}
+
+	if len(workloads) == 0 {
+		return "", fmt.Errorf("workload not found for SPIFFE ID: %s", spiffeID)
+	}
+
+	return workloads[0].ID, nil
+}
+
+// ListWorkloads lists registered workloads, optionally filtered by SPIFFE ID
+func (api *HeadlessAPI) ListWorkloads(spiffeID string) ([]Workload, error) {
+	return api.listWorkloads(context.Background(), spiffeID)
+}
+
+func (api *HeadlessAPI) listWorkloads(ctx context.Context, spiffeID string) ([]Workload, error) {
+	endpoint := api.BaseURL + "/spiresvc/api/v1/workloads"
+	if spiffeID != "" {
+		endpoint += "?spiffe_id=" + url.QueryEscape(spiffeID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
+	if err := api.attest(req); err != nil {
+		return nil, err
+	}
 
 	resp, err := api.HTTPClient.Do(req)
 	if err != nil {
@@ -430,45 +1067,67 @@ func (api *HeadlessAPI) GetOrRefreshSVID(spiffeID string) (*SVIDResponse, error)
 	}
 
 	var workloadResp struct {
-		Workloads []struct {
-			ID       string `json:"id"`
-			SPIFFEID string `json:"spiffe_id"`
-		} `json:"workloads"`
+		Workloads []Workload `json:"workloads"`
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&workloadResp); err != nil {
 		return nil, fmt.Errorf("failed to decode workload response: %w", err)
 	}
 
-	if len(workloadResp.Workloads) == 0 {
-		return nil, fmt.Errorf("workload not found for SPIFFE ID: %s", spiffeID)
-	}
+	return workloadResp.Workloads, nil
+}
 
-	workloadID := workloadResp.Workloads[0].ID
+// GetWorkload returns a registered workload by ID
+func (api *HeadlessAPI) GetWorkload(workloadID string) (*Workload, error) {
+	req, err := http.NewRequest("GET", api.BaseURL+"/spiresvc/api/v1/workloads/"+url.PathEscape(workloadID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if err := api.attest(req); err != nil {
+		return nil, err
+	}
 
-	// Issue new SVID
-	svidReq, err := http.NewRequest("POST", api.BaseURL+"/spiresvc/api/v1/workloads/"+workloadID+"/svid", nil)
+	resp, err := api.HTTPClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create SVID request: %w", err)
+		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
+	defer resp.Body.Close()
 
-	svidResp, err := api.HTTPClient.Do(svidReq)
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to get workload with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var workload Workload
+	if err := json.NewDecoder(resp.Body).Decode(&workload); err != nil {
+		return nil, fmt.Errorf("failed to decode workload response: %w", err)
+	}
+
+	return &workload, nil
+}
+
+// DeleteWorkload removes a workload registration
+func (api *HeadlessAPI) DeleteWorkload(workloadID string) error {
+	req, err := http.NewRequest("DELETE", api.BaseURL+"/spiresvc/api/v1/workloads/"+url.PathEscape(workloadID), nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send SVID request: %w", err)
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if err := api.attest(req); err != nil {
+		return err
 	}
-	defer svidResp.Body.Close()
 
-	if svidResp.StatusCode != http.StatusCreated {
-		body, _ := io.ReadAll(svidResp.Body)
-		return nil, fmt.Errorf("SVID issuance failed with status %d: %s", svidResp.StatusCode, string(body))
+	resp, err := api.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
 	}
+	defer resp.Body.Close()
 
-	var svid SVIDResponse
-	if err := json.NewDecoder(svidResp.Body).Decode(&svid); err != nil {
-		return nil, fmt.Errorf("failed to decode SVID response: %w", err)
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("workload deletion failed with status %d: %s", resp.StatusCode, string(body))
 	}
 
-	return &svid, nil
+	return nil
 }
 
 func (api *HeadlessAPI) VerifyCertificate(payload map[string]string) (*ValidationResult, error) {
@@ -481,6 +1140,9 @@ func (api *HeadlessAPI) VerifyCertificate(payload map[string]string) (*Validatio
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
+	if err := api.attest(req); err != nil {
+		return nil, err
+	}
 
 	req.Header.Set("Content-Type", "application/json")
 
@@ -497,12 +1159,3 @@ func (api *HeadlessAPI) VerifyCertificate(payload map[string]string) (*Validatio
 
 	return &result, nil
 }
-
-// Close cleans up resources
-func (s *SpiffeSDK) Close() error {
-	s.cancel()
-	if s.workloadAPI != nil {
-		return s.workloadAPI.Close()
-	}
-	return nil
-}
\ No newline at end of file
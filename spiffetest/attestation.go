@@ -0,0 +1,197 @@
+package spiffetest
+
+import (
+	"crypto/ecdsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	spiffesdk "github.com/authsec-ai/spiffe-sdk"
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+)
+
+// DefaultAttestationAudience is the token audience HeadlessServer expects
+// unless its Audience is set, matching the SDK's default
+const DefaultAttestationAudience = "spiffe-headless-api"
+
+// Pod describes the pod a projected service account token is minted for
+type Pod struct {
+	Namespace      string
+	ServiceAccount string
+	Name           string
+	UID            string
+	NodeName       string
+}
+
+// serviceAccountClaims are the claims of a projected service account token
+type serviceAccountClaims struct {
+	jwt.Claims
+	Kubernetes struct {
+		Namespace      string            `json:"namespace"`
+		ServiceAccount kubernetesObject  `json:"serviceaccount"`
+		Pod            *kubernetesObject `json:"pod,omitempty"`
+		Node           *kubernetesObject `json:"node,omitempty"`
+	} `json:"kubernetes.io"`
+}
+
+type kubernetesObject struct {
+	Name string `json:"name"`
+	UID  string `json:"uid,omitempty"`
+}
+
+// ServiceAccountToken mints a projected service account token for pod and
+// audience, as the kubelet would mount it. The server accepts it as
+// attestation evidence; tokens it didn't mint fail its TokenReview. The token
+// is valid for DefaultTTL.
+func (s *HeadlessServer) ServiceAccountToken(tb testing.TB, pod Pod, audience string) string {
+	tb.Helper()
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: s.tokenKey}, new(jose.SignerOptions).WithType("JWT"))
+	if err != nil {
+		tb.Fatalf("spiffetest: %v", err)
+	}
+
+	now := time.Now()
+	var claims serviceAccountClaims
+	claims.Claims = jwt.Claims{
+		Issuer:   "https://kubernetes.default.svc.cluster.local",
+		Subject:  fmt.Sprintf("system:serviceaccount:%s:%s", pod.Namespace, pod.ServiceAccount),
+		Audience: jwt.Audience{audience},
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(now.Add(DefaultTTL)),
+	}
+	claims.Kubernetes.Namespace = pod.Namespace
+	claims.Kubernetes.ServiceAccount = kubernetesObject{Name: pod.ServiceAccount}
+	if pod.Name != "" || pod.UID != "" {
+		claims.Kubernetes.Pod = &kubernetesObject{Name: pod.Name, UID: pod.UID}
+	}
+	if pod.NodeName != "" {
+		claims.Kubernetes.Node = &kubernetesObject{Name: pod.NodeName}
+	}
+
+	token, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		tb.Fatalf("spiffetest: failed to sign service account token: %v", err)
+	}
+	return token
+}
+
+// ServiceAccountTokenFile writes a token from ServiceAccountToken to a
+// temporary file, for use as AttestationConfig.TokenPath
+func (s *HeadlessServer) ServiceAccountTokenFile(tb testing.TB, pod Pod, audience string) string {
+	tb.Helper()
+
+	path := filepath.Join(tb.TempDir(), "token")
+	if err := os.WriteFile(path, []byte(s.ServiceAccountToken(tb, pod, audience)), 0o600); err != nil {
+		tb.Fatalf("spiffetest: %v", err)
+	}
+	return path
+}
+
+// attest reviews registration evidence like the headless API's TokenReview
+// and returns the selectors it proves
+func (s *HeadlessServer) attest(evidence *spiffesdk.AttestationEvidence) ([]string, error) {
+	if evidence == nil {
+		return nil, errors.New("no attestation evidence")
+	}
+	if evidence.Type != spiffesdk.AttestationTypeK8sPSAT {
+		return nil, fmt.Errorf("unsupported attestation type %q", evidence.Type)
+	}
+
+	token, err := jwt.ParseSigned(evidence.Token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	var claims serviceAccountClaims
+	if err := token.Claims(s.tokenKey.Public().(*ecdsa.PublicKey), &claims); err != nil {
+		return nil, fmt.Errorf("token review failed: %w", err)
+	}
+
+	audience := s.Audience
+	if audience == "" {
+		audience = DefaultAttestationAudience
+	}
+	if err := claims.ValidateWithLeeway(jwt.Expected{Audience: jwt.Audience{audience}, Time: time.Now()}, 0); err != nil {
+		return nil, fmt.Errorf("token review failed: %w", err)
+	}
+
+	k8s := claims.Kubernetes
+	selectors := []string{
+		"k8s:ns:" + k8s.Namespace,
+		"k8s:sa:" + k8s.ServiceAccount.Name,
+	}
+	if k8s.Pod != nil {
+		if evidence.PodUID != "" && evidence.PodUID != k8s.Pod.UID {
+			return nil, fmt.Errorf("pod UID %s does not match the token", evidence.PodUID)
+		}
+		selectors = append(selectors, "k8s:pod-uid:"+k8s.Pod.UID)
+	}
+	if k8s.Node != nil {
+		if evidence.NodeName != "" && evidence.NodeName != k8s.Node.Name {
+			return nil, fmt.Errorf("node %s does not match the token", evidence.NodeName)
+		}
+		selectors = append(selectors, "k8s:node-name:"+k8s.Node.Name)
+	}
+	return selectors, nil
+}
+
+// attestRequest checks the evidence in spiffesdk.AttestationHeader of a call
+// that looks up workloads or issues an SVID for workloadID
+func (s *HeadlessServer) attestRequest(r *http.Request, workloadID string) error {
+	header := r.Header.Get(spiffesdk.AttestationHeader)
+	if header == "" && !s.RequireAttestation {
+		return nil
+	}
+
+	var evidence *spiffesdk.AttestationEvidence
+	if header != "" {
+		data, err := base64.RawURLEncoding.DecodeString(header)
+		if err == nil {
+			err = json.Unmarshal(data, &evidence)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s header: %w", spiffesdk.AttestationHeader, err)
+		}
+	}
+	attested, err := s.attest(evidence)
+	if err != nil {
+		return err
+	}
+
+	// Unknown workloads are left to the handler's 404
+	if workload, ok := s.workload(workloadID); ok {
+		return checkClaimedSelectors(workload.Selectors, attested)
+	}
+	return nil
+}
+
+// checkClaimedSelectors rejects namespace and service account selectors the
+// evidence doesn't prove. Pod labels can't be checked without the pod.
+func checkClaimedSelectors(claimed, attested []string) error {
+	for _, selector := range claimed {
+		if !strings.HasPrefix(selector, "k8s:ns:") && !strings.HasPrefix(selector, "k8s:sa:") {
+			continue
+		}
+		if !containsSelector(attested, selector) {
+			return fmt.Errorf("selector %s is not attested", selector)
+		}
+	}
+	return nil
+}
+
+func containsSelector(selectors []string, selector string) bool {
+	for _, s := range selectors {
+		if s == selector {
+			return true
+		}
+	}
+	return false
+}
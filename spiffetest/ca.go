@@ -0,0 +1,247 @@
+// Package spiffetest provides in-memory SPIFFE identities for unit tests of
+// code built on the SDK: a CA per trust domain that mints X.509-SVIDs and
+// JWT-SVIDs, SDKs wired to those identities, and mTLS server and client
+// helpers so in-process services can authenticate each other.
+//
+//	ca := spiffetest.NewCA(t, "authsec.dev")
+//	server := ca.NewSDK(t, "spiffe://authsec.dev/payment-service")
+//	client := ca.NewSDK(t, "spiffe://authsec.dev/customer-service")
+//
+//	srv := spiffetest.NewServer(t, server, handler, spiffesdk.WithIncomingValidation())
+//	resp, err := client.GetHTTPClient().Get(srv.URL + "/process")
+package spiffetest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/spiffe/go-spiffe/v2/bundle/jwtbundle"
+	"github.com/spiffe/go-spiffe/v2/bundle/spiffebundle"
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+// DefaultTTL is the lifetime of SVIDs minted without an explicit TTL
+const DefaultTTL = time.Hour
+
+// CA is an in-memory certificate authority and JWT signer for one trust domain
+type CA struct {
+	tb          testing.TB
+	trustDomain spiffeid.TrustDomain
+	cert        *x509.Certificate
+	key         *ecdsa.PrivateKey
+	jwtKey      *ecdsa.PrivateKey
+	jwtKeyID    string
+}
+
+// NewCA creates a CA for trustDomain (e.g. "authsec.dev") valid for a day
+func NewCA(tb testing.TB, trustDomain string) *CA {
+	tb.Helper()
+
+	td, err := spiffeid.TrustDomainFromString(trustDomain)
+	if err != nil {
+		tb.Fatalf("spiffetest: invalid trust domain: %v", err)
+	}
+
+	key := generateKey(tb)
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          newSerial(tb),
+		Subject:               pkix.Name{Organization: []string{"spiffetest"}, CommonName: td.String() + " CA"},
+		URIs:                  []*url.URL{td.ID().URL()},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	cert := createCertificate(tb, template, template, &key.PublicKey, key)
+
+	keyID := make([]byte, 8)
+	if _, err := rand.Read(keyID); err != nil {
+		tb.Fatalf("spiffetest: %v", err)
+	}
+
+	return &CA{
+		tb:          tb,
+		trustDomain: td,
+		cert:        cert,
+		key:         key,
+		jwtKey:      generateKey(tb),
+		jwtKeyID:    hex.EncodeToString(keyID),
+	}
+}
+
+// TrustDomain returns the CA's trust domain
+func (ca *CA) TrustDomain() spiffeid.TrustDomain {
+	return ca.trustDomain
+}
+
+// Certificate returns the CA's root certificate
+func (ca *CA) Certificate() *x509.Certificate {
+	return ca.cert
+}
+
+// X509Bundle returns the X.509 bundle containing the CA certificate
+func (ca *CA) X509Bundle() *x509bundle.Bundle {
+	return x509bundle.FromX509Authorities(ca.trustDomain, []*x509.Certificate{ca.cert})
+}
+
+// JWTBundle returns the JWT bundle containing the CA's signing key
+func (ca *CA) JWTBundle() *jwtbundle.Bundle {
+	return jwtbundle.FromJWTAuthorities(ca.trustDomain, map[string]crypto.PublicKey{
+		ca.jwtKeyID: ca.jwtKey.Public(),
+	})
+}
+
+// Bundle returns the SPIFFE bundle with both X.509 and JWT authorities
+func (ca *CA) Bundle() *spiffebundle.Bundle {
+	bundle := spiffebundle.FromX509Authorities(ca.trustDomain, []*x509.Certificate{ca.cert})
+	bundle.SetJWTAuthorities(ca.JWTBundle().JWTAuthorities())
+	return bundle
+}
+
+// CreateX509SVID mints an X.509-SVID for id, which must belong to the CA's
+// trust domain. A zero ttl uses DefaultTTL; a negative ttl creates an SVID
+// that has already expired.
+func (ca *CA) CreateX509SVID(id string, ttl time.Duration) *x509svid.SVID {
+	ca.tb.Helper()
+
+	spiffeID := ca.requireID(id)
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+
+	key := generateKey(ca.tb)
+	now := time.Now()
+	notBefore, notAfter := now.Add(-time.Minute), now.Add(ttl)
+	if ttl < 0 {
+		notBefore = notAfter.Add(-time.Hour)
+	}
+	template := &x509.Certificate{
+		SerialNumber: newSerial(ca.tb),
+		Subject:      pkix.Name{Organization: []string{"spiffetest"}},
+		URIs:         []*url.URL{spiffeID.URL()},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageKeyAgreement,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	cert := createCertificate(ca.tb, template, ca.cert, &key.PublicKey, ca.key)
+
+	return &x509svid.SVID{
+		ID:           spiffeID,
+		Certificates: []*x509.Certificate{cert},
+		PrivateKey:   key,
+	}
+}
+
+// CreateJWTSVID mints a JWT-SVID for id and audience. A zero ttl uses
+// DefaultTTL. Use CreateJWTToken for tokens that have already expired.
+func (ca *CA) CreateJWTSVID(id string, audience []string, ttl time.Duration) *jwtsvid.SVID {
+	ca.tb.Helper()
+
+	if ttl < 0 {
+		ca.tb.Fatalf("spiffetest: CreateJWTSVID cannot create expired JWT-SVIDs, use CreateJWTToken")
+	}
+	svid, err := jwtsvid.ParseInsecure(ca.CreateJWTToken(id, audience, ttl), audience)
+	if err != nil {
+		ca.tb.Fatalf("spiffetest: %v", err)
+	}
+	return svid
+}
+
+// CreateJWTToken mints a signed JWT-SVID token for id and audience. A zero ttl
+// uses DefaultTTL; a negative ttl creates a token that has already expired.
+func (ca *CA) CreateJWTToken(id string, audience []string, ttl time.Duration) string {
+	ca.tb.Helper()
+
+	spiffeID := ca.requireID(id)
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.ES256,
+		Key:       jose.JSONWebKey{Key: ca.jwtKey, KeyID: ca.jwtKeyID},
+	}, new(jose.SignerOptions).WithType("JWT"))
+	if err != nil {
+		ca.tb.Fatalf("spiffetest: %v", err)
+	}
+
+	now := time.Now()
+	issuedAt := now
+	if ttl < 0 {
+		issuedAt = now.Add(ttl - time.Hour)
+	}
+	token, err := jwt.Signed(signer).Claims(jwt.Claims{
+		Subject:  spiffeID.String(),
+		Audience: audience,
+		IssuedAt: jwt.NewNumericDate(issuedAt),
+		Expiry:   jwt.NewNumericDate(now.Add(ttl)),
+	}).CompactSerialize()
+	if err != nil {
+		ca.tb.Fatalf("spiffetest: failed to sign JWT-SVID: %v", err)
+	}
+	return token
+}
+
+func (ca *CA) requireID(id string) spiffeid.ID {
+	ca.tb.Helper()
+
+	spiffeID, err := spiffeid.FromString(id)
+	if err != nil {
+		ca.tb.Fatalf("spiffetest: invalid SPIFFE ID: %v", err)
+	}
+	if spiffeID.TrustDomain() != ca.trustDomain {
+		ca.tb.Fatalf("spiffetest: %s is not in trust domain %s", spiffeID, ca.trustDomain)
+	}
+	return spiffeID
+}
+
+func generateKey(tb testing.TB) *ecdsa.PrivateKey {
+	tb.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatalf("spiffetest: failed to generate key: %v", err)
+	}
+	return key
+}
+
+func newSerial(tb testing.TB) *big.Int {
+	tb.Helper()
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		tb.Fatalf("spiffetest: %v", err)
+	}
+	return serial
+}
+
+func createCertificate(tb testing.TB, template, parent *x509.Certificate, pub crypto.PublicKey, key crypto.Signer) *x509.Certificate {
+	tb.Helper()
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, key)
+	if err != nil {
+		tb.Fatalf("spiffetest: failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		tb.Fatalf("spiffetest: %v", err)
+	}
+	return cert
+}
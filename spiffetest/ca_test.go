@@ -0,0 +1,107 @@
+package spiffetest_test
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	spiffesdk "github.com/authsec-ai/spiffe-sdk"
+	"github.com/authsec-ai/spiffe-sdk/spiffetest"
+	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+const (
+	paymentID  = "spiffe://authsec.dev/payment-service"
+	customerID = "spiffe://authsec.dev/customer-service"
+)
+
+// callerHandler echoes the caller's SPIFFE ID
+func callerHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := spiffesdk.SPIFFEIDFromContext(r.Context())
+		_, _ = io.WriteString(w, id)
+	})
+}
+
+func TestStaticSDKMTLS(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	server := ca.NewSDK(t, paymentID)
+	client := ca.NewSDK(t, customerID)
+	srv := spiffetest.NewServer(t, server, callerHandler(), spiffesdk.WithIncomingValidation())
+
+	for name, httpClient := range map[string]*http.Client{
+		"GetHTTPClient": client.GetHTTPClient(),
+		"NewClient":     spiffetest.NewClient(client, paymentID),
+	} {
+		resp, err := httpClient.Get(srv.URL + "/process")
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", name, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != customerID {
+			t.Errorf("%s: got %d %q, want 200 %q", name, resp.StatusCode, body, customerID)
+		}
+		if len(resp.TLS.PeerCertificates) == 0 || resp.TLS.PeerCertificates[0].URIs[0].String() != paymentID {
+			t.Errorf("%s: server did not present %s", name, paymentID)
+		}
+	}
+
+	// NewClient pins the server's identity
+	if _, err := spiffetest.NewClient(client, customerID).Get(srv.URL); err == nil {
+		t.Error("NewClient accepted a server with another SPIFFE ID")
+	}
+}
+
+func TestStaticSDKRejectsUntrustedPeers(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	srv := spiffetest.NewServer(t, ca.NewSDK(t, paymentID), callerHandler(), spiffesdk.WithIncomingValidation())
+
+	// Each client trusts the server but presents an SVID the server must refuse
+	svids := map[string]*x509svid.SVID{
+		"other CA":  spiffetest.NewCA(t, "authsec.dev").CreateX509SVID(customerID, 0),
+		"expired":   ca.CreateX509SVID(customerID, -time.Minute),
+		"federated": spiffetest.NewCA(t, "partner.example").CreateX509SVID("spiffe://partner.example/billing", 0),
+	}
+	for name, svid := range svids {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: tlsconfig.MTLSClientConfig(svid, ca.X509Bundle(), tlsconfig.AuthorizeAny()),
+		}}
+		if resp, err := client.Get(srv.URL); err == nil {
+			resp.Body.Close()
+			t.Errorf("%s: client accepted by a server that doesn't trust it", name)
+		}
+	}
+
+	// The server accepts the partner once it trusts the partner's CA
+	partner := spiffetest.NewCA(t, "partner.example")
+	federated := spiffetest.NewServer(t, ca.NewSDK(t, paymentID, partner), callerHandler(), spiffesdk.WithIncomingValidation())
+	resp, err := partner.NewSDK(t, "spiffe://partner.example/billing", ca).GetHTTPClient().Get(federated.URL)
+	if err != nil {
+		t.Fatalf("federated client rejected: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestCAJWTSVID(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	server := ca.NewSDK(t, paymentID)
+
+	svid, err := server.ValidateJWTSVID(ca.CreateJWTToken(customerID, []string{"payments"}, 0), "payments")
+	if err != nil {
+		t.Fatalf("ValidateJWTSVID() error = %v", err)
+	}
+	if svid.ID.String() != customerID {
+		t.Errorf("JWT-SVID is for %s, want %s", svid.ID, customerID)
+	}
+
+	if _, err := server.ValidateJWTSVID(ca.CreateJWTToken(customerID, []string{"orders"}, 0), "payments"); err == nil {
+		t.Error("JWT-SVID for another audience accepted")
+	}
+	other := spiffetest.NewCA(t, "authsec.dev")
+	if _, err := server.ValidateJWTSVID(other.CreateJWTToken(customerID, []string{"payments"}, 0), "payments"); err == nil {
+		t.Error("JWT-SVID signed by an untrusted key accepted")
+	}
+}
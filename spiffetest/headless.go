@@ -0,0 +1,583 @@
+package spiffetest
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	spiffesdk "github.com/authsec-ai/spiffe-sdk"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+// Endpoint identifies an endpoint of the fake headless API for fault
+// injection and request inspection
+type Endpoint string
+
+// Endpoints served by HeadlessServer
+const (
+	EndpointRegister       Endpoint = "register-and-issue"
+	EndpointListWorkloads  Endpoint = "list-workloads"
+	EndpointGetWorkload    Endpoint = "get-workload"
+	EndpointDeleteWorkload Endpoint = "delete-workload"
+	EndpointIssueSVID      Endpoint = "issue-svid"
+	EndpointIssueJWTSVID   Endpoint = "issue-jwt-svid"
+	EndpointJWTBundle      Endpoint = "jwt-bundle"
+	EndpointX509Bundle     Endpoint = "x509-bundle"
+	EndpointDenyList       Endpoint = "deny-list"
+	EndpointVerify         Endpoint = "verify-certificate"
+)
+
+// Fault makes an endpoint misbehave. The zero value of each field disables it.
+type Fault struct {
+	// Latency delays the response (or until the client gives up)
+	Latency time.Duration
+
+	// StatusCode replaces the response with an error status
+	StatusCode int
+
+	// MalformedJSON replaces the response body with invalid JSON
+	MalformedJSON bool
+
+	// ExpiredSVID makes the SVID endpoint issue certificates that have
+	// already expired
+	ExpiredSVID bool
+
+	// Times limits the fault to the next n requests; 0 applies it until
+	// ClearFaults is called
+	Times int
+}
+
+// RecordedRequest is a request received by HeadlessServer
+type RecordedRequest struct {
+	Endpoint Endpoint
+	Method   string
+	Path     string
+	Query    string
+	Header   http.Header
+	Body     []byte
+	Time     time.Time
+}
+
+// HeadlessServer is an in-memory fake of the headless SPIRE API backed by a
+// CA. Use its URL as Config.HeadlessAPIURL. It is closed when the test ends.
+type HeadlessServer struct {
+	// URL is the base URL of the fake API
+	URL string
+
+	// SVIDTTL is the lifetime of issued X.509-SVIDs (default DefaultTTL). Requests
+	// may ask for a shorter one.
+	SVIDTTL time.Duration
+
+	// JWTSVIDTTL is the lifetime of issued JWT-SVIDs (default 5 minutes)
+	JWTSVIDTTL time.Duration
+
+	// RequireAttestation rejects every request without valid evidence, i.e. a
+	// token from ServiceAccountToken for Audience (default
+	// DefaultAttestationAudience) that proves the workload's namespace and
+	// service account selectors. Evidence that is sent is always checked.
+	RequireAttestation bool
+	Audience           string
+
+	ca       *CA
+	server   *httptest.Server
+	tokenKey *ecdsa.PrivateKey // Signs service account tokens
+
+	bundleCAs []*CA // Published X.509 authorities; just ca if empty
+	denyList  spiffesdk.DenyList
+
+	mu        sync.Mutex
+	workloads []spiffesdk.Workload
+	nextID    int
+	faults    map[Endpoint][]*Fault
+	requests  []RecordedRequest
+}
+
+// NewHeadlessServer starts a fake headless API that issues SVIDs from ca
+func NewHeadlessServer(tb testing.TB, ca *CA) *HeadlessServer {
+	tb.Helper()
+
+	s := &HeadlessServer{
+		SVIDTTL:    DefaultTTL,
+		JWTSVIDTTL: 5 * time.Minute,
+		ca:         ca,
+		tokenKey:   generateKey(tb),
+		faults:     make(map[Endpoint][]*Fault),
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.server.URL
+	tb.Cleanup(s.Close)
+	return s
+}
+
+// Close shuts the server down
+func (s *HeadlessServer) Close() {
+	s.server.Close()
+}
+
+// SetBundleCAs sets the authorities published in the X.509 bundle, both by
+// the bundle endpoint and with issued SVIDs, e.g. the outgoing and incoming CA
+// during a rotation. SVIDs are still issued by the server's own CA.
+func (s *HeadlessServer) SetBundleCAs(cas ...*CA) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bundleCAs = append([]*CA(nil), cas...)
+}
+
+// SetDenyList sets the deny-list served to SDKs with DenyListFromHeadlessAPI
+func (s *HeadlessServer) SetDenyList(list spiffesdk.DenyList) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.denyList = list
+}
+
+// x509BundlePEM returns the published X.509 authorities
+func (s *HeadlessServer) x509BundlePEM() ([]byte, error) {
+	s.mu.Lock()
+	cas := s.bundleCAs
+	s.mu.Unlock()
+	if len(cas) == 0 {
+		cas = []*CA{s.ca}
+	}
+
+	var bundlePEM []byte
+	for _, ca := range cas {
+		data, err := ca.X509Bundle().Marshal()
+		if err != nil {
+			return nil, err
+		}
+		bundlePEM = append(bundlePEM, data...)
+	}
+	return bundlePEM, nil
+}
+
+// Register adds a workload as if it had been registered through the API and
+// returns its ID
+func (s *HeadlessServer) Register(spiffeID string, selectors ...string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.registerLocked(spiffesdk.Workload{SPIFFEID: spiffeID, Type: "application", Selectors: selectors})
+}
+
+// Workloads returns the registered workloads
+func (s *HeadlessServer) Workloads() []spiffesdk.Workload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]spiffesdk.Workload(nil), s.workloads...)
+}
+
+// InjectFault makes endpoint misbehave until the fault is used up or cleared.
+// Faults for the same endpoint apply in the order they were injected.
+func (s *HeadlessServer) InjectFault(endpoint Endpoint, fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults[endpoint] = append(s.faults[endpoint], &fault)
+}
+
+// ClearFaults removes all injected faults
+func (s *HeadlessServer) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = make(map[Endpoint][]*Fault)
+}
+
+// Requests returns the requests received so far, optionally only those for
+// the given endpoints
+func (s *HeadlessServer) Requests(endpoints ...Endpoint) []RecordedRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var requests []RecordedRequest
+	for _, req := range s.requests {
+		if len(endpoints) == 0 || containsEndpoint(endpoints, req.Endpoint) {
+			requests = append(requests, req)
+		}
+	}
+	return requests
+}
+
+func (s *HeadlessServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	endpoint, workloadID := route(r)
+	body, _ := io.ReadAll(r.Body)
+
+	s.mu.Lock()
+	s.requests = append(s.requests, RecordedRequest{
+		Endpoint: endpoint,
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		Query:    r.URL.RawQuery,
+		Header:   r.Header.Clone(),
+		Body:     body,
+		Time:     time.Now(),
+	})
+	fault := s.takeFaultLocked(endpoint)
+	s.mu.Unlock()
+
+	if endpoint == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if fault.Latency > 0 {
+		select {
+		case <-time.After(fault.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if fault.StatusCode != 0 {
+		http.Error(w, "injected fault", fault.StatusCode)
+		return
+	}
+	if fault.MalformedJSON {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(successStatus(endpoint))
+		fmt.Fprint(w, `{"workloads": [{"id": `)
+		return
+	}
+
+	// Registrations are attested by the evidence in their body
+	if endpoint != EndpointRegister {
+		if err := s.attestRequest(r, workloadID); err != nil {
+			http.Error(w, "attestation failed: "+err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	switch endpoint {
+	case EndpointRegister:
+		s.handleRegister(w, body)
+	case EndpointListWorkloads:
+		s.handleList(w, r.URL.Query().Get("spiffe_id"))
+	case EndpointGetWorkload:
+		s.handleGet(w, workloadID)
+	case EndpointDeleteWorkload:
+		s.handleDelete(w, workloadID)
+	case EndpointIssueSVID:
+		s.handleIssueSVID(w, workloadID, body, fault.ExpiredSVID)
+	case EndpointIssueJWTSVID:
+		s.handleIssueJWTSVID(w, workloadID, body)
+	case EndpointJWTBundle:
+		s.handleJWTBundle(w)
+	case EndpointX509Bundle:
+		s.handleX509Bundle(w)
+	case EndpointDenyList:
+		s.handleDenyList(w)
+	case EndpointVerify:
+		s.handleVerify(w, body)
+	}
+}
+
+// route maps a request to an endpoint and, where applicable, a workload ID
+func route(r *http.Request) (Endpoint, string) {
+	const workloads = "/spiresvc/api/v1/workloads"
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == workloads+"/register-and-issue":
+		return EndpointRegister, ""
+	case r.Method == http.MethodGet && r.URL.Path == workloads:
+		return EndpointListWorkloads, ""
+	case r.Method == http.MethodGet && r.URL.Path == "/spiresvc/api/v1/bundles/jwt":
+		return EndpointJWTBundle, ""
+	case r.Method == http.MethodGet && r.URL.Path == "/spiresvc/api/v1/bundles/x509":
+		return EndpointX509Bundle, ""
+	case r.Method == http.MethodGet && r.URL.Path == "/spiresvc/api/v1/denylist":
+		return EndpointDenyList, ""
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/verify/certificate":
+		return EndpointVerify, ""
+	}
+
+	rest, ok := strings.CutPrefix(r.URL.Path, workloads+"/")
+	if !ok || rest == "" {
+		return "", ""
+	}
+	id, action, _ := strings.Cut(rest, "/")
+	switch {
+	case r.Method == http.MethodGet && action == "":
+		return EndpointGetWorkload, id
+	case r.Method == http.MethodDelete && action == "":
+		return EndpointDeleteWorkload, id
+	case r.Method == http.MethodPost && action == "svid":
+		return EndpointIssueSVID, id
+	case r.Method == http.MethodPost && action == "jwt-svid":
+		return EndpointIssueJWTSVID, id
+	}
+	return "", ""
+}
+
+func (s *HeadlessServer) handleRegister(w http.ResponseWriter, body []byte) {
+	var req struct {
+		spiffesdk.Workload
+		Attestation     *spiffesdk.AttestationEvidence `json:"attestation"`
+		DeriveSelectors bool                           `json:"derive_selectors"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || req.SPIFFEID == "" {
+		http.Error(w, "invalid registration request", http.StatusBadRequest)
+		return
+	}
+	if !strings.HasPrefix(req.SPIFFEID, s.ca.TrustDomain().IDString()+"/") {
+		http.Error(w, "SPIFFE ID is not in the trust domain", http.StatusBadRequest)
+		return
+	}
+
+	if req.Attestation != nil || req.DeriveSelectors || s.RequireAttestation {
+		attested, err := s.attest(req.Attestation)
+		if err == nil && !req.DeriveSelectors {
+			err = checkClaimedSelectors(req.Selectors, attested)
+		}
+		if err != nil {
+			http.Error(w, "attestation failed: "+err.Error(), http.StatusForbidden)
+			return
+		}
+		if req.DeriveSelectors {
+			req.Selectors = attested
+		}
+	}
+
+	s.mu.Lock()
+	id := s.registerLocked(req.Workload)
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, map[string]string{"id": id, "spiffe_id": req.SPIFFEID})
+}
+
+func (s *HeadlessServer) handleList(w http.ResponseWriter, spiffeID string) {
+	workloads := []spiffesdk.Workload{}
+	for _, workload := range s.Workloads() {
+		if spiffeID == "" || workload.SPIFFEID == spiffeID {
+			workloads = append(workloads, workload)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"workloads": workloads})
+}
+
+func (s *HeadlessServer) handleGet(w http.ResponseWriter, workloadID string) {
+	workload, ok := s.workload(workloadID)
+	if !ok {
+		http.Error(w, "workload not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, workload)
+}
+
+func (s *HeadlessServer) handleDelete(w http.ResponseWriter, workloadID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, workload := range s.workloads {
+		if workload.ID == workloadID {
+			s.workloads = append(s.workloads[:i], s.workloads[i+1:]...)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	http.Error(w, "workload not found", http.StatusNotFound)
+}
+
+func (s *HeadlessServer) handleIssueSVID(w http.ResponseWriter, workloadID string, body []byte, expired bool) {
+	workload, ok := s.workload(workloadID)
+	if !ok {
+		http.Error(w, "workload not found", http.StatusNotFound)
+		return
+	}
+
+	// The request body is optional and may ask for a shorter lifetime
+	var req struct {
+		TTL int64 `json:"ttl"`
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil || req.TTL < 0 {
+			http.Error(w, "invalid ttl", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ttl := s.SVIDTTL
+	if requested := time.Duration(req.TTL) * time.Second; requested > 0 && requested < ttl {
+		ttl = requested
+	}
+	if expired {
+		ttl = -time.Minute
+	}
+	svid := s.ca.CreateX509SVID(workload.SPIFFEID, ttl)
+	certPEM, keyPEM, err := svid.Marshal()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	bundlePEM, err := s.x509BundlePEM()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	cert := svid.Certificates[0]
+	writeJSON(w, http.StatusCreated, spiffesdk.SVIDResponse{
+		ID:         cert.SerialNumber.String(),
+		WorkloadID: workload.ID,
+		SPIFFEID:   workload.SPIFFEID,
+		X509SVID:   string(certPEM),
+		PrivateKey: string(keyPEM),
+		Bundle:     string(bundlePEM),
+		ExpiresAt:  cert.NotAfter,
+		IssuedAt:   cert.NotBefore,
+	})
+}
+
+func (s *HeadlessServer) handleIssueJWTSVID(w http.ResponseWriter, workloadID string, body []byte) {
+	workload, ok := s.workload(workloadID)
+	if !ok {
+		http.Error(w, "workload not found", http.StatusNotFound)
+		return
+	}
+
+	var req struct {
+		Audience []string `json:"audience"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || len(req.Audience) == 0 {
+		http.Error(w, "audience is required", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, spiffesdk.JWTSVIDResponse{
+		SPIFFEID:  workload.SPIFFEID,
+		Token:     s.ca.CreateJWTToken(workload.SPIFFEID, req.Audience, s.JWTSVIDTTL),
+		ExpiresAt: time.Now().Add(s.JWTSVIDTTL),
+	})
+}
+
+func (s *HeadlessServer) handleJWTBundle(w http.ResponseWriter) {
+	data, err := s.ca.JWTBundle().Marshal()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+func (s *HeadlessServer) handleX509Bundle(w http.ResponseWriter) {
+	data, err := s.x509BundlePEM()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	_, _ = w.Write(data)
+}
+
+func (s *HeadlessServer) handleDenyList(w http.ResponseWriter) {
+	s.mu.Lock()
+	list := s.denyList
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *HeadlessServer) handleVerify(w http.ResponseWriter, body []byte) {
+	var req struct {
+		Certificate string `json:"certificate"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "invalid verification request", http.StatusBadRequest)
+		return
+	}
+
+	block, _ := pem.Decode([]byte(req.Certificate))
+	if block == nil {
+		writeJSON(w, http.StatusOK, spiffesdk.ValidationResult{})
+		return
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		writeJSON(w, http.StatusOK, spiffesdk.ValidationResult{})
+		return
+	}
+
+	result := spiffesdk.ValidationResult{
+		Subject:   cert.Subject.String(),
+		Issuer:    cert.Issuer.String(),
+		NotBefore: cert.NotBefore.Format(time.RFC3339),
+		NotAfter:  cert.NotAfter.Format(time.RFC3339),
+	}
+	if id, _, err := x509svid.Verify([]*x509.Certificate{cert}, s.ca.X509Bundle()); err == nil {
+		result.Valid = true
+		result.SPIFFEID = id.String()
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *HeadlessServer) registerLocked(workload spiffesdk.Workload) string {
+	for i, existing := range s.workloads {
+		if existing.SPIFFEID == workload.SPIFFEID {
+			workload.ID = existing.ID
+			s.workloads[i] = workload
+			return workload.ID
+		}
+	}
+
+	s.nextID++
+	workload.ID = fmt.Sprintf("workload-%d", s.nextID)
+	s.workloads = append(s.workloads, workload)
+	return workload.ID
+}
+
+func (s *HeadlessServer) workload(workloadID string) (spiffesdk.Workload, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, workload := range s.workloads {
+		if workload.ID == workloadID {
+			return workload, true
+		}
+	}
+	return spiffesdk.Workload{}, false
+}
+
+// takeFaultLocked returns the current fault for endpoint and uses up one of
+// its occurrences
+func (s *HeadlessServer) takeFaultLocked(endpoint Endpoint) Fault {
+	faults := s.faults[endpoint]
+	if len(faults) == 0 {
+		return Fault{}
+	}
+
+	fault := faults[0]
+	if fault.Times > 0 {
+		fault.Times--
+		if fault.Times == 0 {
+			s.faults[endpoint] = faults[1:]
+		}
+	}
+	return *fault
+}
+
+func successStatus(endpoint Endpoint) int {
+	switch endpoint {
+	case EndpointRegister, EndpointIssueSVID, EndpointIssueJWTSVID:
+		return http.StatusCreated
+	}
+	return http.StatusOK
+}
+
+func containsEndpoint(endpoints []Endpoint, endpoint Endpoint) bool {
+	for _, e := range endpoints {
+		if e == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,130 @@
+package spiffetest_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	spiffesdk "github.com/authsec-ai/spiffe-sdk"
+	"github.com/authsec-ai/spiffe-sdk/spiffetest"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+func headlessConfig(headless *spiffetest.HeadlessServer) *spiffesdk.Config {
+	return &spiffesdk.Config{
+		SPIFFEID:       paymentID,
+		ServiceType:    "application",
+		Namespace:      "payments",
+		ServiceAccount: "payments",
+		HeadlessAPIURL: headless.URL,
+	}
+}
+
+func TestHeadlessSourceRoundTrip(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	headless := spiffetest.NewHeadlessServer(t, ca)
+
+	config := headlessConfig(headless)
+	config.RenewalThreshold = 2 * spiffetest.DefaultTTL // Renew on every check
+	config.CheckInterval = 20 * time.Millisecond
+	source, err := spiffesdk.NewHeadlessSource(config)
+	if err != nil {
+		t.Fatalf("NewHeadlessSource() error = %v", err)
+	}
+	t.Cleanup(func() { _ = source.Close() })
+
+	svid, err := source.GetX509SVID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svid.ID.String() != paymentID {
+		t.Errorf("SVID is for %s, want %s", svid.ID, paymentID)
+	}
+	if _, _, err := x509svid.Verify(svid.Certificates, ca.X509Bundle()); err != nil {
+		t.Errorf("issued SVID does not chain to the CA: %v", err)
+	}
+
+	workloads := headless.Workloads()
+	if len(workloads) != 1 || workloads[0].SPIFFEID != paymentID {
+		t.Fatalf("registered workloads = %+v, want one for %s", workloads, paymentID)
+	}
+	if got, want := workloads[0].Selectors, []string{"k8s:ns:payments", "k8s:sa:payments"}; len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("registered selectors = %v, want %v", got, want)
+	}
+	for _, endpoint := range []spiffetest.Endpoint{spiffetest.EndpointRegister, spiffetest.EndpointIssueSVID} {
+		if len(headless.Requests(endpoint)) == 0 {
+			t.Errorf("no %s request recorded", endpoint)
+		}
+	}
+
+	// Renewal issues a new SVID from the fake
+	select {
+	case <-source.Updated():
+	case <-time.After(5 * time.Second):
+		t.Fatal("SVID was not renewed")
+	}
+	renewed, err := source.GetX509SVID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed.Certificates[0].SerialNumber.Cmp(svid.Certificates[0].SerialNumber) == 0 {
+		t.Error("renewal returned the same SVID")
+	}
+
+	// The issued identity works for mTLS with peers of the same CA
+	server, err := spiffesdk.NewSpiffeSDKWithSource(nil, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = server.Close(context.Background()) })
+	srv := spiffetest.NewServer(t, server, callerHandler(), spiffesdk.WithIncomingValidation())
+
+	resp, err := spiffetest.NewClient(ca.NewSDK(t, customerID), paymentID).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("request to the headless-backed server failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != customerID {
+		t.Errorf("got %d %q, want 200 %q", resp.StatusCode, body, customerID)
+	}
+}
+
+func TestHeadlessServerFaults(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	headless := spiffetest.NewHeadlessServer(t, ca)
+	config := headlessConfig(headless)
+
+	headless.InjectFault(spiffetest.EndpointIssueSVID, spiffetest.Fault{StatusCode: http.StatusServiceUnavailable, Times: 1})
+	if _, err := spiffesdk.NewHeadlessSource(config); err == nil {
+		t.Fatal("NewHeadlessSource() succeeded despite a 503 from the SVID endpoint")
+	}
+
+	// The fault was used up
+	source, err := spiffesdk.NewHeadlessSource(config)
+	if err != nil {
+		t.Fatalf("NewHeadlessSource() error = %v after the fault", err)
+	}
+	_ = source.Close()
+
+	headless.InjectFault(spiffetest.EndpointListWorkloads, spiffetest.Fault{MalformedJSON: true})
+	api := &spiffesdk.HeadlessAPI{BaseURL: headless.URL, HTTPClient: http.DefaultClient}
+	if _, err := api.ListWorkloads(paymentID); err == nil {
+		t.Error("ListWorkloads() accepted malformed JSON")
+	}
+	headless.ClearFaults()
+
+	headless.InjectFault(spiffetest.EndpointIssueSVID, spiffetest.Fault{ExpiredSVID: true})
+	resp, err := api.GetOrRefreshSVID(paymentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.ExpiresAt.Before(time.Now()) {
+		t.Errorf("SVID with ExpiredSVID fault expires at %v", resp.ExpiresAt)
+	}
+}
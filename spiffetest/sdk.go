@@ -0,0 +1,100 @@
+package spiffetest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	spiffesdk "github.com/authsec-ai/spiffe-sdk"
+	"github.com/spiffe/go-spiffe/v2/bundle/spiffebundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+// NewSDK returns a static SDK holding a fresh SVID for id that trusts this CA
+// and the federated CAs. It never contacts SPIRE and is closed when the test
+// ends. Without a headless API, IncomingValidationMiddleware verifies client
+// certificates locally against the same bundles.
+func (ca *CA) NewSDK(tb testing.TB, id string, federated ...*CA) *spiffesdk.SpiffeSDK {
+	tb.Helper()
+	return NewSDKWithSVID(tb, ca.CreateX509SVID(id, 0), append([]*CA{ca}, federated...)...)
+}
+
+// NewSDKWithSVID returns a static SDK presenting svid (e.g. a short-lived one
+// from CreateX509SVID) that trusts the given CAs. The SDK verifies svid like
+// any new SVID, so it must be valid now and chain to one of the CAs.
+func NewSDKWithSVID(tb testing.TB, svid *x509svid.SVID, trusted ...*CA) *spiffesdk.SpiffeSDK {
+	tb.Helper()
+
+	bundles := spiffebundle.NewSet()
+	for _, ca := range trusted {
+		bundles.Add(ca.Bundle())
+	}
+
+	sdk, err := spiffesdk.NewStaticSDK(nil, svid, bundles)
+	if err != nil {
+		tb.Fatalf("spiffetest: %v", err)
+	}
+	tb.Cleanup(func() {
+		_ = sdk.Close(context.Background())
+	})
+	return sdk
+}
+
+// Server is an in-process mTLS server backed by an SDK
+type Server struct {
+	// URL is the base URL of the server, e.g. https://127.0.0.1:40123
+	URL string
+
+	server *spiffesdk.Server
+}
+
+// NewServer starts an mTLS server for handler on a random local port using the
+// same TLS setup and options as sdk.NewServer (health probes are not started).
+// It is shut down when the test ends.
+func NewServer(tb testing.TB, sdk *spiffesdk.SpiffeSDK, handler http.Handler, opts ...spiffesdk.ServerOption) *Server {
+	tb.Helper()
+
+	srv, err := sdk.NewServer("127.0.0.1:0", handler, opts...)
+	if err != nil {
+		tb.Fatalf("spiffetest: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("spiffetest: %v", err)
+	}
+
+	go func() {
+		// Certificates come from the TLS config callbacks, not from files
+		err := srv.HTTPServer().ServeTLS(listener, "", "")
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			tb.Errorf("spiffetest: server failed: %v", err)
+		}
+	}()
+
+	s := &Server{URL: "https://" + listener.Addr().String(), server: srv}
+	tb.Cleanup(s.Close)
+	return s
+}
+
+// Close shuts the server down
+func (s *Server) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = s.server.Shutdown(ctx)
+}
+
+// NewClient returns an HTTP client that presents sdk's SVID and only accepts
+// servers presenting serverID
+func NewClient(sdk *spiffesdk.SpiffeSDK, serverID string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: tlsconfig.MTLSClientConfig(sdk, sdk, tlsconfig.AuthorizeID(spiffeid.RequireFromString(serverID))),
+		},
+		Timeout: 10 * time.Second,
+	}
+}
@@ -0,0 +1,395 @@
+package spiffetest
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/jwtbundle"
+	"github.com/spiffe/go-spiffe/v2/proto/spiffe/workload"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// WorkloadAPI is a fake SPIRE agent serving the SPIFFE Workload API on a
+// temporary Unix socket. Use its SocketPath as Config.SocketPath.
+//
+// SVIDs and bundles can be replaced at any time and are pushed to connected
+// clients immediately. Stop and Start simulate the agent going away and coming
+// back; SetAttested(false) makes it reject callers like an agent that cannot
+// attest the workload.
+type WorkloadAPI struct {
+	// SocketPath is the path of the Unix socket
+	SocketPath string
+
+	// JWTSVIDTTL is the lifetime of issued JWT-SVIDs (default 5 minutes)
+	JWTSVIDTTL time.Duration
+
+	tb  testing.TB
+	ca  *CA
+	dir string
+
+	mu          sync.Mutex
+	server      *grpc.Server
+	svids       []*x509svid.SVID
+	federated   []*CA
+	attested    bool
+	subscribers map[chan struct{}]struct{}
+}
+
+// NewWorkloadAPI starts a fake Workload API that serves SVIDs for ids, issued
+// by ca. It is stopped when the test ends.
+func NewWorkloadAPI(tb testing.TB, ca *CA, ids ...string) *WorkloadAPI {
+	tb.Helper()
+
+	// Unix socket paths are limited to ~100 bytes, so avoid the long
+	// per-test temporary directories
+	dir, err := os.MkdirTemp("", "spiffetest-")
+	if err != nil {
+		tb.Fatalf("spiffetest: %v", err)
+	}
+
+	w := &WorkloadAPI{
+		SocketPath:  filepath.Join(dir, "agent.sock"),
+		JWTSVIDTTL:  5 * time.Minute,
+		tb:          tb,
+		ca:          ca,
+		dir:         dir,
+		attested:    true,
+		subscribers: make(map[chan struct{}]struct{}),
+	}
+	for _, id := range ids {
+		w.svids = append(w.svids, ca.CreateX509SVID(id, 0))
+	}
+
+	tb.Cleanup(func() {
+		w.Stop()
+		_ = os.RemoveAll(dir)
+	})
+	w.Start()
+	return w
+}
+
+// Start serves the Workload API on SocketPath. It is a no-op if the server is
+// already running.
+func (w *WorkloadAPI) Start() {
+	w.tb.Helper()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.server != nil {
+		return
+	}
+
+	_ = os.Remove(w.SocketPath)
+	listener, err := net.Listen("unix", w.SocketPath)
+	if err != nil {
+		w.tb.Fatalf("spiffetest: %v", err)
+	}
+
+	server := grpc.NewServer()
+	workload.RegisterSpiffeWorkloadAPIServer(server, &workloadAPIServer{w: w})
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	w.server = server
+}
+
+// Stop shuts the server down and removes the socket, breaking all streams as
+// if the agent had crashed
+func (w *WorkloadAPI) Stop() {
+	w.mu.Lock()
+	server := w.server
+	w.server = nil
+	w.mu.Unlock()
+
+	if server != nil {
+		server.Stop()
+		_ = os.Remove(w.SocketPath)
+	}
+}
+
+// SetX509SVIDs replaces the SVIDs served to callers and pushes them to
+// connected clients. With no SVIDs, callers get PermissionDenied.
+func (w *WorkloadAPI) SetX509SVIDs(svids ...*x509svid.SVID) {
+	w.mu.Lock()
+	w.svids = svids
+	w.mu.Unlock()
+	w.notify()
+}
+
+// RotateX509SVIDs issues fresh SVIDs with ttl for the current identities and
+// pushes them to connected clients
+func (w *WorkloadAPI) RotateX509SVIDs(ttl time.Duration) {
+	w.tb.Helper()
+
+	w.mu.Lock()
+	svids := make([]*x509svid.SVID, 0, len(w.svids))
+	for _, svid := range w.svids {
+		rotated := w.ca.CreateX509SVID(svid.ID.String(), ttl)
+		rotated.Hint = svid.Hint
+		svids = append(svids, rotated)
+	}
+	w.svids = svids
+	w.mu.Unlock()
+	w.notify()
+}
+
+// SetFederatedCAs replaces the federated trust domains whose bundles are
+// served alongside the CA's own bundle
+func (w *WorkloadAPI) SetFederatedCAs(cas ...*CA) {
+	w.mu.Lock()
+	w.federated = cas
+	w.mu.Unlock()
+	w.notify()
+}
+
+// SetAttested controls whether callers are attested. Unattested callers get
+// PermissionDenied and their open streams are closed.
+func (w *WorkloadAPI) SetAttested(attested bool) {
+	w.mu.Lock()
+	w.attested = attested
+	w.mu.Unlock()
+	w.notify()
+}
+
+// notify wakes up every open stream so it sends the current state
+func (w *WorkloadAPI) notify() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for ch := range w.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (w *WorkloadAPI) subscribe() chan struct{} {
+	ch := make(chan struct{}, 1)
+	w.mu.Lock()
+	w.subscribers[ch] = struct{}{}
+	w.mu.Unlock()
+	return ch
+}
+
+func (w *WorkloadAPI) unsubscribe(ch chan struct{}) {
+	w.mu.Lock()
+	delete(w.subscribers, ch)
+	w.mu.Unlock()
+}
+
+// stream sends the response built by current until the client goes away or
+// the caller loses its identity
+func (w *WorkloadAPI) stream(ctx context.Context, current func() (interface{}, error), send func(interface{}) error) error {
+	if err := checkSecurityHeader(ctx); err != nil {
+		return err
+	}
+
+	ch := w.subscribe()
+	defer w.unsubscribe(ch)
+
+	for {
+		resp, err := current()
+		if err != nil {
+			return err
+		}
+		if err := send(resp); err != nil {
+			return err
+		}
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (w *WorkloadAPI) x509SVIDResponse() (interface{}, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.attested || len(w.svids) == 0 {
+		return nil, status.Error(codes.PermissionDenied, "no identity issued")
+	}
+
+	resp := &workload.X509SVIDResponse{
+		FederatedBundles: make(map[string][]byte),
+	}
+	for _, svid := range w.svids {
+		key, err := x509.MarshalPKCS8PrivateKey(svid.PrivateKey)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		resp.Svids = append(resp.Svids, &workload.X509SVID{
+			SpiffeId:    svid.ID.String(),
+			X509Svid:    concatRaw(svid.Certificates),
+			X509SvidKey: key,
+			Bundle:      concatRaw([]*x509.Certificate{w.ca.Certificate()}),
+			Hint:        svid.Hint,
+		})
+	}
+	for _, ca := range w.federated {
+		resp.FederatedBundles[ca.TrustDomain().IDString()] = concatRaw([]*x509.Certificate{ca.Certificate()})
+	}
+	return resp, nil
+}
+
+func (w *WorkloadAPI) x509BundlesResponse() (interface{}, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.attested {
+		return nil, status.Error(codes.PermissionDenied, "no identity issued")
+	}
+
+	resp := &workload.X509BundlesResponse{
+		Bundles: make(map[string][]byte),
+	}
+	for _, ca := range w.trustedCAsLocked() {
+		resp.Bundles[ca.TrustDomain().IDString()] = concatRaw([]*x509.Certificate{ca.Certificate()})
+	}
+	return resp, nil
+}
+
+func (w *WorkloadAPI) jwtBundlesResponse() (interface{}, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.attested {
+		return nil, status.Error(codes.PermissionDenied, "no identity issued")
+	}
+
+	resp := &workload.JWTBundlesResponse{
+		Bundles: make(map[string][]byte),
+	}
+	for _, ca := range w.trustedCAsLocked() {
+		data, err := ca.JWTBundle().Marshal()
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		resp.Bundles[ca.TrustDomain().IDString()] = data
+	}
+	return resp, nil
+}
+
+func (w *WorkloadAPI) trustedCAsLocked() []*CA {
+	return append([]*CA{w.ca}, w.federated...)
+}
+
+func (w *WorkloadAPI) jwtBundles() *jwtbundle.Set {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	set := jwtbundle.NewSet()
+	for _, ca := range w.trustedCAsLocked() {
+		set.Add(ca.JWTBundle())
+	}
+	return set
+}
+
+// workloadAPIServer adapts WorkloadAPI to the generated gRPC service
+type workloadAPIServer struct {
+	workload.UnimplementedSpiffeWorkloadAPIServer
+	w *WorkloadAPI
+}
+
+func (s *workloadAPIServer) FetchX509SVID(_ *workload.X509SVIDRequest, stream workload.SpiffeWorkloadAPI_FetchX509SVIDServer) error {
+	return s.w.stream(stream.Context(), s.w.x509SVIDResponse, func(resp interface{}) error {
+		return stream.Send(resp.(*workload.X509SVIDResponse))
+	})
+}
+
+func (s *workloadAPIServer) FetchX509Bundles(_ *workload.X509BundlesRequest, stream workload.SpiffeWorkloadAPI_FetchX509BundlesServer) error {
+	return s.w.stream(stream.Context(), s.w.x509BundlesResponse, func(resp interface{}) error {
+		return stream.Send(resp.(*workload.X509BundlesResponse))
+	})
+}
+
+func (s *workloadAPIServer) FetchJWTBundles(_ *workload.JWTBundlesRequest, stream workload.SpiffeWorkloadAPI_FetchJWTBundlesServer) error {
+	return s.w.stream(stream.Context(), s.w.jwtBundlesResponse, func(resp interface{}) error {
+		return stream.Send(resp.(*workload.JWTBundlesResponse))
+	})
+}
+
+func (s *workloadAPIServer) FetchJWTSVID(ctx context.Context, req *workload.JWTSVIDRequest) (*workload.JWTSVIDResponse, error) {
+	if err := checkSecurityHeader(ctx); err != nil {
+		return nil, err
+	}
+	if len(req.Audience) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "audience must be specified")
+	}
+
+	s.w.mu.Lock()
+	attested, svids, ttl := s.w.attested, s.w.svids, s.w.JWTSVIDTTL
+	s.w.mu.Unlock()
+	if !attested || len(svids) == 0 {
+		return nil, status.Error(codes.PermissionDenied, "no identity issued")
+	}
+
+	resp := &workload.JWTSVIDResponse{}
+	for _, svid := range svids {
+		if req.SpiffeId != "" && req.SpiffeId != svid.ID.String() {
+			continue
+		}
+		resp.Svids = append(resp.Svids, &workload.JWTSVID{
+			SpiffeId: svid.ID.String(),
+			Svid:     s.w.ca.CreateJWTToken(svid.ID.String(), req.Audience, ttl),
+			Hint:     svid.Hint,
+		})
+	}
+	if len(resp.Svids) == 0 {
+		return nil, status.Errorf(codes.PermissionDenied, "no identity issued for %s", req.SpiffeId)
+	}
+	return resp, nil
+}
+
+func (s *workloadAPIServer) ValidateJWTSVID(ctx context.Context, req *workload.ValidateJWTSVIDRequest) (*workload.ValidateJWTSVIDResponse, error) {
+	if err := checkSecurityHeader(ctx); err != nil {
+		return nil, err
+	}
+
+	svid, err := jwtsvid.ParseAndValidate(req.Svid, s.w.jwtBundles(), []string{req.Audience})
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	claims, err := structpb.NewStruct(svid.Claims)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &workload.ValidateJWTSVIDResponse{
+		SpiffeId: svid.ID.String(),
+		Claims:   claims,
+	}, nil
+}
+
+// checkSecurityHeader requires the metadata that Workload API clients must
+// send, like a real agent does
+func checkSecurityHeader(ctx context.Context) error {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md["workload.spiffe.io"]) != 1 || md["workload.spiffe.io"][0] != "true" {
+		return status.Error(codes.InvalidArgument, "security header missing from request")
+	}
+	return nil
+}
+
+func concatRaw(certs []*x509.Certificate) []byte {
+	var raw []byte
+	for _, cert := range certs {
+		raw = append(raw, cert.Raw...)
+	}
+	return raw
+}
@@ -0,0 +1,98 @@
+package spiffetest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	spiffesdk "github.com/authsec-ai/spiffe-sdk"
+	"github.com/authsec-ai/spiffe-sdk/spiffetest"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+func TestWorkloadAPIRotation(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	agent := spiffetest.NewWorkloadAPI(t, ca, paymentID)
+	headless := spiffetest.NewHeadlessServer(t, ca)
+
+	events := make(chan spiffesdk.Event, 64)
+	config := headlessConfig(headless)
+	config.SocketPath = agent.SocketPath
+	config.RenewalThreshold = 5 * time.Minute
+	config.CheckInterval = time.Minute
+	config.OnEvent = func(event spiffesdk.Event) {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+	sdk, err := spiffesdk.NewSpiffeSDK(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = sdk.Close(context.Background()) })
+	if err := sdk.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	initial := waitForSVID(t, sdk, nil)
+
+	// A rotation pushed by the agent replaces the SDK's SVID
+	agent.RotateX509SVIDs(time.Hour)
+	rotated := waitForSVID(t, sdk, initial)
+	if cache := sdk.GetCurrentSVID(); cache.Source != spiffesdk.SVIDSourceWorkloadAPI {
+		t.Errorf("SVID source = %s, want %s", cache.Source, spiffesdk.SVIDSourceWorkloadAPI)
+	}
+
+	// An SVID that doesn't chain to the bundle is rejected and the rotated one
+	// stays in use
+	agent.SetX509SVIDs(spiffetest.NewCA(t, "authsec.dev").CreateX509SVID(paymentID, 0))
+	waitForEvent(t, events, spiffesdk.EventSVIDRejected)
+	current, err := sdk.GetX509SVID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !current.Certificates[0].Equal(rotated.Certificates[0]) {
+		t.Error("rejected SVID replaced the current one")
+	}
+
+	// The agent goes away and comes back; rotations reach the SDK again
+	agent.Stop()
+	waitForEvent(t, events, spiffesdk.EventWorkloadAPIDisconnected)
+	agent.Start()
+	agent.RotateX509SVIDs(time.Hour)
+	waitForEvent(t, events, spiffesdk.EventWorkloadAPIConnected)
+	waitForSVID(t, sdk, rotated)
+}
+
+// waitForSVID waits until the SDK holds a Workload API SVID other than
+// previous and returns it
+func waitForSVID(t *testing.T, sdk *spiffesdk.SpiffeSDK, previous *x509svid.SVID) *x509svid.SVID {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		svid, err := sdk.GetX509SVID()
+		if err == nil && sdk.GetCurrentSVID().Source == spiffesdk.SVIDSourceWorkloadAPI &&
+			(previous == nil || !svid.Certificates[0].Equal(previous.Certificates[0])) {
+			return svid
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("SDK did not pick up the SVID from the Workload API")
+	return nil
+}
+
+func waitForEvent(t *testing.T, events <-chan spiffesdk.Event, eventType spiffesdk.EventType) spiffesdk.Event {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.Type == eventType {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("no %s event", eventType)
+		}
+	}
+}
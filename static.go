@@ -0,0 +1,119 @@
+package spiffesdk
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/spiffebundle"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+// NewStaticSDK creates an SDK that presents a fixed SVID and trusts a fixed
+// set of bundles, without contacting the headless API or the Workload API. It
+// is meant for tests (see the spiffetest package) and for workloads whose SVID
+// is provisioned out of band.
+//
+// bundles must contain the bundle of the SVID's trust domain; bundles of other
+// trust domains are treated as federated. config may be nil; its SPIFFEID and
+// TrustDomain are filled in from svid. Start does not fetch anything and the
+// SVID is never renewed.
+func NewStaticSDK(config *Config, svid *x509svid.SVID, bundles *spiffebundle.Set) (*SpiffeSDK, error) {
+	return newStaticSDK(config, svid, bundles, SVIDSourceStatic)
+}
+
+// newStaticSDK creates an SDK that never renews svid, reporting source as
+// where it came from
+func newStaticSDK(config *Config, svid *x509svid.SVID, bundles *spiffebundle.Set, source string) (*SpiffeSDK, error) {
+	if config == nil {
+		config = &Config{}
+	}
+	if len(config.Identities) > 0 {
+		return nil, errors.New("static SDKs support a single identity")
+	}
+	if err := config.adoptIdentity(svid.ID); err != nil {
+		return nil, err
+	}
+	td := svid.ID.TrustDomain()
+
+	localBundle, ok := bundles.Get(td)
+	if !ok {
+		return nil, fmt.Errorf("no bundle for the SVID's trust domain %s", td)
+	}
+
+	sdk, err := newSDK(config)
+	if err != nil {
+		return nil, err
+	}
+	sdk.static = true
+
+	certPEM, keyPEM, err := svid.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	bundlePEM, err := localBundle.X509Bundle().Marshal()
+	if err != nil {
+		return nil, err
+	}
+	if err := sdk.currentSVID.update(string(certPEM), string(keyPEM), string(bundlePEM), time.Time{}, source); err != nil {
+		return nil, err
+	}
+
+	sdk.updateTrustBundle(localBundle.X509Bundle(), source)
+	sdk.jwtCache.bundle = localBundle.JWTBundle()
+	for _, bundle := range bundles.Bundles() {
+		if bundle.TrustDomain() != td {
+			sdk.federatedBundles.Add(bundle)
+		}
+	}
+
+	// Static SDKs don't run background work, so the deny-list is loaded once
+	if config.hasDenyList() {
+		if err := sdk.loadDenyList(true); err != nil {
+			return nil, err
+		}
+	}
+
+	sdk.setupTLSConfig()
+	sdk.setState(StateReady)
+	return sdk, nil
+}
+
+// verifyCertificateLocally verifies a PEM SVID against the SDK's bundles
+func (s *SpiffeSDK) verifyCertificateLocally(certPEM string) (*ValidationResult, error) {
+	var certs []*x509.Certificate
+	rest := []byte(certPEM)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate to verify")
+	}
+
+	leaf := certs[0]
+	result := &ValidationResult{
+		Subject:   leaf.Subject.String(),
+		Issuer:    leaf.Issuer.String(),
+		NotBefore: leaf.NotBefore.Format(time.RFC3339),
+		NotAfter:  leaf.NotAfter.Format(time.RFC3339),
+	}
+
+	id, _, err := x509svid.Verify(certs, s)
+	if err != nil {
+		return result, nil
+	}
+	result.Valid = true
+	result.SPIFFEID = id.String()
+	return result, nil
+}
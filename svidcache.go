@@ -0,0 +1,242 @@
+package spiffesdk
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/authsec-ai/spiffe-sdk/internal/atomicfile"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+// bootstrapRetryInterval is how often registration is retried in the
+// background after starting from a cached SVID
+const bootstrapRetryInterval = 30 * time.Second
+
+// svidCacheVersion is the format version of the on-disk SVID cache
+const svidCacheVersion = 1
+
+// persistedSVID is the SVID material stored in the on-disk cache
+type persistedSVID struct {
+	SPIFFEID   string    `json:"spiffe_id"`
+	SVID       string    `json:"svid"`
+	PrivateKey string    `json:"private_key"`
+	Bundle     string    `json:"bundle"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	IssuedAt   time.Time `json:"issued_at"`
+}
+
+// svidCacheFile is the on-disk format. Encrypted caches hold the JSON encoded
+// persistedSVID sealed with AES-256-GCM in Ciphertext instead of SVID.
+type svidCacheFile struct {
+	Version    int            `json:"version"`
+	SVID       *persistedSVID `json:"svid,omitempty"`
+	Nonce      []byte         `json:"nonce,omitempty"`
+	Ciphertext []byte         `json:"ciphertext,omitempty"`
+}
+
+// loadSVIDCacheKey resolves the cache encryption key from SVIDCacheKeyEnv or
+// SVIDCacheKeyFile. It returns nil if no key is configured.
+func (c *Config) loadSVIDCacheKey() ([]byte, error) {
+	var encoded string
+	switch {
+	case c.SVIDCacheKeyEnv != "":
+		encoded = os.Getenv(c.SVIDCacheKeyEnv)
+		if encoded == "" {
+			return nil, fmt.Errorf("SVID cache key variable %s is not set", c.SVIDCacheKeyEnv)
+		}
+	case c.SVIDCacheKeyFile != "":
+		data, err := os.ReadFile(c.SVIDCacheKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SVID cache key: %w", err)
+		}
+		encoded = string(data)
+	default:
+		return nil, nil
+	}
+
+	encoded = strings.TrimSpace(encoded)
+	key, err := hex.DecodeString(encoded)
+	if err != nil {
+		key, err = base64.StdEncoding.DecodeString(encoded)
+	}
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("SVID cache key must be 32 bytes, hex or base64 encoded")
+	}
+	return key, nil
+}
+
+// persistSVID atomically writes the current SVID to the on-disk cache, if one
+// is configured. Failures are logged; the in-memory SVID stays authoritative.
+func (s *SpiffeSDK) persistSVID() {
+	if s.config.SVIDCachePath == "" {
+		return
+	}
+
+	s.currentSVID.mu.RLock()
+	svid := &persistedSVID{
+		SPIFFEID:   s.config.SPIFFEID,
+		SVID:       s.currentSVID.SVID,
+		PrivateKey: s.currentSVID.PrivateKey,
+		Bundle:     s.currentSVID.Bundle,
+		ExpiresAt:  s.currentSVID.ExpiresAt,
+		IssuedAt:   s.currentSVID.IssuedAt,
+	}
+	s.currentSVID.mu.RUnlock()
+
+	if err := writeSVIDCache(s.config.SVIDCachePath, svid, s.cacheKey); err != nil {
+		s.logf("Failed to write SVID cache: %v", err)
+	}
+}
+
+// loadCachedSVID installs the cached SVID if it exists, belongs to this
+// service and is still valid. It reports whether an SVID was installed.
+func (s *SpiffeSDK) loadCachedSVID() bool {
+	if s.config.SVIDCachePath == "" {
+		return false
+	}
+
+	svid, err := readSVIDCache(s.config.SVIDCachePath, s.cacheKey)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			s.logf("Ignoring SVID cache: %v", err)
+		}
+		return false
+	}
+
+	if svid.SPIFFEID != s.config.SPIFFEID {
+		s.logf("Ignoring SVID cache: it holds %s, not %s", svid.SPIFFEID, s.config.SPIFFEID)
+		return false
+	}
+	parsed, err := x509svid.Parse([]byte(svid.SVID), []byte(svid.PrivateKey))
+	if err != nil {
+		s.logf("Ignoring SVID cache: %v", err)
+		return false
+	}
+	if parsed.ID.String() != svid.SPIFFEID {
+		s.logf("Ignoring SVID cache: certificate is for %s", parsed.ID)
+		return false
+	}
+	expiresAt := parsed.Certificates[0].NotAfter
+	if !time.Now().Before(expiresAt) {
+		s.logf("Ignoring SVID cache: SVID expired at %v", expiresAt)
+		return false
+	}
+
+	if err := s.currentSVID.update(svid.SVID, svid.PrivateKey, svid.Bundle, svid.IssuedAt, SVIDSourceDiskCache); err != nil {
+		s.logf("Ignoring SVID cache: %v", err)
+		return false
+	}
+	s.syncTrustBundle(SVIDSourceDiskCache)
+
+	s.logf("Loaded cached SVID for %s, expires at: %v", svid.SPIFFEID, expiresAt)
+	return true
+}
+
+// retryBootstrap keeps trying to register and fetch a fresh SVID after the SDK
+// started from a cached SVID
+func (s *SpiffeSDK) retryBootstrap() {
+	for {
+		err := s.bootstrap()
+		if err == nil {
+			s.markReady()
+			s.logf("Registration completed, SVID expires at: %v", s.GetCurrentSVID().ExpiresAt)
+			return
+		}
+		s.logf("Background registration failed, using cached SVID: %v", err)
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(bootstrapRetryInterval):
+		}
+	}
+}
+
+func readSVIDCache(path string, key []byte) (*persistedSVID, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file svidCacheFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse SVID cache: %w", err)
+	}
+	if file.Version != svidCacheVersion {
+		return nil, fmt.Errorf("unsupported SVID cache version %d", file.Version)
+	}
+
+	if key == nil {
+		if file.SVID == nil {
+			return nil, errors.New("SVID cache is encrypted but no key is configured")
+		}
+		return file.SVID, nil
+	}
+
+	// With a key configured, plaintext caches are not trusted
+	if file.Ciphertext == nil {
+		return nil, errors.New("SVID cache is not encrypted")
+	}
+	aead, err := newSVIDCacheAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, file.Nonce, file.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt SVID cache: %w", err)
+	}
+
+	var svid persistedSVID
+	if err := json.Unmarshal(plaintext, &svid); err != nil {
+		return nil, fmt.Errorf("failed to parse SVID cache: %w", err)
+	}
+	return &svid, nil
+}
+
+func writeSVIDCache(path string, svid *persistedSVID, key []byte) error {
+	file := svidCacheFile{Version: svidCacheVersion}
+	if key == nil {
+		file.SVID = svid
+	} else {
+		plaintext, err := json.Marshal(svid)
+		if err != nil {
+			return err
+		}
+		aead, err := newSVIDCacheAEAD(key)
+		if err != nil {
+			return err
+		}
+		file.Nonce = make([]byte, aead.NonceSize())
+		if _, err := rand.Read(file.Nonce); err != nil {
+			return err
+		}
+		file.Ciphertext = aead.Seal(nil, file.Nonce, plaintext, nil)
+	}
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return atomicfile.Write(path, data, 0o600)
+}
+
+func newSVIDCacheAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
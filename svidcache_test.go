@@ -0,0 +1,199 @@
+package spiffesdk_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	spiffesdk "github.com/authsec-ai/spiffe-sdk"
+	"github.com/authsec-ai/spiffe-sdk/spiffetest"
+)
+
+func TestSVIDCacheEncryptedRoundTrip(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	headless := spiffetest.NewHeadlessServer(t, ca)
+	dir := t.TempDir()
+	withCache := svidCacheConfig(filepath.Join(dir, "svid.json"), writeCacheKey(t, dir, "key", 1))
+
+	first := newHeadlessSDK(t, headless, withCache)
+	if err := first.Start(); err != nil {
+		t.Fatal(err)
+	}
+	issued, err := first.GetX509SVID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "svid.json")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("SVID cache not written: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o600 {
+		t.Errorf("SVID cache mode = %o, want 600", mode)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "PRIVATE KEY") || !strings.Contains(string(data), "ciphertext") {
+		t.Error("SVID cache is not encrypted")
+	}
+
+	// A restart while the headless API is down comes up degraded on the
+	// cached SVID
+	failAllEndpoints(headless)
+	restarted := newHeadlessSDK(t, headless, withCache)
+	if err := restarted.Start(); err != nil {
+		t.Fatalf("Start() from the cache error = %v", err)
+	}
+	if state := restarted.State(); state != spiffesdk.StateDegraded {
+		t.Errorf("state = %s, want degraded", state)
+	}
+	cached, err := restarted.GetX509SVID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached.Certificates[0].SerialNumber.Cmp(issued.Certificates[0].SerialNumber) != 0 {
+		t.Error("restarted SDK does not present the cached SVID")
+	}
+	if source := restarted.GetCurrentSVID().Source; source != spiffesdk.SVIDSourceDiskCache {
+		t.Errorf("SVID source = %q, want %q", source, spiffesdk.SVIDSourceDiskCache)
+	}
+}
+
+func TestSVIDCacheRejectsWrongKeyAndTampering(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	headless := spiffetest.NewHeadlessServer(t, ca)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "svid.json")
+	key := writeCacheKey(t, dir, "key", 1)
+
+	first := newHeadlessSDK(t, headless, svidCacheConfig(path, key))
+	if err := first.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	failAllEndpoints(headless)
+
+	wrongKey := newHeadlessSDK(t, headless, svidCacheConfig(path, writeCacheKey(t, dir, "other-key", 2)))
+	if err := wrongKey.Start(); err == nil {
+		t.Error("SDK started from a cache encrypted with another key")
+	}
+
+	// Flip one bit of the ciphertext
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file map[string]interface{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(file["ciphertext"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext[len(ciphertext)/2] ^= 1
+	file["ciphertext"] = ciphertext
+	if data, err = json.Marshal(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tampered := newHeadlessSDK(t, headless, svidCacheConfig(path, key))
+	if err := tampered.Start(); err == nil {
+		t.Error("SDK started from a tampered cache")
+	}
+}
+
+func TestSVIDCacheIgnoresExpiredSVID(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	headless := spiffetest.NewHeadlessServer(t, ca)
+	failAllEndpoints(headless)
+	path := filepath.Join(t.TempDir(), "svid.json")
+
+	tests := []struct {
+		name      string
+		ttl       time.Duration
+		wantStart bool
+	}{
+		{"valid", time.Hour, true},
+		{"expired", -time.Hour, false},
+	}
+	for _, tt := range tests {
+		certPEM, keyPEM := marshalSVID(t, ca.CreateX509SVID(paymentID, tt.ttl))
+		writePlainSVIDCache(t, path, certPEM, keyPEM, marshalBundle(t, ca))
+
+		sdk := newHeadlessSDK(t, headless, svidCacheConfig(path, ""))
+		if err := sdk.Start(); (err == nil) != tt.wantStart {
+			t.Errorf("%s cached SVID: Start() error = %v, want started %v", tt.name, err, tt.wantStart)
+		}
+	}
+}
+
+// svidCacheConfig caches the SVID at path, encrypted with keyFile if set
+func svidCacheConfig(path, keyFile string) func(*spiffesdk.Config) {
+	return func(config *spiffesdk.Config) {
+		config.SVIDCachePath = path
+		config.SVIDCacheKeyFile = keyFile
+	}
+}
+
+// writeCacheKey writes a hex encoded 32-byte key filled with b
+func writeCacheKey(t *testing.T, dir, name string, b byte) string {
+	t.Helper()
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = b
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writePlainSVIDCache writes an unencrypted cache in the on-disk format
+func writePlainSVIDCache(t *testing.T, path, certPEM, keyPEM, bundlePEM string) {
+	t.Helper()
+	data, err := json.Marshal(map[string]interface{}{
+		"version": 1,
+		"svid": map[string]string{
+			"spiffe_id":   paymentID,
+			"svid":        certPEM,
+			"private_key": keyPEM,
+			"bundle":      bundlePEM,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// failAllEndpoints makes every endpoint of the headless API fail
+func failAllEndpoints(headless *spiffetest.HeadlessServer) {
+	for _, endpoint := range []spiffetest.Endpoint{
+		spiffetest.EndpointRegister, spiffetest.EndpointListWorkloads, spiffetest.EndpointGetWorkload,
+		spiffetest.EndpointDeleteWorkload, spiffetest.EndpointIssueSVID, spiffetest.EndpointIssueJWTSVID,
+		spiffetest.EndpointJWTBundle, spiffetest.EndpointX509Bundle, spiffetest.EndpointDenyList,
+		spiffetest.EndpointVerify,
+	} {
+		headless.InjectFault(endpoint, spiffetest.Fault{StatusCode: 503})
+	}
+}
@@ -0,0 +1,59 @@
+package spiffesdk
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+// maxClockSkew is how far in the future a new SVID's NotBefore may be, to
+// tolerate clocks that are slightly behind the issuer's
+const maxClockSkew = time.Minute
+
+// VerifySVID checks an SVID before it is used or written out. It must be for
+// expectedID (unless empty), be valid now and chain to bundle. The private key
+// is not checked here; x509svid.Parse already matches it against the leaf.
+func VerifySVID(svid *x509svid.SVID, bundle *x509bundle.Bundle, expectedID string) error {
+	if expectedID != "" && svid.ID.String() != expectedID {
+		return fmt.Errorf("SVID is for %s, not %s", svid.ID, expectedID)
+	}
+
+	leaf := svid.Certificates[0]
+	now := time.Now()
+	if !leaf.NotAfter.After(leaf.NotBefore) {
+		return fmt.Errorf("SVID validity period is empty (%v to %v)", leaf.NotBefore, leaf.NotAfter)
+	}
+	if !now.Before(leaf.NotAfter) {
+		return fmt.Errorf("SVID expired at %v", leaf.NotAfter)
+	}
+	if leaf.NotBefore.After(now.Add(maxClockSkew)) {
+		return fmt.Errorf("SVID is not valid until %v", leaf.NotBefore)
+	}
+
+	// Verify within the validity period, so small clock skew isn't fatal
+	verifyAt := now
+	if verifyAt.Before(leaf.NotBefore) {
+		verifyAt = leaf.NotBefore
+	}
+	if _, _, err := x509svid.Verify(svid.Certificates, bundle, x509svid.WithTime(verifyAt)); err != nil {
+		return fmt.Errorf("SVID does not chain to the trust bundle: %w", err)
+	}
+	return nil
+}
+
+// rejectSVID reports a new SVID that failed verification. The previous SVID
+// stays in use.
+func (s *SpiffeSDK) rejectSVID(spiffeID, source string, err error) {
+	metrics.Add("svid_rejections_total", 1)
+	s.emit(Event{
+		Type:    EventSVIDRejected,
+		Message: fmt.Sprintf("Rejected SVID for %s from %s, keeping the previous one", spiffeID, source),
+		Err:     err,
+		Attributes: map[string]string{
+			"spiffe_id": spiffeID,
+			"source":    source,
+		},
+	})
+}
@@ -0,0 +1,113 @@
+package spiffesdk_test
+
+import (
+	"testing"
+	"time"
+
+	spiffesdk "github.com/authsec-ai/spiffe-sdk"
+	"github.com/authsec-ai/spiffe-sdk/spiffetest"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+const paymentID = "spiffe://authsec.dev/payment-service"
+
+func TestVerifySVID(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	other := spiffetest.NewCA(t, "authsec.dev")
+
+	tests := []struct {
+		name       string
+		svid       *x509svid.SVID
+		expectedID string
+		wantErr    bool
+	}{
+		{"valid", ca.CreateX509SVID(paymentID, 0), paymentID, false},
+		{"any ID", ca.CreateX509SVID(paymentID, 0), "", false},
+		{"wrong ID", ca.CreateX509SVID("spiffe://authsec.dev/customer-service", 0), paymentID, true},
+		{"untrusted chain", other.CreateX509SVID(paymentID, 0), paymentID, true},
+		{"expired", ca.CreateX509SVID(paymentID, -time.Minute), paymentID, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := spiffesdk.VerifySVID(tt.svid, ca.X509Bundle(), tt.expectedID)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifySVID() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSVIDCacheUpdate(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	other := spiffetest.NewCA(t, "authsec.dev")
+	bundlePEM := marshalBundle(t, ca)
+
+	cache := spiffesdk.NewSVIDCache(paymentID)
+	current := ca.CreateX509SVID(paymentID, 0)
+	certPEM, keyPEM := marshalSVID(t, current)
+	if err := cache.Update(certPEM, keyPEM, bundlePEM, spiffesdk.SVIDSourceHeadlessAPI); err != nil {
+		t.Fatalf("valid SVID rejected: %v", err)
+	}
+
+	wrongIDCert, wrongIDKey := marshalSVID(t, ca.CreateX509SVID("spiffe://authsec.dev/customer-service", 0))
+	untrustedCert, untrustedKey := marshalSVID(t, other.CreateX509SVID(paymentID, 0))
+	expiredCert, expiredKey := marshalSVID(t, ca.CreateX509SVID(paymentID, -time.Minute))
+	freshCert, _ := marshalSVID(t, ca.CreateX509SVID(paymentID, 0))
+	_, otherKey := marshalSVID(t, ca.CreateX509SVID(paymentID, 0))
+
+	tests := []struct {
+		name                       string
+		certPEM, keyPEM, bundlePEM string
+	}{
+		{"wrong ID", wrongIDCert, wrongIDKey, bundlePEM},
+		{"untrusted chain", untrustedCert, untrustedKey, bundlePEM},
+		{"key does not match certificate", freshCert, otherKey, bundlePEM},
+		{"expired", expiredCert, expiredKey, bundlePEM},
+		{"invalid bundle", freshCert, keyPEM, "not a bundle"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := cache.Update(tt.certPEM, tt.keyPEM, tt.bundlePEM, spiffesdk.SVIDSourceWorkloadAPI); err == nil {
+				t.Fatal("Update() accepted the SVID")
+			}
+
+			// The rejected SVID must not replace the current one
+			svid, err := cache.X509SVID()
+			if err != nil {
+				t.Fatalf("X509SVID() error = %v", err)
+			}
+			if !svid.Certificates[0].Equal(current.Certificates[0]) {
+				t.Error("previous SVID was replaced")
+			}
+			if cache.SVID != certPEM || cache.PrivateKey != keyPEM || cache.Source != spiffesdk.SVIDSourceHeadlessAPI {
+				t.Error("cached PEM or source changed after rejection")
+			}
+		})
+	}
+
+	rotatedCert, rotatedKey := marshalSVID(t, ca.CreateX509SVID(paymentID, 0))
+	if err := cache.Update(rotatedCert, rotatedKey, bundlePEM, spiffesdk.SVIDSourceWorkloadAPI); err != nil {
+		t.Fatalf("rotated SVID rejected: %v", err)
+	}
+	if cache.SVID != rotatedCert || cache.Source != spiffesdk.SVIDSourceWorkloadAPI {
+		t.Error("rotated SVID was not installed")
+	}
+}
+
+func marshalSVID(t *testing.T, svid *x509svid.SVID) (string, string) {
+	t.Helper()
+	certPEM, keyPEM, err := svid.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(certPEM), string(keyPEM)
+}
+
+func marshalBundle(t *testing.T, ca *spiffetest.CA) string {
+	t.Helper()
+	bundlePEM, err := ca.X509Bundle().Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(bundlePEM)
+}
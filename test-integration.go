@@ -1,3 +1,8 @@
+//go:build ignore
+
+// This script lives next to package spiffesdk but is package main, so it is
+// excluded from the build and run directly with: go run test-integration.go
+
 package main
 
 import (
@@ -7,7 +12,7 @@ import (
 	"net/http"
 	"time"
 
-	spiffesdk "spiffe-service/sdk" // Local import path
+	spiffesdk "github.com/authsec-ai/spiffe-sdk"
 )
 
 // Test Integration Script
@@ -31,11 +36,11 @@ func testCustomerServiceIntegration() {
 	fmt.Println("\n📋 Test 1: Customer Service Integration")
 
 	config := &spiffesdk.Config{
-		ServiceName:     "test-customer-service",
-		SPIFFEID:        "spiffe://authsec.dev/test-customer-service",
-		ServiceType:     "application",
-		Namespace:       "authsec",
-		ServiceAccount:  "authsec-sa",
+		ServiceName:    "test-customer-service",
+		SPIFFEID:       "spiffe://authsec.dev/test-customer-service",
+		ServiceType:    "application",
+		Namespace:      "authsec",
+		ServiceAccount: "authsec-sa",
 		PodLabels: map[string]string{
 			"app": "test-customer-service",
 		},
@@ -51,7 +56,7 @@ func testCustomerServiceIntegration() {
 		log.Printf("❌ Failed to create SDK for customer service: %v", err)
 		return
 	}
-	defer sdk.Close()
+	defer sdk.Close(context.Background())
 
 	// Test registration and initial SVID
 	if err := sdk.Initialize(); err != nil {
@@ -72,11 +77,11 @@ func testPaymentServiceIntegration() {
 	fmt.Println("\n💳 Test 2: Payment Service Integration")
 
 	config := &spiffesdk.Config{
-		ServiceName:     "test-payment-service",
-		SPIFFEID:        "spiffe://authsec.dev/test-payment-service",
-		ServiceType:     "application",
-		Namespace:       "authsec",
-		ServiceAccount:  "authsec-sa",
+		ServiceName:    "test-payment-service",
+		SPIFFEID:       "spiffe://authsec.dev/test-payment-service",
+		ServiceType:    "application",
+		Namespace:      "authsec",
+		ServiceAccount: "authsec-sa",
 		PodLabels: map[string]string{
 			"app": "test-payment-service",
 		},
@@ -92,7 +97,7 @@ func testPaymentServiceIntegration() {
 		log.Printf("❌ Failed to create SDK for payment service: %v", err)
 		return
 	}
-	defer sdk.Close()
+	defer sdk.Close(context.Background())
 
 	if err := sdk.Initialize(); err != nil {
 		log.Printf("❌ Failed to initialize payment service SDK: %v", err)
@@ -125,7 +130,7 @@ func testCrossServiceCommunication() {
 		log.Printf("❌ Failed to create customer SDK: %v", err)
 		return
 	}
-	defer customerSDK.Close()
+	defer customerSDK.Close(context.Background())
 
 	if err := customerSDK.Initialize(); err != nil {
 		log.Printf("❌ Failed to initialize customer SDK: %v", err)
@@ -165,7 +170,7 @@ func demonstrateMiddleware() {
 		log.Printf("❌ Failed to create demo SDK: %v", err)
 		return
 	}
-	defer sdk.Close()
+	defer sdk.Close(context.Background())
 
 	if err := sdk.Initialize(); err != nil {
 		log.Printf("❌ Failed to initialize demo SDK: %v", err)
@@ -176,7 +181,7 @@ func demonstrateMiddleware() {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/secure", func(w http.ResponseWriter, r *http.Request) {
 		// Extract authenticated caller's SPIFFE ID
-		spiffeID := r.Context().Value("spiffe_id")
+		spiffeID, _ := spiffesdk.SPIFFEIDFromContext(r.Context())
 
 		w.Header().Set("Content-Type", "application/json")
 		fmt.Fprintf(w, `{
@@ -193,10 +198,6 @@ func demonstrateMiddleware() {
 	fmt.Println("🔐 All incoming requests will be authenticated via SPIFFE certificates")
 
 	// In production, you would start the server:
-	// server := &http.Server{
-	//     Addr:      ":8080",
-	//     Handler:   protectedHandler,
-	//     TLSConfig: sdk.GetHTTPClient().Transport.(*http.Transport).TLSClientConfig,
-	// }
-	// log.Fatal(server.ListenAndServeTLS("", ""))
-}
\ No newline at end of file
+	// log.Fatal(sdk.Serve(ctx, ":8080", protectedHandler, spiffesdk.WithHealthProbes(":8081")))
+	_ = protectedHandler
+}
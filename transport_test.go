@@ -0,0 +1,150 @@
+package spiffesdk_test
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	spiffesdk "github.com/authsec-ai/spiffe-sdk"
+	"github.com/authsec-ai/spiffe-sdk/spiffetest"
+)
+
+func TestRoutedHTTPClientPinsServerID(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	client := ca.NewSDK(t, clientID)
+	payments := spiffetest.NewServer(t, ca.NewSDK(t, paymentID), okHandler())
+	impostor := spiffetest.NewServer(t, ca.NewSDK(t, deniedID), okHandler())
+	external := httptest.NewServer(okHandler())
+	t.Cleanup(external.Close)
+
+	// Servers listen on 127.0.0.1; "localhost" reaches them through the
+	// internal domains only
+	httpClient, err := client.NewRoutedHTTPClient(
+		[]spiffesdk.Route{{Host: "127.0.0.1", ExpectedID: paymentID}},
+		[]string{"localhost"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := getWith(httpClient, payments.URL); err != nil {
+		t.Errorf("pinned server rejected: %v", err)
+	}
+	if err := getWith(httpClient, impostor.URL); err == nil {
+		t.Error("server with the wrong SPIFFE ID accepted on a pinned route")
+	}
+	if err := getWith(httpClient, strings.Replace(impostor.URL, "127.0.0.1", "localhost", 1)); err != nil {
+		t.Errorf("internal domain rejected an SVID from the trust bundle: %v", err)
+	}
+
+	// Hosts matching neither routes nor internal domains use plain HTTP
+	externalClient, err := client.NewRoutedHTTPClient(
+		[]spiffesdk.Route{{Host: "payments.svc.cluster.local", ExpectedID: paymentID}},
+		[]string{".svc.cluster.local"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := getWith(externalClient, external.URL); err != nil {
+		t.Errorf("external request failed: %v", err)
+	}
+
+	if _, err := client.NewRoutedHTTPClient([]spiffesdk.Route{{Host: "payments", ExpectedID: "not-an-id"}}, nil); err == nil {
+		t.Error("route with an invalid SPIFFE ID accepted")
+	}
+	if _, err := client.NewRoutedHTTPClient([]spiffesdk.Route{{ExpectedID: paymentID}}, nil); err == nil {
+		t.Error("route without a host accepted")
+	}
+}
+
+func TestAttachSVIDKeepsTransportSettings(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	client := ca.NewSDK(t, clientID)
+
+	proxyURL, _ := url.Parse("http://proxy.internal:3128")
+	base := &http.Transport{
+		Proxy:                 http.ProxyURL(proxyURL),
+		TLSHandshakeTimeout:   7 * time.Second,
+		ResponseHeaderTimeout: 9 * time.Second,
+		IdleConnTimeout:       42 * time.Second,
+		MaxIdleConnsPerHost:   3,
+		ForceAttemptHTTP2:     true,
+		TLSClientConfig:       &tls.Config{MinVersion: tls.VersionTLS13},
+	}
+	transport, err := client.AttachSVID(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if transport == base {
+		t.Fatal("AttachSVID() returned the caller's transport instead of a clone")
+	}
+	if base.TLSClientConfig.GetClientCertificate != nil {
+		t.Error("AttachSVID() modified the caller's TLS config")
+	}
+	if got, err := transport.Proxy(httptest.NewRequest("GET", "https://payments/", nil)); err != nil || got.String() != proxyURL.String() {
+		t.Errorf("Proxy = %v, %v, want %v", got, err, proxyURL)
+	}
+	if transport.TLSHandshakeTimeout != base.TLSHandshakeTimeout ||
+		transport.ResponseHeaderTimeout != base.ResponseHeaderTimeout ||
+		transport.IdleConnTimeout != base.IdleConnTimeout ||
+		transport.MaxIdleConnsPerHost != base.MaxIdleConnsPerHost {
+		t.Error("timeouts or pooling settings were not kept")
+	}
+	if !transport.ForceAttemptHTTP2 {
+		t.Error("ForceAttemptHTTP2 was not kept")
+	}
+	if transport.TLSClientConfig.MinVersion != tls.VersionTLS13 {
+		t.Error("MinVersion was not kept")
+	}
+	if transport.TLSClientConfig.GetClientCertificate == nil {
+		t.Error("cloned transport does not present the SVID")
+	}
+}
+
+func TestOutgoingAttachmentMiddlewarePresentsSVID(t *testing.T) {
+	ca := spiffetest.NewCA(t, "authsec.dev")
+	srv := spiffetest.NewServer(t, ca.NewSDK(t, paymentID), callerHandler(), spiffesdk.WithIncomingValidation())
+	client := ca.NewSDK(t, clientID)
+
+	httpClient := &http.Client{Transport: client.OutgoingAttachmentMiddleware(&http.Transport{ForceAttemptHTTP2: true})}
+	resp, err := httpClient.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != clientID {
+		t.Errorf("got %d %q, want 200 %q", resp.StatusCode, body, clientID)
+	}
+
+	// Other RoundTrippers can't carry a client certificate and fail instead
+	// of sending requests without an SVID
+	failing := &http.Client{Transport: client.OutgoingAttachmentMiddleware(roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		t.Error("request sent without an SVID")
+		return nil, nil
+	}))}
+	if resp, err := failing.Get(srv.URL); err == nil {
+		resp.Body.Close()
+		t.Error("request through an unsupported RoundTripper succeeded")
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// callerHandler echoes the caller's SPIFFE ID
+func callerHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := spiffesdk.SPIFFEIDFromContext(r.Context())
+		_, _ = io.WriteString(w, id)
+	})
+}
@@ -0,0 +1,270 @@
+package spiffesdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+)
+
+const (
+	// workloadAPIStartTimeout is how long NewSpiffeSDK and Initialize wait for
+	// the first Workload API update before carrying on with the headless API
+	workloadAPIStartTimeout = 5 * time.Second
+
+	// workloadAPIRetryMin and workloadAPIRetryMax bound the backoff between
+	// watches the Workload API client gave up on. While the socket is merely
+	// missing or the agent is down, the client retries on its own.
+	workloadAPIRetryMin = time.Second
+	workloadAPIRetryMax = time.Minute
+)
+
+// initWorkloadAPI starts the Workload API supervisor, if it isn't running yet,
+// and waits briefly for the first SVID. An error only means the agent isn't
+// reachable yet; the supervisor keeps trying and switches over when it is.
+func (s *SpiffeSDK) initWorkloadAPI() error {
+	if s.config.SocketPath == "" {
+		return errors.New("no Workload API socket configured")
+	}
+	s.workloadOnce.Do(func() {
+		s.goBackground(s.superviseWorkloadAPI)
+	})
+
+	timer := time.NewTimer(workloadAPIStartTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-s.workloadReady:
+		if !s.hasWorkloadAPI() {
+			return errors.New("Workload API is disconnected")
+		}
+		return nil
+	case <-timer.C:
+		return errors.New("timed out waiting for the Workload API")
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+// hasWorkloadAPI reports whether the Workload API is connected
+func (s *SpiffeSDK) hasWorkloadAPI() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.workloadBundles != nil
+}
+
+// activeJWTSource returns the Workload API JWT source while the Workload API
+// is connected, or nil if JWT-SVIDs should come from the headless API
+func (s *SpiffeSDK) activeJWTSource() *workloadapi.JWTSource {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.workloadBundles == nil {
+		return nil
+	}
+	return s.jwtSource
+}
+
+// superviseWorkloadAPI watches the Workload API until the SDK is closed,
+// restarting the watch with backoff whenever it ends
+func (s *SpiffeSDK) superviseWorkloadAPI() {
+	backoff := workloadAPIRetryMin
+	for {
+		client, err := workloadapi.New(s.ctx, workloadapi.WithAddr("unix://"+s.config.SocketPath))
+		if err == nil {
+			err = client.WatchX509Context(s.ctx, workloadAPIWatcher{s})
+			_ = client.Close()
+		}
+		if s.ctx.Err() != nil {
+			return
+		}
+
+		s.logf("Workload API watch stopped, retrying in %v: %v", backoff, err)
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > workloadAPIRetryMax {
+			backoff = workloadAPIRetryMax
+		}
+	}
+}
+
+// workloadAPIWatcher receives X.509 context updates for the supervisor
+type workloadAPIWatcher struct {
+	s *SpiffeSDK
+}
+
+func (w workloadAPIWatcher) OnX509ContextUpdate(x509Context *workloadapi.X509Context) {
+	w.s.onWorkloadAPIUpdate(x509Context)
+}
+
+func (w workloadAPIWatcher) OnX509ContextWatchError(err error) {
+	w.s.onWorkloadAPIError(err)
+}
+
+// onWorkloadAPIUpdate switches the SDK to the SVID and bundles streamed by the
+// Workload API
+func (s *SpiffeSDK) onWorkloadAPIUpdate(x509Context *workloadapi.X509Context) {
+	svid, err := pickSVID(x509Context.SVIDs, s.config.SPIFFEID, s.config.SVIDHint)
+	if err == nil {
+		// The agent pushes CA rotations even when the SVID is unchanged
+		if bundle, ok := x509Context.Bundles.Get(svid.ID.TrustDomain()); ok {
+			s.updateTrustBundle(bundle, SVIDSourceWorkloadAPI)
+		}
+		err = s.installSVID(svid, x509Context.Bundles, SVIDSourceWorkloadAPI)
+	}
+	if err != nil {
+		s.logf("Ignoring Workload API update: %v", err)
+		return
+	}
+
+	// Additional identities the agent doesn't issue keep their headless SVIDs
+	for _, identity := range s.identities {
+		svid, err := pickSVID(x509Context.SVIDs, identity.SPIFFEID, identity.Hint)
+		if err == nil {
+			if _, err = identity.svid.install(svid, x509Context.Bundles, SVIDSourceWorkloadAPI); err != nil {
+				s.rejectSVID(identity.SPIFFEID, SVIDSourceWorkloadAPI, err)
+			}
+		}
+		if err != nil {
+			s.logf("Ignoring Workload API SVID for %s: %v", identity.SPIFFEID, err)
+		}
+	}
+
+	s.mu.Lock()
+	connected := s.workloadBundles == nil
+	s.workloadBundles = x509Context.Bundles
+	select {
+	case <-s.workloadReady:
+	default:
+		close(s.workloadReady)
+	}
+	startJWTSource := connected && s.jwtSource == nil
+	s.mu.Unlock()
+
+	s.markReady()
+	if connected {
+		s.emit(Event{
+			Type:    EventWorkloadAPIConnected,
+			Message: fmt.Sprintf("Workload API connected, SVID expires at %v", svid.Certificates[0].NotAfter),
+			Attributes: map[string]string{
+				"spiffe_id": svid.ID.String(),
+			},
+		})
+	}
+	if startJWTSource {
+		s.goBackground(s.startJWTSource)
+	}
+}
+
+// onWorkloadAPIError falls back to the headless API when an established
+// Workload API stream breaks. Errors while still connecting are left to the
+// client's retries.
+func (s *SpiffeSDK) onWorkloadAPIError(err error) {
+	if s.ctx.Err() != nil {
+		return
+	}
+
+	s.mu.Lock()
+	connected := s.workloadBundles != nil
+	s.workloadBundles = nil
+	s.mu.Unlock()
+	if !connected {
+		return
+	}
+
+	metrics.Add("workload_api_disconnects_total", 1)
+	s.emit(Event{
+		Type:    EventWorkloadAPIDisconnected,
+		Message: "Workload API stream broke",
+		Err:     err,
+	})
+	s.markDegraded()
+	s.goBackground(s.fallBackToHeadless)
+}
+
+// fallBackToHeadless replaces the SVID streamed by the Workload API with one
+// issued by the headless API. Without a headless API the last SVID is kept.
+func (s *SpiffeSDK) fallBackToHeadless() {
+	if s.config.HeadlessAPIURL == "" {
+		s.emit(Event{
+			Type:    EventHeadlessFallbackFailed,
+			Message: "no headless API configured, keeping the last Workload API SVID",
+		})
+		return
+	}
+
+	if err := s.refreshSVID(); err != nil {
+		s.emit(Event{
+			Type:    EventHeadlessFallbackFailed,
+			Message: "keeping the last Workload API SVID",
+			Err:     err,
+		})
+		return
+	}
+	for _, identity := range s.identities {
+		if err := s.refreshIdentitySVID(identity); err != nil {
+			s.emit(Event{
+				Type:    EventHeadlessFallbackFailed,
+				Message: "keeping the last Workload API SVID for " + identity.SPIFFEID,
+				Err:     err,
+			})
+		}
+	}
+
+	s.markReady()
+	current := s.GetCurrentSVID()
+	s.emit(Event{
+		Type:    EventHeadlessFallback,
+		Message: fmt.Sprintf("Using SVID from headless API, expires at %v", current.ExpiresAt),
+		Attributes: map[string]string{
+			"source": current.Source,
+		},
+	})
+}
+
+// pickSVID returns the Workload API SVID for spiffeID with the given hint.
+// Empty values match any SVID, so with neither the default SVID is returned.
+func pickSVID(svids []*x509svid.SVID, spiffeID, hint string) (*x509svid.SVID, error) {
+	if len(svids) == 0 {
+		return nil, errors.New("Workload API returned no SVIDs")
+	}
+	for _, svid := range svids {
+		if (spiffeID == "" || svid.ID.String() == spiffeID) && (hint == "" || svid.Hint == hint) {
+			return svid, nil
+		}
+	}
+	if hint != "" {
+		return nil, fmt.Errorf("Workload API issued no SVID for %s with hint %q", spiffeID, hint)
+	}
+	return nil, fmt.Errorf("Workload API issued no SVID for %s", spiffeID)
+}
+
+// startJWTSource connects a JWT source once the Workload API is reachable.
+// JWT-SVIDs are optional; without a JWT source they come from the headless API.
+func (s *SpiffeSDK) startJWTSource() {
+	ctx, cancel := context.WithTimeout(s.ctx, workloadAPIStartTimeout)
+	defer cancel()
+
+	source, err := workloadapi.NewJWTSource(ctx, workloadapi.WithClientOptions(
+		workloadapi.WithAddr("unix://"+s.config.SocketPath),
+	))
+	if err != nil {
+		s.logf("Workload API JWT source unavailable, using headless API for JWT-SVIDs: %v", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx.Err() != nil {
+		_ = source.Close()
+		return
+	}
+	s.jwtSource = source
+}